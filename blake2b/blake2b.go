@@ -12,6 +12,14 @@
 // If you need a secret-key MAC (message authentication code), use the New512
 // function with a non-nil key.
 //
+// The hashes returned by New512, New384 and New256 restore their keyed
+// initial state on Reset and also implement
+//
+//	Clone() hash.Hash
+//
+// which copies the current state. Cloning a freshly created keyed hash
+// avoids repeating the key setup for every message.
+//
 // BLAKE2X is a construction to compute hash values larger than 64 bytes. It
 // can produce hash values between 0 and 4 GiB.
 package blake2b
@@ -150,6 +158,13 @@ func (d *digest) Reset() {
 	}
 }
 
+// Clone returns a copy of the hash in its current state, including
+// the key.
+func (d *digest) Clone() hash.Hash {
+	clone := *d
+	return &clone
+}
+
 func (d *digest) Write(p []byte) (n int, err error) {
 	n = len(p)
 
@@ -217,6 +217,57 @@ func computeHash(msg []byte, hashSize int) (sum []byte) {
 	}
 }
 
+func TestCloneAndReset(t *testing.T) {
+	key := []byte("a secret key")
+	msg1 := bytes.Repeat([]byte("first message "), 20)
+	msg2 := []byte("second message")
+
+	want := func(msg ...[]byte) []byte {
+		h, err := New512(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range msg {
+			h.Write(m)
+		}
+		return h.Sum(nil)
+	}
+
+	h, err := New512(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyed := h.(interface {
+		Clone() hash.Hash
+	})
+
+	c1 := keyed.Clone()
+	c1.Write(msg1)
+	c2 := keyed.Clone()
+	c2.Write(msg2)
+	if got := c1.Sum(nil); !bytes.Equal(got, want(msg1)) {
+		t.Errorf("clone of keyed state: got %x, want %x", got, want(msg1))
+	}
+	if got := c2.Sum(nil); !bytes.Equal(got, want(msg2)) {
+		t.Errorf("clone of keyed state: got %x, want %x", got, want(msg2))
+	}
+
+	// Clone in the middle of a message.
+	mid := c1.(interface {
+		Clone() hash.Hash
+	}).Clone()
+	mid.Write(msg2)
+	if got := mid.Sum(nil); !bytes.Equal(got, want(msg1, msg2)) {
+		t.Errorf("mid-stream clone: got %x, want %x", got, want(msg1, msg2))
+	}
+
+	c1.Reset()
+	c1.Write(msg2)
+	if got := c1.Sum(nil); !bytes.Equal(got, want(msg2)) {
+		t.Errorf("after Reset: got %x, want %x", got, want(msg2))
+	}
+}
+
 // Test function from RFC 7693.
 func TestSelfTest(t *testing.T) {
 	hashLens := [4]int{20, 32, 48, 64}
@@ -318,30 +318,38 @@ type Request struct {
 
 // Marshal marshals the OCSP request to ASN.1 DER encoded form.
 func (req *Request) Marshal() ([]byte, error) {
-	hashAlg := getOIDFromHashAlgorithm(req.HashAlgorithm)
-	if hashAlg == nil {
-		return nil, errors.New("Unknown hash algorithm")
+	id, err := req.certID()
+	if err != nil {
+		return nil, err
 	}
 	return asn1.Marshal(ocspRequest{
 		tbsRequest{
 			Version: 0,
 			RequestList: []request{
 				{
-					Cert: certID{
-						pkix.AlgorithmIdentifier{
-							Algorithm:  hashAlg,
-							Parameters: asn1.RawValue{Tag: 5 /* ASN.1 NULL */},
-						},
-						req.IssuerNameHash,
-						req.IssuerKeyHash,
-						req.SerialNumber,
-					},
+					Cert: id,
 				},
 			},
 		},
 	})
 }
 
+func (req *Request) certID() (certID, error) {
+	hashAlg := getOIDFromHashAlgorithm(req.HashAlgorithm)
+	if hashAlg == nil {
+		return certID{}, errors.New("Unknown hash algorithm")
+	}
+	return certID{
+		pkix.AlgorithmIdentifier{
+			Algorithm:  hashAlg,
+			Parameters: asn1.RawValue{Tag: 5 /* ASN.1 NULL */},
+		},
+		req.IssuerNameHash,
+		req.IssuerKeyHash,
+		req.SerialNumber,
+	}, nil
+}
+
 // Response represents an OCSP response containing a single SingleResponse. See
 // RFC 6960.
 type Response struct {
@@ -446,7 +454,8 @@ func ParseRequest(bytes []byte) (*Request, error) {
 }
 
 // ParseResponse parses an OCSP response in DER form. It only supports
-// responses for a single certificate. If the response contains a certificate
+// responses for a single certificate; see ParseBatchResponse for responses
+// covering several. If the response contains a certificate
 // then the signature over the response is checked. If issuer is not nil then
 // it will be used to validate the signature or embedded certificate.
 //
@@ -465,32 +474,10 @@ func ParseResponse(bytes []byte, issuer *x509.Certificate) (*Response, error) {
 // Invalid responses and parse failures will result in a ParseError.
 // Error responses will result in a ResponseError.
 func ParseResponseForCert(bytes []byte, cert, issuer *x509.Certificate) (*Response, error) {
-	var resp responseASN1
-	rest, err := asn1.Unmarshal(bytes, &resp)
+	basicResp, err := parseBasicResponse(bytes)
 	if err != nil {
 		return nil, err
 	}
-	if len(rest) > 0 {
-		return nil, ParseError("trailing data in OCSP response")
-	}
-
-	if status := ResponseStatus(resp.Status); status != Success {
-		return nil, ResponseError{status}
-	}
-
-	if !resp.Response.ResponseType.Equal(idPKIXOCSPBasic) {
-		return nil, ParseError("bad OCSP response type")
-	}
-
-	var basicResp basicResponse
-	rest, err = asn1.Unmarshal(resp.Response.Response, &basicResp)
-	if err != nil {
-		return nil, err
-	}
-
-	if len(basicResp.Certificates) > 1 {
-		return nil, ParseError("OCSP response contains bad number of certificates")
-	}
 
 	if n := len(basicResp.TBSResponseData.Responses); n == 0 || cert == nil && n > 1 {
 		return nil, ParseError("OCSP response contains bad number of responses")
@@ -513,15 +500,97 @@ func ParseResponseForCert(bytes []byte, cert, issuer *x509.Certificate) (*Respon
 		}
 	}
 
+	ret, err := newResponse(basicResp, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if err := ret.setSingleResponse(&singleResp); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ParseBatchResponse parses an OCSP response in DER form that may contain
+// responses for several certificates, such as the reply to a request created
+// by CreateBatchRequest. The responses are keyed by the CertID they were
+// issued for; use NewCertID with the hash function of the request to look up
+// the status of a given certificate. If the response contains a certificate
+// then the signature over the response is checked. If issuer is not nil then
+// it will be used to validate the signature or embedded certificate.
+//
+// Invalid responses and parse failures will result in a ParseError.
+// Error responses will result in a ResponseError.
+func ParseBatchResponse(bytes []byte, issuer *x509.Certificate) (map[CertID]*Response, error) {
+	basicResp, err := parseBasicResponse(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(basicResp.TBSResponseData.Responses) == 0 {
+		return nil, ParseError("OCSP response contains bad number of responses")
+	}
+
+	template, err := newResponse(basicResp, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[CertID]*Response, len(basicResp.TBSResponseData.Responses))
+	for i := range basicResp.TBSResponseData.Responses {
+		singleResp := &basicResp.TBSResponseData.Responses[i]
+		resp := new(Response)
+		*resp = *template
+		if err := resp.setSingleResponse(singleResp); err != nil {
+			return nil, err
+		}
+		id := certIDFromASN1(resp.IssuerHash, &singleResp.CertID)
+		if _, ok := ret[id]; ok {
+			return nil, ParseError("OCSP response contains duplicate responses")
+		}
+		ret[id] = resp
+	}
+	return ret, nil
+}
+
+// parseBasicResponse unwraps a successful basic OCSP response.
+func parseBasicResponse(bytes []byte) (*basicResponse, error) {
+	var resp responseASN1
+	rest, err := asn1.Unmarshal(bytes, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, ParseError("trailing data in OCSP response")
+	}
+
+	if status := ResponseStatus(resp.Status); status != Success {
+		return nil, ResponseError{status}
+	}
+
+	if !resp.Response.ResponseType.Equal(idPKIXOCSPBasic) {
+		return nil, ParseError("bad OCSP response type")
+	}
+
+	basicResp := new(basicResponse)
+	rest, err = asn1.Unmarshal(resp.Response.Response, basicResp)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(basicResp.Certificates) > 1 {
+		return nil, ParseError("OCSP response contains bad number of certificates")
+	}
+	return basicResp, nil
+}
+
+// newResponse returns a Response holding the fields of basicResp that are
+// shared by all of its single responses, after checking its signature.
+func newResponse(basicResp *basicResponse, issuer *x509.Certificate) (*Response, error) {
 	ret := &Response{
 		TBSResponseData:    basicResp.TBSResponseData.Raw,
 		Signature:          basicResp.Signature.RightAlign(),
 		SignatureAlgorithm: getSignatureAlgorithmFromOID(basicResp.SignatureAlgorithm.Algorithm),
-		Extensions:         singleResp.SingleExtensions,
-		SerialNumber:       singleResp.CertID.SerialNumber,
 		ProducedAt:         basicResp.TBSResponseData.ProducedAt,
-		ThisUpdate:         singleResp.ThisUpdate,
-		NextUpdate:         singleResp.NextUpdate,
 	}
 
 	// Handle the ResponderID CHOICE tag. ResponderID can be flattened into
@@ -544,6 +613,7 @@ func ParseResponseForCert(bytes []byte, cert, issuer *x509.Certificate) (*Respon
 	}
 
 	if len(basicResp.Certificates) > 0 {
+		var err error
 		ret.Certificate, err = x509.ParseCertificate(basicResp.Certificates[0].FullBytes)
 		if err != nil {
 			return nil, err
@@ -563,35 +633,44 @@ func ParseResponseForCert(bytes []byte, cert, issuer *x509.Certificate) (*Respon
 			return nil, ParseError("bad OCSP signature: " + err.Error())
 		}
 	}
+	return ret, nil
+}
+
+// setSingleResponse fills in the certificate status fields of resp from
+// singleResp.
+func (resp *Response) setSingleResponse(singleResp *singleResponse) error {
+	resp.Extensions = singleResp.SingleExtensions
+	resp.SerialNumber = singleResp.CertID.SerialNumber
+	resp.ThisUpdate = singleResp.ThisUpdate
+	resp.NextUpdate = singleResp.NextUpdate
 
 	for _, ext := range singleResp.SingleExtensions {
 		if ext.Critical {
-			return nil, ParseError("unsupported critical extension")
+			return ParseError("unsupported critical extension")
 		}
 	}
 
 	for h, oid := range hashOIDs {
 		if singleResp.CertID.HashAlgorithm.Algorithm.Equal(oid) {
-			ret.IssuerHash = h
+			resp.IssuerHash = h
 			break
 		}
 	}
-	if ret.IssuerHash == 0 {
-		return nil, ParseError("unsupported issuer hash algorithm")
+	if resp.IssuerHash == 0 {
+		return ParseError("unsupported issuer hash algorithm")
 	}
 
 	switch {
 	case bool(singleResp.Good):
-		ret.Status = Good
+		resp.Status = Good
 	case bool(singleResp.Unknown):
-		ret.Status = Unknown
+		resp.Status = Unknown
 	default:
-		ret.Status = Revoked
-		ret.RevokedAt = singleResp.Revoked.RevocationTime
-		ret.RevocationReason = int(singleResp.Revoked.Reason)
+		resp.Status = Revoked
+		resp.RevokedAt = singleResp.Revoked.RevocationTime
+		resp.RevocationReason = int(singleResp.Revoked.Reason)
 	}
-
-	return ret, nil
+	return nil
 }
 
 // RequestOptions contains options for constructing OCSP requests.
@@ -612,8 +691,91 @@ func (opts *RequestOptions) hash() crypto.Hash {
 // CreateRequest returns a DER-encoded, OCSP request for the status of cert. If
 // opts is nil then sensible defaults are used.
 func CreateRequest(cert, issuer *x509.Certificate, opts *RequestOptions) ([]byte, error) {
-	hashFunc := opts.hash()
+	req, err := newRequest(cert, issuer, opts.hash())
+	if err != nil {
+		return nil, err
+	}
+	return req.Marshal()
+}
+
+// CertPair holds a certificate whose status is requested, together with the
+// certificate of its issuer.
+type CertPair struct {
+	Cert, Issuer *x509.Certificate
+}
 
+// CreateBatchRequest returns a DER-encoded OCSP request for the status of
+// several certificates, as allowed by RFC 6960, section 4.1.1. The reply can
+// be parsed with ParseBatchResponse. If opts is nil then sensible defaults
+// are used.
+func CreateBatchRequest(certs []CertPair, opts *RequestOptions) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("ocsp: no certificates in batch request")
+	}
+	var list []request
+	for _, pair := range certs {
+		req, err := newRequest(pair.Cert, pair.Issuer, opts.hash())
+		if err != nil {
+			return nil, err
+		}
+		id, err := req.certID()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, request{Cert: id})
+	}
+	return asn1.Marshal(ocspRequest{
+		tbsRequest{
+			Version:     0,
+			RequestList: list,
+		},
+	})
+}
+
+// CertID identifies the certificate that a response of an OCSP reply refers to.
+// It is comparable, so that it can be used as a map key. See RFC 6960, section
+// 4.1.1.
+type CertID struct {
+	HashAlgorithm crypto.Hash
+	// IssuerNameHash and IssuerKeyHash hold the raw hash values.
+	IssuerNameHash string
+	IssuerKeyHash  string
+	// SerialNumber holds the decimal serial number of the certificate.
+	SerialNumber string
+}
+
+// NewCertID returns the CertID of cert, computed with hashFunc. Responders
+// echo the CertID of each request, so hashFunc should be the one the request
+// was made with.
+func NewCertID(cert, issuer *x509.Certificate, hashFunc crypto.Hash) (CertID, error) {
+	req, err := newRequest(cert, issuer, hashFunc)
+	if err != nil {
+		return CertID{}, err
+	}
+	return CertID{
+		HashAlgorithm:  hashFunc,
+		IssuerNameHash: string(req.IssuerNameHash),
+		IssuerKeyHash:  string(req.IssuerKeyHash),
+		SerialNumber:   req.SerialNumber.String(),
+	}, nil
+}
+
+func certIDFromASN1(hashFunc crypto.Hash, id *certID) CertID {
+	var serial string
+	if id.SerialNumber != nil {
+		serial = id.SerialNumber.String()
+	}
+	return CertID{
+		HashAlgorithm:  hashFunc,
+		IssuerNameHash: string(id.NameHash),
+		IssuerKeyHash:  string(id.IssuerKeyHash),
+		SerialNumber:   serial,
+	}
+}
+
+// newRequest returns the Request for the status of cert, hashing the
+// issuer's name and key with hashFunc.
+func newRequest(cert, issuer *x509.Certificate, hashFunc crypto.Hash) (*Request, error) {
 	// OCSP seems to be the only place where these raw hash identifiers are
 	// used. I took the following from
 	// http://msdn.microsoft.com/en-us/library/ff635603.aspx
@@ -625,7 +787,7 @@ func CreateRequest(cert, issuer *x509.Certificate, opts *RequestOptions) ([]byte
 	if !hashFunc.Available() {
 		return nil, x509.ErrUnsupportedAlgorithm
 	}
-	h := opts.hash().New()
+	h := hashFunc.New()
 
 	var publicKeyInfo struct {
 		Algorithm pkix.AlgorithmIdentifier
@@ -642,13 +804,12 @@ func CreateRequest(cert, issuer *x509.Certificate, opts *RequestOptions) ([]byte
 	h.Write(issuer.RawSubject)
 	issuerNameHash := h.Sum(nil)
 
-	req := &Request{
+	return &Request{
 		HashAlgorithm:  hashFunc,
 		IssuerNameHash: issuerNameHash,
 		IssuerKeyHash:  issuerKeyHash,
 		SerialNumber:   cert.SerialNumber,
-	}
-	return req.Marshal()
+	}, nil
 }
 
 // CreateResponse returns a DER-encoded OCSP response with the specified contents.
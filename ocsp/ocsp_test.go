@@ -358,6 +358,98 @@ func TestOCSPDecodeMultiResponseWithoutMatchingCert(t *testing.T) {
 	}
 }
 
+func TestOCSPBatchRequest(t *testing.T) {
+	leafCert, _ := hex.DecodeString(leafCertHex)
+	leaf, err := x509.ParseCertificate(leafCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuerCert, _ := hex.DecodeString(issuerCertHex)
+	issuer, err := x509.ParseCertificate(issuerCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pairs := []CertPair{{leaf, issuer}, {issuer, issuer}}
+	opts := &RequestOptions{Hash: crypto.SHA256}
+	request, err := CreateBatchRequest(pairs, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req ocspRequest
+	if _, err := asn1.Unmarshal(request, &req); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(req.TBSRequest.RequestList); n != len(pairs) {
+		t.Fatalf("got %d requests, want %d", n, len(pairs))
+	}
+	for i, pair := range pairs {
+		want, err := NewCertID(pair.Cert, pair.Issuer, crypto.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := certIDFromASN1(crypto.SHA256, &req.TBSRequest.RequestList[i].Cert); got != want {
+			t.Errorf("request %d: got CertID %+v, want %+v", i, got, want)
+		}
+	}
+
+	// The first entry must match the one of a single request.
+	single, err := CreateRequest(leaf, issuer, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := ParseRequest(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marshaled, err := first.Marshal(); err != nil || !bytes.Equal(marshaled, single) {
+		t.Errorf("first request: got %x, want %x", marshaled, single)
+	}
+
+	if _, err := CreateBatchRequest(nil, nil); err == nil {
+		t.Error("CreateBatchRequest succeeded without certificates")
+	}
+}
+
+func TestOCSPBatchResponse(t *testing.T) {
+	inclCert, _ := hex.DecodeString(ocspMultiResponseCertHex)
+	cert, err := x509.ParseCertificate(inclCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responseBytes, _ := hex.DecodeString(ocspMultiResponseHex)
+	resps, err := ParseBatchResponse(responseBytes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resps) < 2 {
+		t.Fatalf("got %d responses, want several", len(resps))
+	}
+
+	want, err := ParseResponseForCert(responseBytes, cert, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for id, resp := range resps {
+		if id.SerialNumber != resp.SerialNumber.String() || id.HashAlgorithm != resp.IssuerHash {
+			t.Errorf("CertID %+v does not match its response", id)
+		}
+		if id.SerialNumber == cert.SerialNumber.String() {
+			found = true
+			if !reflect.DeepEqual(resp, want) {
+				t.Errorf("got response %+v, want %+v", resp, want)
+			}
+		}
+	}
+	if !found {
+		t.Error("no response for the certificate")
+	}
+}
+
 // This OCSP response was taken from Thawte's public OCSP responder.
 // To recreate:
 //   $ openssl s_client -tls1 -showcerts -servername www.google.com -connect www.google.com:443
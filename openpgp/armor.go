@@ -0,0 +1,42 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package openpgp
+
+import (
+	"io"
+
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// Dearmor removes the ASCII armor from the first armored block in r. It
+// returns the block's type, such as "PGP MESSAGE", its armor headers and
+// the binary packet stream, which is returned as is without being parsed.
+// The armor checksum is verified once body has been read to EOF; a
+// mismatch is reported as an error from body's Read. Headers are keyed
+// by name, so of a header that is repeated, such as several Comment
+// lines, only the last value is returned.
+func Dearmor(r io.Reader) (blockType string, body io.Reader, headers map[string]string, err error) {
+	block, err := armor.Decode(r)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return block.Type, block.Body, block.Header, nil
+}
+
+// Enarmor writes the packet stream read from body to w, wrapped in ASCII
+// armor of the given type and with the given armor headers. Like Dearmor,
+// it does not parse the packets, so any packet stream survives a round
+// trip through the two unchanged. The armor itself may not: headers are
+// written sorted by key, and repeated headers dropped by Dearmor are lost.
+func Enarmor(w io.Writer, blockType string, headers map[string]string, body io.Reader) error {
+	aw, err := armor.Encode(w, blockType, headers)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(aw, body); err != nil {
+		return err
+	}
+	return aw.Close()
+}
@@ -7,6 +7,7 @@ package armor
 import (
 	"encoding/base64"
 	"io"
+	"sort"
 )
 
 var armorHeaderSep = []byte(": ")
@@ -129,7 +130,8 @@ func (e *encoding) Close() (err error) {
 }
 
 // Encode returns a WriteCloser which will encode the data written to it in
-// OpenPGP armor.
+// OpenPGP armor. The headers are written sorted by key, so that the output
+// does not depend on map iteration order.
 func Encode(out io.Writer, blockType string, headers map[string]string) (w io.WriteCloser, err error) {
 	bType := []byte(blockType)
 	err = writeSlices(out, armorStart, bType, armorEndOfLineOut)
@@ -137,8 +139,13 @@ func Encode(out io.Writer, blockType string, headers map[string]string) (w io.Wr
 		return
 	}
 
-	for k, v := range headers {
-		err = writeSlices(out, []byte(k), armorHeaderSep, []byte(v), newline)
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		err = writeSlices(out, []byte(k), armorHeaderSep, []byte(headers[k]), newline)
 		if err != nil {
 			return
 		}
@@ -0,0 +1,98 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package openpgp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDearmorEnarmor(t *testing.T) {
+	blockType, body, headers, err := Dearmor(strings.NewReader(armoredPrivateKeyBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blockType != PrivateKeyType {
+		t.Errorf("got block type %q, want %q", blockType, PrivateKeyType)
+	}
+	if want := map[string]string{"Version": "GnuPG v1.4.10 (GNU/Linux)"}; !reflect.DeepEqual(headers, want) {
+		t.Errorf("got headers %v, want %v", headers, want)
+	}
+	packets, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Enarmor(&buf, blockType, headers, bytes.NewReader(packets)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), armoredPrivateKeyBlock; got != want {
+		t.Errorf("re-armored block differs:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEnarmorDearmorRoundTrip(t *testing.T) {
+	// The stream is not parsed, so bytes that are not a packet, appended
+	// after the keys here, survive too.
+	packets, _ := hex.DecodeString(testKeys1And2PrivateHex)
+	packets = append(packets, 0xff, 0x00, 0x01)
+	headers := map[string]string{
+		"Comment": "round trip",
+		"Hash":    "SHA256",
+		"Version": "test",
+	}
+
+	var armored bytes.Buffer
+	if err := Enarmor(&armored, "PGP ARMORED FILE", headers, bytes.NewReader(packets)); err != nil {
+		t.Fatal(err)
+	}
+	blockType, body, gotHeaders, err := Dearmor(bytes.NewReader(armored.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blockType != "PGP ARMORED FILE" || !reflect.DeepEqual(gotHeaders, headers) {
+		t.Errorf("got type %q and headers %v, want %q and %v", blockType, gotHeaders, "PGP ARMORED FILE", headers)
+	}
+	if !bytes.Equal(got, packets) {
+		t.Errorf("packet stream changed in the round trip")
+	}
+
+	var again bytes.Buffer
+	if err := Enarmor(&again, blockType, gotHeaders, bytes.NewReader(got)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Bytes(), armored.Bytes()) {
+		t.Errorf("re-armoring is not deterministic:\n%s\n%s", armored.Bytes(), again.Bytes())
+	}
+}
+
+func TestDearmorRepeatedHeader(t *testing.T) {
+	const armored = `-----BEGIN PGP ARMORED FILE-----
+Comment: first
+Comment: second
+
+AQID
+=Z2GT
+-----END PGP ARMORED FILE-----`
+	_, body, headers, err := Dearmor(strings.NewReader(armored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadAll(body); err != nil || !bytes.Equal(got, []byte{1, 2, 3}) {
+		t.Errorf("got body %x, %v", got, err)
+	}
+	if want := map[string]string{"Comment": "second"}; !reflect.DeepEqual(headers, want) {
+		t.Errorf("got headers %v, want %v", headers, want)
+	}
+}
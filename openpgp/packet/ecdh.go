@@ -0,0 +1,185 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"crypto/aes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/subtle"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"strconv"
+
+	"golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/s2k"
+)
+
+// ecdhParam returns the KDF parameters that bind the key encryption key
+// to the recipient, as defined in RFC 6637, section 8.
+func ecdhParam(pub *PublicKey) []byte {
+	param := make([]byte, 0, 1+len(pub.ec.oid)+5+20+len(pub.Fingerprint))
+	param = append(param, byte(len(pub.ec.oid)))
+	param = append(param, pub.ec.oid...)
+	param = append(param, byte(PubKeyAlgoECDH), 3, 1, byte(pub.ecdh.KdfHash), byte(pub.ecdh.KdfAlgo))
+	param = append(param, "Anonymous Sender    "...)
+	return append(param, pub.Fingerprint[:]...)
+}
+
+// ecdhKEK derives the key encryption key from the shared point's x
+// coordinate, as defined in RFC 6637, section 7.
+func ecdhKEK(pub *PublicKey, curve elliptic.Curve, x *big.Int) ([]byte, error) {
+	hash, ok := s2k.HashIdToHash(byte(pub.ecdh.KdfHash))
+	if !ok || !hash.Available() {
+		return nil, errors.UnsupportedError("ECDH KDF hash " + strconv.Itoa(int(pub.ecdh.KdfHash)))
+	}
+	kekCipher := CipherFunction(pub.ecdh.KdfAlgo)
+	switch kekCipher {
+	case CipherAES128, CipherAES192, CipherAES256:
+	default:
+		return nil, errors.UnsupportedError("ECDH key wrapping cipher")
+	}
+	if hash.Size() < kekCipher.KeySize() {
+		return nil, errors.UnsupportedError("ECDH KDF hash too short for the key wrapping cipher")
+	}
+
+	zb := make([]byte, (curve.Params().BitSize+7)/8)
+	xBytes := x.Bytes()
+	copy(zb[len(zb)-len(xBytes):], xBytes)
+
+	h := hash.New()
+	h.Write([]byte{0, 0, 0, 1})
+	h.Write(zb)
+	h.Write(ecdhParam(pub))
+	return h.Sum(nil)[:kekCipher.KeySize()], nil
+}
+
+// ecdhEncrypt wraps keyBlock for pub, returning the ephemeral public
+// point and the wrapped key.
+func ecdhEncrypt(rand io.Reader, pub *PublicKey, keyBlock []byte) (ephemeral, wrapped []byte, err error) {
+	ecPub := pub.PublicKey.(*ecdsa.PublicKey)
+	d, x, y, err := elliptic.GenerateKey(ecPub.Curve, rand)
+	if err != nil {
+		return nil, nil, err
+	}
+	sx, _ := ecPub.Curve.ScalarMult(ecPub.X, ecPub.Y, d)
+	kek, err := ecdhKEK(pub, ecPub.Curve, sx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The key block is padded to a multiple of 8 bytes as in PKCS #5.
+	padding := 8 - len(keyBlock)%8
+	padded := make([]byte, len(keyBlock)+padding)
+	copy(padded, keyBlock)
+	for i := len(keyBlock); i < len(padded); i++ {
+		padded[i] = byte(padding)
+	}
+
+	wrapped, err = aesKeyWrap(kek, padded)
+	if err != nil {
+		return nil, nil, err
+	}
+	return elliptic.Marshal(ecPub.Curve, x, y), wrapped, nil
+}
+
+// ecdhDecrypt unwraps the key block that was wrapped for priv.
+func ecdhDecrypt(priv *PrivateKey, ephemeral, wrapped []byte) ([]byte, error) {
+	ecPriv, ok := priv.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.InvalidArgumentError("ECDH private key has the wrong type")
+	}
+	curve := ecPriv.Curve
+	x, y := elliptic.Unmarshal(curve, ephemeral)
+	if x == nil {
+		return nil, errors.StructuralError("invalid ECDH ephemeral point")
+	}
+	sx, _ := curve.ScalarMult(x, y, ecPriv.D.Bytes())
+	kek, err := ecdhKEK(&priv.PublicKey, curve, sx)
+	if err != nil {
+		return nil, err
+	}
+
+	padded, err := aesKeyUnwrap(kek, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	padding := int(padded[len(padded)-1])
+	if padding == 0 || padding > 8 || padding > len(padded) {
+		return nil, errors.StructuralError("invalid ECDH key block padding")
+	}
+	for _, b := range padded[len(padded)-padding:] {
+		if int(b) != padding {
+			return nil, errors.StructuralError("invalid ECDH key block padding")
+		}
+	}
+	return padded[:len(padded)-padding], nil
+}
+
+// aesKeyWrapIV is the default initial value of RFC 3394, section 2.2.3.1.
+var aesKeyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// aesKeyWrap wraps plaintext, a multiple of 8 bytes long, with kek as
+// specified in RFC 3394, section 2.2.1.
+func aesKeyWrap(kek, plaintext []byte) ([]byte, error) {
+	if len(plaintext)%8 != 0 || len(plaintext) < 16 {
+		return nil, errors.InvalidArgumentError("key wrap input must be a multiple of 8 bytes, at least 16")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(plaintext) / 8
+	out := make([]byte, 8+len(plaintext))
+	copy(out, aesKeyWrapIV)
+	copy(out[8:], plaintext)
+
+	var buf [16]byte
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(buf[:8], out[:8])
+			copy(buf[8:], out[8*i:])
+			block.Encrypt(buf[:], buf[:])
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(buf[:8])^t)
+			copy(out[8*i:], buf[8:])
+		}
+	}
+	return out, nil
+}
+
+// aesKeyUnwrap unwraps ciphertext with kek as specified in RFC 3394,
+// section 2.2.2, and checks the integrity value.
+func aesKeyUnwrap(kek, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext)%8 != 0 || len(ciphertext) < 24 {
+		return nil, errors.StructuralError("wrapped key must be a multiple of 8 bytes, at least 24")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(ciphertext)/8 - 1
+	out := make([]byte, len(ciphertext))
+	copy(out, ciphertext)
+
+	var buf [16]byte
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(buf[:8], binary.BigEndian.Uint64(out[:8])^t)
+			copy(buf[8:], out[8*i:8*i+8])
+			block.Decrypt(buf[:], buf[:])
+			copy(out[:8], buf[:8])
+			copy(out[8*i:], buf[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(out[:8], aesKeyWrapIV) != 1 {
+		return nil, errors.ErrKeyIncorrect
+	}
+	return out[8:], nil
+}
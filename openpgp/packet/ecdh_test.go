@@ -0,0 +1,68 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Test vectors from RFC 3394, section 4.
+var aesKeyWrapTests = []struct {
+	kek, plaintext, ciphertext string
+}{
+	{
+		"000102030405060708090A0B0C0D0E0F",
+		"00112233445566778899AABBCCDDEEFF",
+		"1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5",
+	},
+	{
+		"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+		"00112233445566778899AABBCCDDEEFF",
+		"64E8C3F9CE0F5BA263E9777905818A2A93C8191E7D6E8AE7",
+	},
+	{
+		"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+		"00112233445566778899AABBCCDDEEFF0001020304050607",
+		"A8F9BC1612C68B3FF6E6F4FBE30E71E4769C8B80A32CB8958CD5D17D6B254DA1",
+	},
+	{
+		"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+		"00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F",
+		"28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21",
+	},
+}
+
+func TestAESKeyWrap(t *testing.T) {
+	for i, test := range aesKeyWrapTests {
+		kek, _ := hex.DecodeString(test.kek)
+		plaintext, _ := hex.DecodeString(test.plaintext)
+		ciphertext, _ := hex.DecodeString(test.ciphertext)
+
+		wrapped, err := aesKeyWrap(kek, plaintext)
+		if err != nil {
+			t.Errorf("#%d: aesKeyWrap: %v", i, err)
+			continue
+		}
+		if !bytes.Equal(wrapped, ciphertext) {
+			t.Errorf("#%d: got %X, want %X", i, wrapped, ciphertext)
+		}
+
+		unwrapped, err := aesKeyUnwrap(kek, ciphertext)
+		if err != nil {
+			t.Errorf("#%d: aesKeyUnwrap: %v", i, err)
+			continue
+		}
+		if !bytes.Equal(unwrapped, plaintext) {
+			t.Errorf("#%d: got %X, want %X", i, unwrapped, plaintext)
+		}
+
+		ciphertext[len(ciphertext)-1] ^= 1
+		if _, err := aesKeyUnwrap(kek, ciphertext); err == nil {
+			t.Errorf("#%d: aesKeyUnwrap accepted a modified ciphertext", i)
+		}
+	}
+}
@@ -26,6 +26,10 @@ type EncryptedKey struct {
 	Key        []byte         // only valid after a successful Decrypt
 
 	encryptedMPI1, encryptedMPI2 parsedMPI
+
+	// ecdhWrappedKey is the wrapped session key of an ECDH
+	// encrypted key, whose ephemeral point is in encryptedMPI1.
+	ecdhWrappedKey []byte
 }
 
 func (e *EncryptedKey) parse(r io.Reader) (err error) {
@@ -48,6 +52,20 @@ func (e *EncryptedKey) parse(r io.Reader) (err error) {
 			return
 		}
 		e.encryptedMPI2.bytes, e.encryptedMPI2.bitLength, err = readMPI(r)
+	case PubKeyAlgoECDH:
+		e.encryptedMPI1.bytes, e.encryptedMPI1.bitLength, err = readMPI(r)
+		if err != nil {
+			return
+		}
+		var length [1]byte
+		if _, err = readFull(r, length[:]); err != nil {
+			return
+		}
+		e.ecdhWrappedKey = make([]byte, length[0])
+		_, err = readFull(r, e.ecdhWrappedKey)
+	}
+	if err != nil {
+		return
 	}
 	_, err = consumeAll(r)
 	return
@@ -68,6 +86,10 @@ func (e *EncryptedKey) Decrypt(priv *PrivateKey, config *Config) error {
 	var err error
 	var b []byte
 
+	if !compatibleEncryptionAlgos(e.Algo, priv.PubKeyAlgo) {
+		return errors.InvalidArgumentError("cannot decrypt encrypted session key of type " + strconv.Itoa(int(e.Algo)) + " with private key of type " + strconv.Itoa(int(priv.PubKeyAlgo)))
+	}
+
 	// TODO(agl): use session key decryption routines here to avoid
 	// padding oracle attacks.
 	switch priv.PubKeyAlgo {
@@ -77,6 +99,8 @@ func (e *EncryptedKey) Decrypt(priv *PrivateKey, config *Config) error {
 		c1 := new(big.Int).SetBytes(e.encryptedMPI1.bytes)
 		c2 := new(big.Int).SetBytes(e.encryptedMPI2.bytes)
 		b, err = elgamal.Decrypt(priv.PrivateKey.(*elgamal.PrivateKey), c1, c2)
+	case PubKeyAlgoECDH:
+		b, err = ecdhDecrypt(priv, e.encryptedMPI1.bytes, e.ecdhWrappedKey)
 	default:
 		err = errors.InvalidArgumentError("cannot decrypted encrypted session key with private key of type " + strconv.Itoa(int(priv.PubKeyAlgo)))
 	}
@@ -84,6 +108,9 @@ func (e *EncryptedKey) Decrypt(priv *PrivateKey, config *Config) error {
 	if err != nil {
 		return err
 	}
+	if len(b) < 3 {
+		return errors.StructuralError("EncryptedKey too short")
+	}
 
 	e.CipherFunc = CipherFunction(b[0])
 	e.Key = b[1 : len(b)-2]
@@ -104,6 +131,8 @@ func (e *EncryptedKey) Serialize(w io.Writer) error {
 		mpiLen = 2 + len(e.encryptedMPI1.bytes)
 	case PubKeyAlgoElGamal:
 		mpiLen = 2 + len(e.encryptedMPI1.bytes) + 2 + len(e.encryptedMPI2.bytes)
+	case PubKeyAlgoECDH:
+		mpiLen = 2 + len(e.encryptedMPI1.bytes) + 1 + len(e.ecdhWrappedKey)
 	default:
 		return errors.InvalidArgumentError("don't know how to serialize encrypted key type " + strconv.Itoa(int(e.Algo)))
 	}
@@ -119,6 +148,10 @@ func (e *EncryptedKey) Serialize(w io.Writer) error {
 		writeMPIs(w, e.encryptedMPI1)
 	case PubKeyAlgoElGamal:
 		writeMPIs(w, e.encryptedMPI1, e.encryptedMPI2)
+	case PubKeyAlgoECDH:
+		writeMPIs(w, e.encryptedMPI1)
+		w.Write([]byte{byte(len(e.ecdhWrappedKey))})
+		w.Write(e.ecdhWrappedKey)
 	default:
 		panic("internal error")
 	}
@@ -147,6 +180,8 @@ func SerializeEncryptedKey(w io.Writer, pub *PublicKey, cipherFunc CipherFunctio
 		return serializeEncryptedKeyRSA(w, config.Random(), buf, pub.PublicKey.(*rsa.PublicKey), keyBlock)
 	case PubKeyAlgoElGamal:
 		return serializeEncryptedKeyElGamal(w, config.Random(), buf, pub.PublicKey.(*elgamal.PublicKey), keyBlock)
+	case PubKeyAlgoECDH:
+		return serializeEncryptedKeyECDH(w, config.Random(), buf, pub, keyBlock)
 	case PubKeyAlgoDSA, PubKeyAlgoRSASignOnly:
 		return errors.InvalidArgumentError("cannot encrypt to public key of type " + strconv.Itoa(int(pub.PubKeyAlgo)))
 	}
@@ -154,6 +189,16 @@ func SerializeEncryptedKey(w io.Writer, pub *PublicKey, cipherFunc CipherFunctio
 	return errors.UnsupportedError("encrypting a key to public key of type " + strconv.Itoa(int(pub.PubKeyAlgo)))
 }
 
+// compatibleEncryptionAlgos reports whether a session key encrypted with
+// algorithm algo can be decrypted with a private key of type keyAlgo.
+func compatibleEncryptionAlgos(algo, keyAlgo PublicKeyAlgorithm) bool {
+	switch algo {
+	case PubKeyAlgoRSA, PubKeyAlgoRSAEncryptOnly:
+		return keyAlgo == PubKeyAlgoRSA || keyAlgo == PubKeyAlgoRSAEncryptOnly
+	}
+	return algo == keyAlgo
+}
+
 func serializeEncryptedKeyRSA(w io.Writer, rand io.Reader, header [10]byte, pub *rsa.PublicKey, keyBlock []byte) error {
 	cipherText, err := rsa.EncryptPKCS1v15(rand, pub, keyBlock)
 	if err != nil {
@@ -197,3 +242,33 @@ func serializeEncryptedKeyElGamal(w io.Writer, rand io.Reader, header [10]byte,
 	}
 	return writeBig(w, c2)
 }
+
+func serializeEncryptedKeyECDH(w io.Writer, rand io.Reader, header [10]byte, pub *PublicKey, keyBlock []byte) error {
+	ephemeral, wrapped, err := ecdhEncrypt(rand, pub, keyBlock)
+	if err != nil {
+		return errors.InvalidArgumentError("ECDH encryption failed: " + err.Error())
+	}
+
+	packetLen := 10 /* header length */
+	packetLen += 2 /* mpi size */ + len(ephemeral)
+	packetLen += 1 /* wrapped key size */ + len(wrapped)
+
+	err = serializeHeader(w, packetTypeEncryptedKey, packetLen)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(header[:])
+	if err != nil {
+		return err
+	}
+	err = writeMPI(w, 8*uint16(len(ephemeral)), ephemeral)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte{byte(len(wrapped))})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(wrapped)
+	return err
+}
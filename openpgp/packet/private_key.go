@@ -282,7 +282,9 @@ func (pk *PrivateKey) parsePrivateKey(data []byte) (err error) {
 		return pk.parseDSAPrivateKey(data)
 	case PubKeyAlgoElGamal:
 		return pk.parseElGamalPrivateKey(data)
-	case PubKeyAlgoECDSA:
+	case PubKeyAlgoECDSA, PubKeyAlgoECDH:
+		// The ECDH private key is stored in an ecdsa.PrivateKey,
+		// like its public key.
 		return pk.parseECDSAPrivateKey(data)
 	}
 	panic("impossible")
@@ -47,6 +47,15 @@ type MessageDetails struct {
 	LiteralData              *packet.LiteralData // the metadata of the contents
 	UnverifiedBody           io.Reader           // the contents of the message.
 
+	// SessionKey and SessionKeyCipher hold the symmetric key, and its
+	// cipher, that the message was encrypted with. They are set when
+	// an encrypted message has been successfully decrypted and can be
+	// passed to DecryptWithSessionKey, or to
+	// packet.SerializeEncryptedKey to grant another recipient access
+	// to the message.
+	SessionKey       []byte
+	SessionKeyCipher packet.CipherFunction
+
 	// If IsSigned is true and SignedBy is non-zero then the signature will
 	// be verified as UnverifiedBody is read. The signature cannot be
 	// checked until the whole of UnverifiedBody is read so UnverifiedBody
@@ -113,7 +122,7 @@ ParsePackets:
 			// This packet contains the decryption key encrypted to a public key.
 			md.EncryptedToKeyIds = append(md.EncryptedToKeyIds, p.KeyId)
 			switch p.Algo {
-			case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSAEncryptOnly, packet.PubKeyAlgoElGamal:
+			case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSAEncryptOnly, packet.PubKeyAlgoElGamal, packet.PubKeyAlgoECDH:
 				break
 			default:
 				continue
@@ -169,6 +178,8 @@ FindKey:
 				}
 				if decrypted != nil {
 					md.DecryptedWith = pk.key
+					md.SessionKey = pk.encryptedKey.Key
+					md.SessionKeyCipher = pk.encryptedKey.CipherFunc
 					break FindKey
 				}
 			} else {
@@ -204,6 +215,8 @@ FindKey:
 						return nil, err
 					}
 					if decrypted != nil {
+						md.SessionKey = key
+						md.SessionKeyCipher = cipherFunc
 						break FindKey
 					}
 				}
@@ -219,6 +232,52 @@ FindKey:
 	return readSignedMessage(packets, md, keyring)
 }
 
+// DecryptWithSessionKey parses an encrypted OpenPGP message using a
+// session key that is already known, for example one taken from the
+// SessionKey field of a previously decrypted message. Any encrypted
+// key packets in the message are skipped. Since no keyring is
+// available, signatures are not verified and SignedBy is always nil.
+func DecryptWithSessionKey(r io.Reader, algo packet.CipherFunction, key []byte) (md *MessageDetails, err error) {
+	var p packet.Packet
+	var se *packet.SymmetricallyEncrypted
+
+	packets := packet.NewReader(r)
+	md = new(MessageDetails)
+	md.IsEncrypted = true
+
+ParsePackets:
+	for {
+		p, err = packets.Next()
+		if err != nil {
+			return nil, err
+		}
+		switch p := p.(type) {
+		case *packet.SymmetricKeyEncrypted:
+			md.IsSymmetricallyEncrypted = true
+		case *packet.EncryptedKey:
+			md.EncryptedToKeyIds = append(md.EncryptedToKeyIds, p.KeyId)
+		case *packet.SymmetricallyEncrypted:
+			se = p
+			break ParsePackets
+		case *packet.Compressed, *packet.LiteralData, *packet.OnePassSignature:
+			return nil, errors.InvalidArgumentError("message is not encrypted")
+		}
+	}
+
+	decrypted, err := se.Decrypt(algo, key)
+	if err != nil {
+		return nil, err
+	}
+	md.SessionKey = key
+	md.SessionKeyCipher = algo
+
+	md.decrypted = decrypted
+	if err := packets.Push(decrypted); err != nil {
+		return nil, err
+	}
+	return readSignedMessage(packets, md, EntityList(nil))
+}
+
 // readSignedMessage reads a possibly signed message if mdin is non-zero then
 // that structure is updated and returned. Otherwise a fresh MessageDetails is
 // used.
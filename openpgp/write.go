@@ -70,7 +70,7 @@ func detachSign(w io.Writer, signer *Entity, message io.Reader, sigType packet.S
 	sig := new(packet.Signature)
 	sig.SigType = sigType
 	sig.PubKeyAlgo = signer.PrivateKey.PubKeyAlgo
-	sig.Hash = config.Hash()
+	sig.Hash = signingHash(signer, config)
 	sig.CreationTime = config.Now()
 	sig.IssuerKeyId = &signer.PrivateKey.KeyId
 
@@ -88,6 +88,40 @@ func detachSign(w io.Writer, signer *Entity, message io.Reader, sigType packet.S
 	return sig.Serialize(w)
 }
 
+// signingHashes are the hash functions that may be picked from a
+// signer's preferences, in our order of preference.
+var signingHashes = []crypto.Hash{
+	crypto.SHA256,
+	crypto.SHA512,
+	crypto.SHA384,
+	crypto.SHA224,
+}
+
+// signingHash returns the hash function to sign with. A hash set
+// explicitly in config is always used. Otherwise the first hash listed
+// in the signer's preferred hash algorithms that is also in
+// signingHashes and available is chosen, falling back to the default
+// of config.
+func signingHash(signer *Entity, config *packet.Config) crypto.Hash {
+	if config != nil && config.DefaultHash != 0 {
+		return config.DefaultHash
+	}
+	if ident := signer.primaryIdentity(); ident != nil && ident.SelfSignature != nil {
+		for _, id := range ident.SelfSignature.PreferredHash {
+			h, ok := s2k.HashIdToHash(id)
+			if !ok || !h.Available() {
+				continue
+			}
+			for _, candidate := range signingHashes {
+				if h == candidate {
+					return h
+				}
+			}
+		}
+	}
+	return config.Hash()
+}
+
 // FileHints contains metadata about encrypted files. This metadata is, itself,
 // encrypted.
 type FileHints struct {
@@ -198,9 +232,16 @@ func Encrypt(ciphertext io.Writer, to []*Entity, signed *Entity, hints *FileHint
 		hashToHashId(crypto.SHA1),
 		hashToHashId(crypto.RIPEMD160),
 	}
+	// These are the possible compression algorithms, if compression
+	// was requested by config.
+	candidateCompression := []uint8{
+		uint8(packet.CompressionZLIB),
+		uint8(packet.CompressionZIP),
+	}
 	// In the event that a recipient doesn't specify any supported ciphers
 	// or hash functions, these are the ones that we assume that every
-	// implementation supports.
+	// implementation supports. Without compression preferences, only
+	// uncompressed data is assumed to be understood.
 	defaultCiphers := candidateCiphers[len(candidateCiphers)-1:]
 	defaultHashes := candidateHashes[len(candidateHashes)-1:]
 
@@ -224,6 +265,7 @@ func Encrypt(ciphertext io.Writer, to []*Entity, signed *Entity, hints *FileHint
 		}
 		candidateCiphers = intersectPreferences(candidateCiphers, preferredSymmetric)
 		candidateHashes = intersectPreferences(candidateHashes, preferredHashes)
+		candidateCompression = intersectPreferences(candidateCompression, sig.PreferredCompression)
 	}
 
 	if len(candidateCiphers) == 0 || len(candidateHashes) == 0 {
@@ -241,6 +283,19 @@ func Encrypt(ciphertext io.Writer, to []*Entity, signed *Entity, hints *FileHint
 		}
 	}
 
+	// Compress only if requested, with the configured algorithm if
+	// all recipients accept it, or else with one they all prefer.
+	compression := packet.CompressionNone
+	if configuredCompression := config.Compression(); configuredCompression != packet.CompressionNone && len(candidateCompression) > 0 {
+		compression = packet.CompressionAlgo(candidateCompression[0])
+		for _, c := range candidateCompression {
+			if packet.CompressionAlgo(c) == configuredCompression {
+				compression = configuredCompression
+				break
+			}
+		}
+	}
+
 	var hash crypto.Hash
 	for _, hashId := range candidateHashes {
 		if h, ok := s2k.HashIdToHash(hashId); ok && h.Available() {
@@ -284,6 +339,17 @@ func Encrypt(ciphertext io.Writer, to []*Entity, signed *Entity, hints *FileHint
 		return
 	}
 
+	if compression != packet.CompressionNone {
+		var compConfig *packet.CompressionConfig
+		if config != nil {
+			compConfig = config.CompressionConfig
+		}
+		encryptedData, err = packet.SerializeCompressed(encryptedData, compression, compConfig)
+		if err != nil {
+			return
+		}
+	}
+
 	if signer != nil {
 		ops := &packet.OnePassSignature{
 			SigType:    packet.SigTypeBinary,
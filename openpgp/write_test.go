@@ -6,6 +6,7 @@ package openpgp
 
 import (
 	"bytes"
+	"crypto"
 	"io"
 	"io/ioutil"
 	"testing"
@@ -271,3 +272,124 @@ func TestEncryption(t *testing.T) {
 		}
 	}
 }
+
+func TestEncryptionMixedRecipients(t *testing.T) {
+	rsaRing, _ := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
+	ecdhRing, err := ReadKeyRing(readerFromHex(ecdhP256TestKeyPrivateHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := []*Entity{rsaRing[0], ecdhRing[0]}
+
+	buf := new(bytes.Buffer)
+	w, err := Encrypt(buf, to, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("error in Encrypt: %s", err)
+	}
+	const message = "testing"
+	if _, err := w.Write([]byte(message)); err != nil {
+		t.Fatalf("error writing plaintext: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing WriteCloser: %s", err)
+	}
+
+	// Each recipient decrypts the message on its own.
+	for i, kring := range []EntityList{rsaRing[:1], ecdhRing} {
+		md, err := ReadMessage(bytes.NewReader(buf.Bytes()), kring, nil, nil)
+		if err != nil {
+			t.Errorf("#%d: error reading message: %s", i, err)
+			continue
+		}
+		if len(md.EncryptedToKeyIds) != 2 {
+			t.Errorf("#%d: got %d recipients, want 2", i, len(md.EncryptedToKeyIds))
+		}
+		plaintext, err := ioutil.ReadAll(md.UnverifiedBody)
+		if err != nil {
+			t.Errorf("#%d: error reading encrypted contents: %s", i, err)
+			continue
+		}
+		if string(plaintext) != message {
+			t.Errorf("#%d: got: %s, want: %s", i, plaintext, message)
+		}
+	}
+	if _, err := ReadMessage(bytes.NewReader(buf.Bytes()), rsaRing[1:], nil, nil); err == nil {
+		t.Error("message decrypted without a recipient key")
+	}
+}
+
+func TestSignDetachedPreferredHash(t *testing.T) {
+	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
+	kring[0].primaryIdentity().SelfSignature.PreferredHash = []uint8{hashToHashId(crypto.MD5), hashToHashId(crypto.SHA512), hashToHashId(crypto.SHA256)}
+
+	for _, test := range []struct {
+		config *packet.Config
+		want   crypto.Hash
+	}{
+		{nil, crypto.SHA512},
+		{&packet.Config{DefaultHash: crypto.SHA256}, crypto.SHA256},
+	} {
+		out := bytes.NewBuffer(nil)
+		if err := DetachSign(out, kring[0], bytes.NewBufferString(signedInput), test.config); err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := packet.Read(bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig, ok := p.(*packet.Signature); !ok || sig.Hash != test.want {
+			t.Errorf("got signature %#v, want hash %v", p, test.want)
+		}
+
+		testDetachedSignature(t, kring, out, signedInput, "check", testKey1KeyId)
+	}
+}
+
+func TestEncryptionPreferredCompression(t *testing.T) {
+	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
+	selfSig := kring[0].primaryIdentity().SelfSignature
+	config := &packet.Config{DefaultCompressionAlgo: packet.CompressionZLIB}
+	message := make([]byte, 1<<16)
+
+	for _, test := range []struct {
+		prefs      []uint8
+		compressed bool
+	}{
+		{[]uint8{uint8(packet.CompressionZIP)}, true},
+		{[]uint8{uint8(packet.CompressionZIP), uint8(packet.CompressionZLIB)}, true},
+		{nil, false},
+	} {
+		selfSig.PreferredCompression = test.prefs
+
+		buf := new(bytes.Buffer)
+		w, err := Encrypt(buf, kring[:1], kring[0], nil, config)
+		if err != nil {
+			t.Fatalf("Encrypt: %s", err)
+		}
+		if _, err := w.Write(message); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close: %s", err)
+		}
+		if compressed := buf.Len() < len(message); compressed != test.compressed {
+			t.Errorf("prefs %v: got %d bytes of ciphertext for %d bytes of input", test.prefs, buf.Len(), len(message))
+		}
+
+		md, err := ReadMessage(buf, kring, nil, nil)
+		if err != nil {
+			t.Fatalf("ReadMessage: %s", err)
+		}
+		plaintext, err := ioutil.ReadAll(md.UnverifiedBody)
+		if err != nil {
+			t.Fatalf("ReadAll: %s", err)
+		}
+		if !bytes.Equal(plaintext, message) {
+			t.Errorf("prefs %v: plaintext does not match", test.prefs)
+		}
+		if md.SignatureError != nil || md.Signature == nil {
+			t.Errorf("prefs %v: signature error: %v", test.prefs, md.SignatureError)
+		}
+	}
+}
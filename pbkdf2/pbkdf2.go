@@ -15,6 +15,12 @@ HMAC-SHA1, the drafted v2.1 specification allows use of all five FIPS Approved
 Hash Functions SHA-1, SHA-224, SHA-256, SHA-384 and SHA-512 for HMAC. To
 choose, you can pass the `New` functions from the different SHA packages to
 pbkdf2.Key.
+
+Other hash functions work as well, such as SHA-3 with sha3.New256 from
+golang.org/x/crypto/sha3. The hash must be unkeyed, since HMAC keys it with
+the password: for BLAKE2, pass a function that calls blake2s.New256 with a
+nil key, and likewise for blake2b. A keyed BLAKE2 is a MAC of its own, and
+using it here derives keys that no other PBKDF2 implementation reproduces.
 */
 package pbkdf2 // import "golang.org/x/crypto/pbkdf2"
 
@@ -34,6 +40,14 @@ import (
 //
 // 	dk := pbkdf2.Key([]byte("some password"), salt, 4096, 32, sha1.New)
 //
+// With BLAKE2s, which needs a key argument, the unkeyed constructor is
+// wrapped:
+//
+// 	dk := pbkdf2.Key([]byte("some password"), salt, 4096, 32, func() hash.Hash {
+// 		h, _ := blake2s.New256(nil)
+// 		return h
+// 	})
+//
 // Remember to get a good random salt. At least 8 bytes is recommended by the
 // RFC.
 //
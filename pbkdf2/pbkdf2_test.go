@@ -10,6 +10,10 @@ import (
 	"crypto/sha256"
 	"hash"
 	"testing"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/sha3"
 )
 
 type testVector struct {
@@ -139,6 +143,172 @@ var sha256TestVectors = []testVector{
 	},
 }
 
+// Test vectors for HMAC-SHA3-256 and HMAC-BLAKE2s-256, computed with
+// OpenSSL. The longer outputs span two blocks.
+var sha3_256TestVectors = []testVector{
+	{
+		"password",
+		"salt",
+		1,
+		[]byte{
+			0x94, 0x61, 0x3f, 0x3e, 0xe2, 0xea, 0x73, 0x0e,
+			0x0b, 0x06, 0x75, 0x4f, 0x3f, 0xc8, 0x16, 0xd4,
+			0xf8, 0x7c, 0x9b, 0xe9, 0xcb, 0xd8, 0x55, 0x6b,
+			0x5d, 0x59, 0xb5, 0x23, 0x30, 0xe3, 0x33, 0xa8,
+		},
+	},
+	{
+		"password",
+		"salt",
+		2,
+		[]byte{
+			0x4c, 0x91, 0x5b, 0xae, 0xdd, 0x17, 0x73, 0x38,
+			0x3e, 0x77, 0xfc, 0xfe, 0x38, 0x11, 0x4c, 0xa7,
+			0x51, 0x40, 0x10, 0xad, 0xec, 0x24, 0xb4, 0x72,
+			0x90, 0xec, 0x17, 0x02, 0x08, 0x42, 0x3f, 0x76,
+		},
+	},
+	{
+		"password",
+		"salt",
+		4096,
+		[]byte{
+			0x77, 0x8b, 0x6e, 0x23, 0x7a, 0x0f, 0x49, 0x62,
+			0x15, 0x49, 0xff, 0x70, 0xd2, 0x18, 0xd2, 0x08,
+			0x07, 0x56, 0xb9, 0xfb, 0x38, 0xd7, 0x1b, 0x5d,
+			0x7e, 0xf4, 0x47, 0xfa, 0x22, 0x54, 0xaf, 0x61,
+		},
+	},
+	{
+		"passwordPASSWORDpassword",
+		"saltSALTsaltSALTsaltSALTsaltSALTsalt",
+		4096,
+		[]byte{
+			0x7a, 0xef, 0x8f, 0x1a, 0xd8, 0xc7, 0xf1, 0x22,
+			0x05, 0x33, 0x4f, 0x62, 0x4d, 0x4a, 0xf9, 0xe2,
+			0x86, 0x31, 0x21, 0x61, 0x8f, 0x7a, 0x0b, 0x32,
+			0x09, 0xbe, 0xf3, 0x93, 0x48, 0x01, 0xc3, 0x9f,
+			0xea, 0xc2, 0x4e, 0xf0, 0xac, 0x6a, 0x5c, 0x25,
+		},
+	},
+}
+
+var blake2s256TestVectors = []testVector{
+	{
+		"password",
+		"salt",
+		1,
+		[]byte{
+			0xed, 0x93, 0x9d, 0x6f, 0x35, 0x1d, 0xdd, 0xb6,
+			0x9f, 0x59, 0x1a, 0xa6, 0x93, 0xd7, 0x5e, 0xcc,
+			0xaa, 0xb7, 0xc8, 0xf5, 0x87, 0x38, 0x4d, 0x8e,
+			0xd8, 0x82, 0xd4, 0x2f, 0xe8, 0x07, 0x64, 0x74,
+		},
+	},
+	{
+		"password",
+		"salt",
+		2,
+		[]byte{
+			0x7b, 0x88, 0xe6, 0x5e, 0x6e, 0x95, 0xa1, 0x18,
+			0xbc, 0x99, 0x5f, 0x68, 0x1a, 0x39, 0x1c, 0xbd,
+			0x7b, 0x46, 0xe0, 0xcf, 0x97, 0x50, 0xa8, 0x11,
+			0x00, 0xf6, 0x13, 0xad, 0xd6, 0x2d, 0x84, 0xbe,
+		},
+	},
+	{
+		"password",
+		"salt",
+		4096,
+		[]byte{
+			0x07, 0x2b, 0x63, 0xe2, 0xcf, 0xe4, 0xd2, 0x0c,
+			0xd2, 0x08, 0x6a, 0x6b, 0xe6, 0xec, 0x8e, 0x1f,
+			0xd1, 0xbf, 0x2b, 0x79, 0x7f, 0xa2, 0x72, 0xa7,
+			0x49, 0xa7, 0x61, 0xfa, 0xad, 0x66, 0xbe, 0xb6,
+		},
+	},
+	{
+		"passwordPASSWORDpassword",
+		"saltSALTsaltSALTsaltSALTsaltSALTsalt",
+		4096,
+		[]byte{
+			0xd3, 0x1c, 0x50, 0xf0, 0x6f, 0x99, 0xdd, 0xf9,
+			0x1b, 0x80, 0x78, 0xfa, 0x61, 0x01, 0x4e, 0x03,
+			0x8a, 0xc1, 0x1c, 0x08, 0x6d, 0x00, 0x74, 0x5b,
+			0xd1, 0x0c, 0xfd, 0x59, 0x27, 0x1d, 0x1d, 0xdb,
+			0x9c, 0x6d, 0x21, 0x17, 0x27, 0x0e, 0xcc, 0x94,
+		},
+	},
+}
+
+// Test vectors for HMAC-BLAKE2b-512, computed with Python's
+// hashlib.pbkdf2_hmac("blake2b", ...). The longer output spans two
+// blocks.
+var blake2b512TestVectors = []testVector{
+	{
+		"password",
+		"salt",
+		1,
+		[]byte{
+			0x68, 0x4e, 0x7c, 0xc1, 0xdd, 0x9b, 0x24, 0x1d,
+			0x2c, 0x97, 0x7f, 0x38, 0xa8, 0x96, 0x64, 0x5d,
+			0xa4, 0x9b, 0x85, 0xeb, 0x13, 0xcf, 0x8f, 0x5c,
+			0x02, 0x1e, 0xfc, 0x16, 0x7a, 0xad, 0x79, 0x93,
+			0x43, 0xc0, 0x6f, 0x50, 0xe2, 0x95, 0x9d, 0xe0,
+			0x6a, 0x0b, 0xca, 0x80, 0xa1, 0x54, 0x45, 0x7d,
+			0x8e, 0x92, 0xe7, 0x0e, 0xbd, 0xcd, 0xb3, 0x72,
+			0x2d, 0xcf, 0x9b, 0xad, 0xd6, 0xff, 0x1d, 0xfb,
+		},
+	},
+	{
+		"password",
+		"salt",
+		2,
+		[]byte{
+			0x40, 0xb7, 0x7c, 0xc2, 0xee, 0x4b, 0x4c, 0x44,
+			0xee, 0xb5, 0xba, 0xbc, 0x29, 0x9b, 0xe1, 0x4a,
+			0xf5, 0x67, 0x0e, 0x39, 0xea, 0x3c, 0xe1, 0x4c,
+			0x0f, 0xe7, 0x0e, 0x6c, 0x99, 0x36, 0x98, 0x86,
+			0xab, 0x4d, 0x69, 0x3b, 0xad, 0x8b, 0xd8, 0x11,
+			0xed, 0x64, 0xc5, 0xcf, 0x65, 0xa4, 0xcc, 0x52,
+			0x60, 0x99, 0x3e, 0x17, 0xbb, 0xf2, 0x42, 0x3c,
+			0x77, 0x16, 0x47, 0x52, 0xfc, 0xbf, 0x5a, 0x60,
+		},
+	},
+	{
+		"password",
+		"salt",
+		4096,
+		[]byte{
+			0x9d, 0x4f, 0x32, 0x4e, 0xf4, 0x0b, 0x5b, 0xe6,
+			0x58, 0xfa, 0x0a, 0xb9, 0x4a, 0x16, 0x86, 0x64,
+			0xf0, 0x60, 0xc0, 0xc9, 0xcc, 0x85, 0xa0, 0x2a,
+			0xc8, 0x3f, 0x2d, 0x44, 0x08, 0x8c, 0xb7, 0xe7,
+			0xb8, 0x12, 0xef, 0x60, 0xe9, 0xb1, 0x67, 0x3d,
+			0x4f, 0xd7, 0x72, 0x40, 0xa6, 0x86, 0x07, 0xd7,
+			0x2b, 0x91, 0x2e, 0x18, 0xa0, 0xea, 0x47, 0x72,
+			0xf4, 0x76, 0xbe, 0x75, 0x83, 0xb6, 0x69, 0x70,
+		},
+	},
+	{
+		"passwordPASSWORDpassword",
+		"saltSALTsaltSALTsaltSALTsaltSALTsalt",
+		4096,
+		[]byte{
+			0xa4, 0x6b, 0x53, 0x35, 0xdb, 0xdd, 0xa3, 0xd2,
+			0x5d, 0x19, 0xbb, 0x11, 0xfe, 0xdd, 0xd9, 0x9e,
+			0x45, 0x2a, 0x7c, 0x34, 0x47, 0x41, 0x98, 0xca,
+			0x31, 0x74, 0xb6, 0x34, 0x22, 0xac, 0x83, 0xb0,
+			0x38, 0x6e, 0xf5, 0x93, 0x0f, 0xf5, 0x16, 0x46,
+			0x0b, 0x97, 0xdc, 0x6c, 0x27, 0x5b, 0xe7, 0x25,
+			0xc2, 0xcb, 0xec, 0x50, 0x02, 0xc6, 0x52, 0x8b,
+			0x34, 0x68, 0x53, 0x65, 0xf5, 0x1f, 0x55, 0x29,
+			0x6b, 0xb9, 0xce, 0xc7, 0x38, 0x08, 0x78, 0x24,
+			0xf2, 0x21, 0x91, 0x20, 0x84, 0x82, 0x25, 0x14,
+		},
+	},
+}
+
 func testHash(t *testing.T, h func() hash.Hash, hashName string, vectors []testVector) {
 	for i, v := range vectors {
 		o := Key([]byte(v.password), []byte(v.salt), v.iter, len(v.output), h)
@@ -155,3 +325,42 @@ func TestWithHMACSHA1(t *testing.T) {
 func TestWithHMACSHA256(t *testing.T) {
 	testHash(t, sha256.New, "SHA256", sha256TestVectors)
 }
+
+func TestWithHMACSHA3(t *testing.T) {
+	testHash(t, sha3.New256, "SHA3-256", sha3_256TestVectors)
+}
+
+func TestWithHMACBLAKE2s(t *testing.T) {
+	testHash(t, newBLAKE2s256, "BLAKE2s-256", blake2s256TestVectors)
+
+	// A keyed BLAKE2s is a different PRF, which does not give the
+	// standard results.
+	keyed := func() hash.Hash {
+		h, _ := blake2s.New256([]byte("key"))
+		return h
+	}
+	v := blake2s256TestVectors[0]
+	if o := Key([]byte(v.password), []byte(v.salt), v.iter, len(v.output), keyed); bytes.Equal(o, v.output) {
+		t.Errorf("keyed BLAKE2s-256 gave the unkeyed result")
+	}
+}
+
+func TestWithHMACBLAKE2b(t *testing.T) {
+	testHash(t, newBLAKE2b512, "BLAKE2b-512", blake2b512TestVectors)
+}
+
+func newBLAKE2s256() hash.Hash {
+	h, err := blake2s.New256(nil)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+func newBLAKE2b512() hash.Hash {
+	h, err := blake2b.New512(nil)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
@@ -8,6 +8,7 @@
 package scrypt // import "golang.org/x/crypto/scrypt"
 
 import (
+	"context"
 	"crypto/sha256"
 	"errors"
 
@@ -172,7 +173,13 @@ func integer(b []uint32, r int) uint64 {
 	return uint64(b[j]) | uint64(b[j+1])<<32
 }
 
-func smix(b []byte, r, N int, v, xy []uint32) {
+// checkInterval is the number of smix iterations between two calls
+// of the cancellation check.
+const checkInterval = 1024
+
+// smix computes ROMix in place on b. If check is non-nil, it is called
+// regularly and smix returns the first error it reports.
+func smix(b []byte, r, N int, v, xy []uint32, check func() error) error {
 	var tmp [16]uint32
 	x := xy
 	y := xy[32*r:]
@@ -183,6 +190,11 @@ func smix(b []byte, r, N int, v, xy []uint32) {
 		j += 4
 	}
 	for i := 0; i < N; i += 2 {
+		if check != nil && i%checkInterval == 0 {
+			if err := check(); err != nil {
+				return err
+			}
+		}
 		blockCopy(v[i*(32*r):], x, 32*r)
 		blockMix(&tmp, x, y, r)
 
@@ -190,6 +202,11 @@ func smix(b []byte, r, N int, v, xy []uint32) {
 		blockMix(&tmp, y, x, r)
 	}
 	for i := 0; i < N; i += 2 {
+		if check != nil && i%checkInterval == 0 {
+			if err := check(); err != nil {
+				return err
+			}
+		}
 		j := int(integer(x, r) & uint64(N-1))
 		blockXOR(x, v[j*(32*r):], 32*r)
 		blockMix(&tmp, x, y, r)
@@ -206,6 +223,7 @@ func smix(b []byte, r, N int, v, xy []uint32) {
 		b[j+3] = byte(v >> 24)
 		j += 4
 	}
+	return nil
 }
 
 // Key derives a key from the password, salt, and cost parameters, returning
@@ -224,6 +242,18 @@ func smix(b []byte, r, N int, v, xy []uint32) {
 // r=8, p=1. They should be increased as memory latency and CPU parallelism
 // increases. Remember to get a good random salt.
 func Key(password, salt []byte, N, r, p, keyLen int) ([]byte, error) {
+	return key(password, salt, N, r, p, keyLen, nil)
+}
+
+// KeyContext is like Key, but gives up and returns ctx.Err() if ctx is
+// done before the derivation has finished. This allows an expensive
+// derivation, for which N and r were perhaps chosen by an attacker, to
+// be interrupted.
+func KeyContext(ctx context.Context, password, salt []byte, N, r, p, keyLen int) ([]byte, error) {
+	return key(password, salt, N, r, p, keyLen, ctx.Err)
+}
+
+func key(password, salt []byte, N, r, p, keyLen int, check func() error) ([]byte, error) {
 	if N <= 1 || N&(N-1) != 0 {
 		return nil, errors.New("scrypt: N must be > 1 and a power of 2")
 	}
@@ -231,12 +261,20 @@ func Key(password, salt []byte, N, r, p, keyLen int) ([]byte, error) {
 		return nil, errors.New("scrypt: parameters are too large")
 	}
 
+	if check != nil {
+		if err := check(); err != nil {
+			return nil, err
+		}
+	}
+
 	xy := make([]uint32, 64*r)
 	v := make([]uint32, 32*N*r)
 	b := pbkdf2.Key(password, salt, 1, p*128*r, sha256.New)
 
 	for i := 0; i < p; i++ {
-		smix(b[i*128*r:], r, N, v, xy)
+		if err := smix(b[i*128*r:], r, N, v, xy, check); err != nil {
+			return nil, err
+		}
 	}
 
 	return pbkdf2.Key(password, b, 1, keyLen, sha256.New), nil
@@ -6,6 +6,7 @@ package scrypt
 
 import (
 	"bytes"
+	"context"
 	"testing"
 )
 
@@ -153,6 +154,23 @@ func TestKey(t *testing.T) {
 	}
 }
 
+func TestKeyContext(t *testing.T) {
+	v := good[0]
+	k, err := KeyContext(context.Background(), []byte(v.password), []byte(v.salt), v.N, v.r, v.p, len(v.output))
+	if err != nil {
+		t.Fatalf("KeyContext: %v", err)
+	}
+	if !bytes.Equal(k, v.output) {
+		t.Errorf("expected %x, got %x", v.output, k)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := KeyContext(ctx, []byte("password"), []byte("salt"), 16384, 8, 1, 32); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
 func BenchmarkKey(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		Key([]byte("password"), []byte("salt"), 16384, 8, 1, 64)
@@ -385,6 +385,11 @@ func (c *client) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
 		if err := ssh.Unmarshal(msg.SigBlob, &sig); err != nil {
 			return nil, err
 		}
+		// Only the signatures of security keys have fields after
+		// the blob.
+		if len(sig.Rest) > 0 && sig.Format != ssh.KeyAlgoSKECDSA256 && sig.Format != ssh.KeyAlgoSKED25519 {
+			return nil, errors.New("agent: trailing data after signature")
+		}
 
 		return &sig, nil
 	case *failureAgentMsg:
@@ -668,8 +673,28 @@ func (c *client) Signers() ([]ssh.Signer, error) {
 	return result, nil
 }
 
+// NewSignersFiltered returns signers for the keys known to the agent
+// for which match returns true. This makes it possible to offer only
+// relevant identities, for example by comment, so that a server's
+// limit on authentication attempts is not spent on unrelated keys.
+// Keys are selected before any signing takes place.
+func NewSignersFiltered(agent Agent, match func(*Key) bool) ([]ssh.Signer, error) {
+	keys, err := agent.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []ssh.Signer
+	for _, k := range keys {
+		if match(k) {
+			result = append(result, &agentKeyringSigner{agent, k})
+		}
+	}
+	return result, nil
+}
+
 type agentKeyringSigner struct {
-	agent *client
+	agent Agent
 	pub   ssh.PublicKey
 }
 
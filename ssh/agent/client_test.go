@@ -7,7 +7,9 @@ package agent
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
 	"errors"
+	"io"
 	"net"
 	"os"
 	"os/exec"
@@ -263,6 +265,78 @@ func TestAuth(t *testing.T) {
 	conn.Close()
 }
 
+func TestSignTrailingData(t *testing.T) {
+	for _, tc := range []struct {
+		sig    ssh.Signature
+		wantOK bool
+	}{
+		{ssh.Signature{Format: ssh.KeyAlgoED25519, Blob: []byte("sig")}, true},
+		{ssh.Signature{Format: ssh.KeyAlgoED25519, Blob: []byte("sig"), Rest: []byte("junk")}, false},
+		{ssh.Signature{Format: ssh.KeyAlgoSKED25519, Blob: []byte("sig"), Rest: []byte{1, 0, 0, 0, 7}}, true},
+	} {
+		c1, c2, err := netPipe()
+		if err != nil {
+			t.Fatalf("netPipe: %v", err)
+		}
+		go func() {
+			var size [4]byte
+			if _, err := io.ReadFull(c2, size[:]); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(c2, make([]byte, binary.BigEndian.Uint32(size[:]))); err != nil {
+				return
+			}
+			resp := ssh.Marshal(signResponseAgentMsg{SigBlob: ssh.Marshal(tc.sig)})
+			binary.BigEndian.PutUint32(size[:], uint32(len(resp)))
+			c2.Write(append(size[:], resp...))
+		}()
+
+		sig, err := NewClient(c1).Sign(testPublicKeys["ed25519"], []byte("data"))
+		if tc.wantOK && (err != nil || !bytes.Equal(sig.Rest, tc.sig.Rest)) {
+			t.Errorf("%s with rest %q: got %v, %v", tc.sig.Format, tc.sig.Rest, sig, err)
+		}
+		if !tc.wantOK && err == nil {
+			t.Errorf("%s with rest %q: Sign succeeded", tc.sig.Format, tc.sig.Rest)
+		}
+		c1.Close()
+		c2.Close()
+	}
+}
+
+func TestNewSignersFiltered(t *testing.T) {
+	agent, cleanup := startKeyringAgent(t)
+	defer cleanup()
+
+	if err := agent.Add(AddedKey{PrivateKey: testPrivateKeys["rsa"], Comment: "work"}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := agent.Add(AddedKey{PrivateKey: testPrivateKeys["ecdsa"], Comment: "home"}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	signers, err := NewSignersFiltered(agent, func(k *Key) bool {
+		return k.Comment == "home"
+	})
+	if err != nil {
+		t.Fatalf("NewSignersFiltered: %v", err)
+	}
+	if len(signers) != 1 {
+		t.Fatalf("got %d signers, want 1", len(signers))
+	}
+	if !bytes.Equal(signers[0].PublicKey().Marshal(), testPublicKeys["ecdsa"].Marshal()) {
+		t.Errorf("got key %s, want ecdsa key", signers[0].PublicKey().Type())
+	}
+
+	data := []byte("data")
+	sig, err := signers[0].Sign(rand.Reader, data)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if err := testPublicKeys["ecdsa"].Verify(data, sig); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
 func TestLockOpenSSHAgent(t *testing.T) {
 	agent, _, cleanup := startOpenSSHAgent(t)
 	defer cleanup()
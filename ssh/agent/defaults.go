@@ -0,0 +1,170 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package agent
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultIdentityFiles are the private key files in ~/.ssh that
+// DefaultAuthMethods loads, in the order they are offered.
+var defaultIdentityFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// DefaultAuthMethods returns the public key authentication that the ssh
+// command does without options: the keys of the agent that SSH_AUTH_SOCK
+// points to, if one is running, followed by the keys in those of
+// ~/.ssh/id_ed25519, ~/.ssh/id_ecdsa and ~/.ssh/id_rsa that exist. It is
+// in this package rather than in package ssh, which cannot import it.
+//
+// Encrypted PEM keys are decrypted with what passphrase returns for
+// their path, and its errors and those of a wrong passphrase are
+// returned. If passphrase is nil, encrypted keys are skipped. Like ssh,
+// DefaultAuthMethods also skips files it cannot parse.
+//
+// Keys in the OpenSSH format, the default of ssh-keygen since OpenSSH
+// 7.8, cannot be decrypted by this package. If passphrase is not nil,
+// an encrypted one is reported as an error rather than skipped, since
+// the caller expects to be asked for its passphrase.
+//
+// The agent is dialed when the keys are needed, and again for each
+// signature, so no connection is left open. If SSH_AUTH_SOCK is unset
+// and there are no keys, no methods are returned.
+func DefaultAuthMethods(passphrase func(path string) ([]byte, error)) ([]ssh.AuthMethod, error) {
+	var fileSigners []ssh.Signer
+	if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range defaultIdentityFiles {
+			path := filepath.Join(home, ".ssh", name)
+			signer, err := loadIdentityFile(path, passphrase)
+			if err != nil {
+				return nil, err
+			}
+			if signer != nil {
+				fileSigners = append(fileSigners, signer)
+			}
+		}
+	}
+
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" && len(fileSigners) == 0 {
+		return nil, nil
+	}
+	// The client tries each method only once, so the keys of the
+	// agent and of the files have to go in a single method.
+	return []ssh.AuthMethod{ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		var signers []ssh.Signer
+		if socket != "" {
+			// An agent that fails is ignored, as ssh does.
+			signers, _ = socketSigners(socket)
+		}
+		return append(signers, fileSigners...), nil
+	})}, nil
+}
+
+// socketSigners returns signers for the keys of the agent listening on
+// socket. Each of them dials the agent to sign.
+func socketSigners(socket string) ([]ssh.Signer, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	keys, err := NewClient(conn).List()
+	if err != nil {
+		return nil, err
+	}
+	var signers []ssh.Signer
+	for _, k := range keys {
+		signers = append(signers, &socketSigner{socket: socket, pub: k})
+	}
+	return signers, nil
+}
+
+// socketSigner signs with a key of the agent listening on socket, over
+// a connection that is opened for the signature only.
+type socketSigner struct {
+	socket string
+	pub    ssh.PublicKey
+}
+
+func (s *socketSigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+func (s *socketSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	conn, err := net.Dial("unix", s.socket)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return NewClient(conn).Sign(s.pub, data)
+}
+
+// loadIdentityFile returns a signer for the private key in path, or nil
+// if the file does not exist or is to be skipped.
+func loadIdentityFile(path string, passphrase func(path string) ([]byte, error)) (ssh.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block != nil && block.Type == "OPENSSH PRIVATE KEY" && encryptedOpenSSHKey(block.Bytes) {
+		if passphrase == nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("agent: %s: encrypted OpenSSH private keys are not supported", path)
+	}
+	if block == nil || !strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED") {
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, nil
+		}
+		return signer, nil
+	}
+
+	if passphrase == nil {
+		return nil, nil
+	}
+	pass, err := passphrase(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(data, pass)
+	if err != nil {
+		return nil, fmt.Errorf("agent: %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// encryptedOpenSSHKey reports whether key, the contents of an OPENSSH
+// PRIVATE KEY block, names a cipher in its envelope; see PROTOCOL.key
+// in the OpenSSH sources.
+func encryptedOpenSSHKey(key []byte) bool {
+	magic := []byte("openssh-key-v1\x00")
+	if !bytes.HasPrefix(key, magic) {
+		return false
+	}
+	var w struct {
+		CipherName string
+		Rest       []byte `ssh:"rest"`
+	}
+	if err := ssh.Unmarshal(key[len(magic):], &w); err != nil {
+		return false
+	}
+	return w.CipherName != "none"
+}
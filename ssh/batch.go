@@ -0,0 +1,124 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// CommandResult is the outcome of one of the commands run by RunBatch.
+type CommandResult struct {
+	// Command is the command as it was passed to RunBatch.
+	Command string
+
+	// Stdout and Stderr hold what the command wrote to its standard
+	// output and standard error.
+	Stdout []byte
+	Stderr []byte
+
+	// ExitStatus is the exit status of the command.
+	ExitStatus int
+}
+
+// RunBatch runs commands one after the other in a single shell on the
+// remote host, and returns the output and exit status of each. This
+// saves a session per command on servers that limit them.
+//
+// The commands are written to the shell's standard input, each followed
+// by a command that prints a random marker and the exit status. The
+// output is split at these markers, so the remote shell must be a POSIX
+// shell, and each command must be complete: it is run as
+// "{ command\n} </dev/null", so it cannot read the rest of the batch.
+// A command exiting with a non-zero status is not an error; the next
+// command runs regardless. If the shell exits early, for example on an
+// "exit" command, the results of the commands that completed are
+// returned with an error.
+//
+// RunBatch requires Stdin, Stdout and Stderr to be unset, and it counts
+// as the one call to Run, Start or Shell that a Session accepts.
+func (s *Session) RunBatch(commands []string) ([]CommandResult, error) {
+	if s.Stdin != nil || s.Stdout != nil || s.Stderr != nil {
+		return nil, errors.New("ssh: Stdin, Stdout or Stderr already set")
+	}
+
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	marker := "ssh-batch-" + hex.EncodeToString(nonce[:])
+
+	var script bytes.Buffer
+	for _, cmd := range commands {
+		fmt.Fprintf(&script, "{ %s\n} </dev/null; printf '\\n%s %%d\\n' \"$?\"; printf '\\n%s\\n' >&2\n", cmd, marker, marker)
+	}
+	var stdout, stderr bytes.Buffer
+	s.Stdin = &script
+	s.Stdout = &stdout
+	s.Stderr = &stderr
+	if err := s.Shell(); err != nil {
+		return nil, err
+	}
+	waitErr := s.Wait()
+
+	results, err := splitBatchOutput(commands, marker, stdout.Bytes(), stderr.Bytes())
+	if err != nil {
+		return results, err
+	}
+	if len(results) < len(commands) {
+		if waitErr != nil {
+			return results, waitErr
+		}
+		return results, fmt.Errorf("ssh: shell exited after %d of %d commands", len(results), len(commands))
+	}
+	return results, waitErr
+}
+
+// splitBatchOutput cuts the output of a batch at the markers that
+// follow each command. On standard output, a marker line carries the
+// exit status of the command before it.
+func splitBatchOutput(commands []string, marker string, stdout, stderr []byte) ([]CommandResult, error) {
+	outSep := []byte("\n" + marker + " ")
+	errSep := []byte("\n" + marker + "\n")
+
+	var results []CommandResult
+	for _, cmd := range commands {
+		i := bytes.Index(stdout, outSep)
+		if i < 0 {
+			break
+		}
+		result := CommandResult{
+			Command: cmd,
+			Stdout:  stdout[:i],
+		}
+		stdout = stdout[i+len(outSep):]
+		end := bytes.IndexByte(stdout, '\n')
+		if end < 0 {
+			break
+		}
+		status, err := strconv.Atoi(string(stdout[:end]))
+		if err != nil {
+			return results, fmt.Errorf("ssh: bad exit status in batch output: %q", stdout[:end])
+		}
+		result.ExitStatus = status
+		stdout = stdout[end+1:]
+
+		// The marker on standard error is written after the one on
+		// standard output, so it may be missing if the shell died.
+		if j := bytes.Index(stderr, errSep); j >= 0 {
+			result.Stderr = stderr[:j]
+			stderr = stderr[j+len(errSep):]
+		} else {
+			result.Stderr = stderr
+			stderr = nil
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
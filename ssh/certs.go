@@ -37,6 +37,14 @@ const (
 type Signature struct {
 	Format string
 	Blob   []byte
+
+	// Rest holds the fields that follow the blob in the signatures of
+	// security keys: a flags byte, see SKFlagUserPresent and
+	// SKFlagUserVerified, and a uint32 signature counter. It is
+	// empty for other signatures. Unmarshal stores any trailing data
+	// in Rest whatever the format, so callers that unmarshal a
+	// Signature must reject a non-empty Rest for other formats.
+	Rest []byte `ssh:"rest"`
 }
 
 // CertTimeInfinity can be used for OpenSSHCertV01.ValidBefore to indicate that
@@ -241,6 +249,14 @@ func (s *openSSHCertSigner) PublicKey() PublicKey {
 
 const sourceAddressCriticalOption = "source-address"
 
+// Options that control the flags required from security key signatures.
+// They match the authorized_keys options and certificate fields of
+// OpenSSH.
+const (
+	verifyRequiredCriticalOption = "verify-required"
+	noTouchRequiredExtension     = "no-touch-required"
+)
+
 // CertChecker does the work of verifying a certificate. Its methods
 // can be plugged into ClientConfig.HostKeyCallback and
 // ServerConfig.PublicKeyCallback. For the CertChecker to work,
@@ -264,8 +280,11 @@ type CertChecker struct {
 	// CertChecker will be checking host certificates.
 	IsHostAuthority func(auth PublicKey, address string) bool
 
-	// Clock is used for verifying time stamps. If nil, time.Now
-	// is used.
+	// Clock is used for verifying time stamps. It applies equally
+	// to host certificates checked by CheckHostKey and to user
+	// certificates checked by Authenticate, since both go through
+	// CheckCert. Setting it allows validating certificates as of a
+	// specific point in time. If nil, time.Now is used.
 	Clock func() time.Time
 
 	// UserKeyFallback is called when CertChecker.Authenticate encounters a
@@ -283,6 +302,13 @@ type CertChecker struct {
 	// is revoked and false otherwise. If nil, no certificates are
 	// considered to have been revoked.
 	IsRevoked func(cert *Certificate) bool
+
+	// AcceptCert, if non-nil, is called for each certificate that
+	// passed all other checks, including the authority, principal,
+	// validity period and signature checks. It should return true if
+	// the certificate is acceptable, for example because its Serial or
+	// KeyId is on an allow-list, and false to reject it.
+	AcceptCert func(cert *Certificate) bool
 }
 
 // CheckHostKey checks a host key certificate. This method can be
@@ -311,6 +337,18 @@ func (c *CertChecker) CheckHostKey(addr string, remote net.Addr, key PublicKey)
 	return c.CheckCert(hostname, cert)
 }
 
+// ConfigureClient makes config verify host keys with c. It sets
+// HostKeyCallback to c.CheckHostKey and, if HostKeyAlgorithms is set,
+// expands it with CertAlgoNames, as servers only present their host
+// certificates when the client asks for the certificate algorithms. The
+// default host key algorithms already include them.
+func (c *CertChecker) ConfigureClient(config *ClientConfig) {
+	config.HostKeyCallback = c.CheckHostKey
+	if len(config.HostKeyAlgorithms) > 0 {
+		config.HostKeyAlgorithms = CertAlgoNames(config.HostKeyAlgorithms)
+	}
+}
+
 // Authenticate checks a user certificate. Authenticate can be used as
 // a value for ServerConfig.PublicKeyCallback.
 func (c *CertChecker) Authenticate(conn ConnMetadata, pubKey PublicKey) (*Permissions, error) {
@@ -336,17 +374,26 @@ func (c *CertChecker) Authenticate(conn ConnMetadata, pubKey PublicKey) (*Permis
 	return &cert.Permissions, nil
 }
 
+// now returns the current time according to c.Clock.
+func (c *CertChecker) now() time.Time {
+	if c.Clock != nil {
+		return c.Clock()
+	}
+	return time.Now()
+}
+
 // CheckCert checks CriticalOptions, ValidPrincipals, revocation, timestamp and
-// the signature of the certificate.
+// the signature of the certificate, and finally consults AcceptCert.
 func (c *CertChecker) CheckCert(principal string, cert *Certificate) error {
 	if c.IsRevoked != nil && c.IsRevoked(cert) {
 		return fmt.Errorf("ssh: certicate serial %d revoked", cert.Serial)
 	}
 
 	for opt, _ := range cert.CriticalOptions {
-		// sourceAddressCriticalOption will be enforced by
+		// sourceAddressCriticalOption and
+		// verifyRequiredCriticalOption will be enforced by
 		// serverAuthenticate
-		if opt == sourceAddressCriticalOption {
+		if opt == sourceAddressCriticalOption || opt == verifyRequiredCriticalOption {
 			continue
 		}
 
@@ -376,12 +423,7 @@ func (c *CertChecker) CheckCert(principal string, cert *Certificate) error {
 		}
 	}
 
-	clock := c.Clock
-	if clock == nil {
-		clock = time.Now
-	}
-
-	unixNow := clock().Unix()
+	unixNow := c.now().Unix()
 	if after := int64(cert.ValidAfter); after < 0 || unixNow < int64(cert.ValidAfter) {
 		return fmt.Errorf("ssh: cert is not yet valid")
 	}
@@ -391,6 +433,9 @@ func (c *CertChecker) CheckCert(principal string, cert *Certificate) error {
 	if err := cert.SignatureKey.Verify(cert.bytesForSigning(), cert.Signature); err != nil {
 		return fmt.Errorf("ssh: certificate signature does not verify")
 	}
+	if c.AcceptCert != nil && !c.AcceptCert(cert) {
+		return fmt.Errorf("ssh: certificate serial %d with key id %q not accepted", cert.Serial, cert.KeyId)
+	}
 
 	return nil
 }
@@ -421,6 +466,26 @@ var certAlgoNames = map[string]string{
 	KeyAlgoED25519:  CertAlgoED25519v01,
 }
 
+// CertAlgoNames returns algos preceded by the certificate algorithms of
+// the plain key algorithms it lists, in the same order, as in the
+// HostKeyAlgorithms of a client that prefers host certificates. Names
+// without a certificate form, and certificate algorithms that are
+// already listed, are left as they are.
+func CertAlgoNames(algos []string) []string {
+	var result []string
+	for _, algo := range algos {
+		if certAlgo, ok := certAlgoNames[algo]; ok && !containsMethod(algos, certAlgo) && !containsMethod(result, certAlgo) {
+			result = append(result, certAlgo)
+		}
+	}
+	for _, algo := range algos {
+		if !containsMethod(result, algo) {
+			result = append(result, algo)
+		}
+	}
+	return result
+}
+
 // certToPrivAlgo returns the underlying algorithm for a certificate algorithm.
 // Panics if a non-certificate algorithm is passed.
 func certToPrivAlgo(algo string) string {
@@ -502,6 +567,12 @@ func parseSignatureBody(in []byte) (out *Signature, rest []byte, ok bool) {
 		return
 	}
 
+	switch out.Format {
+	case KeyAlgoSKECDSA256, KeyAlgoSKED25519:
+		out.Rest = in
+		return out, nil, ok
+	}
+
 	return out, in, ok
 }
 
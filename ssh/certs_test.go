@@ -153,11 +153,179 @@ func TestValidateCertTime(t *testing.T) {
 	}
 }
 
+func TestCertCheckerClock(t *testing.T) {
+	for _, certType := range []uint32{UserCert, HostCert} {
+		cert := &Certificate{
+			ValidPrincipals: []string{"user", "hostname"},
+			Key:             testPublicKeys["rsa"],
+			ValidAfter:      50,
+			ValidBefore:     100,
+			CertType:        certType,
+		}
+		cert.SignCert(rand.Reader, testSigners["ecdsa"])
+
+		isAuthority := func(k PublicKey) bool {
+			return bytes.Equal(k.Marshal(), testPublicKeys["ecdsa"].Marshal())
+		}
+
+		for ts, ok := range map[int64]bool{
+			25:  false,
+			75:  true,
+			125: false,
+		} {
+			checker := CertChecker{
+				Clock:           func() time.Time { return time.Unix(ts, 0) },
+				IsUserAuthority: isAuthority,
+				IsHostAuthority: func(k PublicKey, addr string) bool {
+					return isAuthority(k)
+				},
+			}
+
+			var err error
+			if certType == UserCert {
+				_, err = checker.Authenticate(&sshConn{user: "user"}, cert)
+			} else {
+				err = checker.CheckHostKey("hostname:22", nil, cert)
+			}
+			if (err == nil) != ok {
+				t.Errorf("cert type %d at %d: got %v, want ok=%v", certType, ts, err, ok)
+			}
+		}
+	}
+}
+
+func TestCertCheckerAcceptCert(t *testing.T) {
+	allowed := map[uint64]bool{7: true}
+	for _, certType := range []uint32{UserCert, HostCert} {
+		for serial, ok := range map[uint64]bool{7: true, 8: false} {
+			cert := &Certificate{
+				Serial:          serial,
+				KeyId:           "key",
+				ValidPrincipals: []string{"user", "hostname"},
+				Key:             testPublicKeys["rsa"],
+				ValidBefore:     CertTimeInfinity,
+				CertType:        certType,
+			}
+			cert.SignCert(rand.Reader, testSigners["ecdsa"])
+
+			var called bool
+			checker := CertChecker{
+				IsUserAuthority: func(k PublicKey) bool { return true },
+				IsHostAuthority: func(k PublicKey, addr string) bool { return true },
+				AcceptCert: func(c *Certificate) bool {
+					called = true
+					return allowed[c.Serial]
+				},
+			}
+
+			var err error
+			if certType == UserCert {
+				_, err = checker.Authenticate(&sshConn{user: "user"}, cert)
+			} else {
+				err = checker.CheckHostKey("hostname:22", nil, cert)
+			}
+			if (err == nil) != ok || !called {
+				t.Errorf("cert type %d serial %d: got %v (called %v), want ok=%v", certType, serial, err, called, ok)
+			}
+		}
+	}
+
+	// AcceptCert is not consulted for certificates that fail the
+	// other checks.
+	cert := &Certificate{
+		Key:         testPublicKeys["rsa"],
+		ValidBefore: CertTimeInfinity,
+		CertType:    UserCert,
+	}
+	cert.SignCert(rand.Reader, testSigners["ecdsa"])
+	cert.Signature.Blob[0]++
+	checker := CertChecker{
+		AcceptCert: func(c *Certificate) bool {
+			t.Error("AcceptCert called for a certificate with a bad signature")
+			return true
+		},
+	}
+	if err := checker.CheckCert("user", cert); err == nil {
+		t.Error("certificate with a bad signature accepted")
+	}
+}
+
 // TODO(hanwen): tests for
 //
 // host keys:
 // * fallbacks
 
+func TestCertAlgoNames(t *testing.T) {
+	for _, test := range []struct {
+		in, want []string
+	}{
+		{nil, nil},
+		{[]string{KeyAlgoED25519}, []string{CertAlgoED25519v01, KeyAlgoED25519}},
+		{
+			[]string{KeyAlgoECDSA256, KeyAlgoRSA, "unknown"},
+			[]string{CertAlgoECDSA256v01, CertAlgoRSAv01, KeyAlgoECDSA256, KeyAlgoRSA, "unknown"},
+		},
+		{
+			[]string{KeyAlgoRSA, CertAlgoRSAv01, KeyAlgoED25519, KeyAlgoED25519},
+			[]string{CertAlgoED25519v01, KeyAlgoRSA, CertAlgoRSAv01, KeyAlgoED25519},
+		},
+	} {
+		if got := CertAlgoNames(test.in); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("CertAlgoNames(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestCertCheckerConfigureClient(t *testing.T) {
+	cert := &Certificate{
+		ValidPrincipals: []string{"hostname"},
+		Key:             testPublicKeys["ecdsa"],
+		ValidBefore:     CertTimeInfinity,
+		CertType:        HostCert,
+	}
+	cert.SignCert(rand.Reader, testSigners["rsa"])
+	certSigner, err := NewCertSigner(cert, testSigners["ecdsa"])
+	if err != nil {
+		t.Fatalf("NewCertSigner: %v", err)
+	}
+
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	go func() {
+		conf := ServerConfig{
+			NoClientAuth: true,
+		}
+		conf.AddHostKey(certSigner)
+		conf.AddHostKey(testSigners["ecdsa"])
+		NewServerConn(c1, &conf)
+	}()
+
+	checker := &CertChecker{
+		IsHostAuthority: func(p PublicKey, addr string) bool {
+			return bytes.Equal(testPublicKeys["rsa"].Marshal(), p.Marshal())
+		},
+	}
+	config := &ClientConfig{
+		User:              "user",
+		HostKeyAlgorithms: []string{KeyAlgoECDSA256},
+	}
+	checker.ConfigureClient(config)
+	if want := []string{CertAlgoECDSA256v01, KeyAlgoECDSA256}; !reflect.DeepEqual(config.HostKeyAlgorithms, want) {
+		t.Errorf("got HostKeyAlgorithms %q, want %q", config.HostKeyAlgorithms, want)
+	}
+
+	// Without the certificate algorithm, the server would present
+	// its plain key, which the checker rejects.
+	if _, _, _, err := NewClientConn(c2, "hostname:22", config); err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+}
+
 func TestHostKeyCert(t *testing.T) {
 	cert := &Certificate{
 		ValidPrincipals: []string{"hostname", "hostname.domain", "otherhost"},
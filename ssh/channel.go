@@ -11,6 +11,7 @@ import (
 	"io"
 	"log"
 	"sync"
+	"sync/atomic"
 )
 
 const (
@@ -69,6 +70,12 @@ type Channel interface {
 	// if the data stream is closed or blocked by flow control.
 	// If the channel is closed before a reply is returned, io.EOF
 	// is returned.
+	//
+	// It is safe to call SendRequest from several goroutines. Since
+	// replies carry no request identifier, requests with wantReply
+	// set are sent one at a time, each waiting for its own reply
+	// before the next is sent. The peer answers them through the
+	// Reply method of the Request it receives.
 	SendRequest(name string, wantReply bool, payload []byte) (bool, error)
 
 	// Stderr returns an io.ReadWriter that writes to this channel
@@ -78,6 +85,33 @@ type Channel interface {
 	Stderr() io.ReadWriter
 }
 
+// A MaxPacketSizer reports the maximum packet size announced by the
+// peer for a channel, which is the largest amount of data that is sent
+// in a single packet; writes are split into packets of at most this
+// size. The Channels of this package implement it, so callers can
+// type-assert a Channel to MaxPacketSizer.
+type MaxPacketSizer interface {
+	MaxPacketSize() uint32
+}
+
+// A WindowController gives control over the flow-control window that a
+// channel grants to the peer. The Channels of this package implement
+// it, so callers can type-assert a Channel to WindowController.
+type WindowController interface {
+	// SetAutoWindowAdjust controls how the window grows. By default,
+	// the window is extended by the amount of data read from the
+	// channel, so that the peer can send as much again. When
+	// disabled, the peer may only send as much data as ExtendWindow
+	// has granted, which lets a proxy apply backpressure from a
+	// slow consumer.
+	SetAutoWindowAdjust(auto bool)
+
+	// ExtendWindow allows the peer to send n more bytes. It is meant
+	// to be used after auto adjustment has been disabled with
+	// SetAutoWindowAdjust.
+	ExtendWindow(n uint32) error
+}
+
 // Request is a request sent outside of the normal stream of
 // data. Requests can either be specific to an SSH channel, or they
 // can be global.
@@ -178,6 +212,13 @@ type channel struct {
 	// goroutine that has such an outgoing request pending.
 	sentRequestMu sync.Mutex
 
+	// awaitingReply is 1 while the request sent under sentRequestMu
+	// has not been answered. Replies that arrive while it is 0 do
+	// not belong to any request and are dropped, so that they cannot
+	// be mistaken for the answer to a later request. It is accessed
+	// atomically.
+	awaitingReply int32
+
 	incomingRequests chan *Request
 
 	sentEOF bool
@@ -187,9 +228,12 @@ type channel struct {
 	pending    *buffer
 	extPending *buffer
 
-	// windowMu protects myWindow, the flow-control window.
-	windowMu sync.Mutex
-	myWindow uint32
+	// windowMu protects myWindow, the flow-control window, and
+	// manualWindow, which is set when the application extends the
+	// window itself instead of having reads do it.
+	windowMu     sync.Mutex
+	myWindow     uint32
+	manualWindow bool
 
 	// writeMu serializes calls to mux.conn.writePacket() and
 	// protects sentClose and packetPool. This mutex must be
@@ -334,15 +378,30 @@ func (c *channel) handleData(packet []byte) error {
 
 func (c *channel) adjustWindow(n uint32) error {
 	c.windowMu.Lock()
-	// Since myWindow is managed on our side, and can never exceed
-	// the initial window setting, we don't worry about overflow.
-	c.myWindow += uint32(n)
+	// myWindow can be extended by hand with ExtendWindow, so it may
+	// grow past the initial window setting; check for overflow under
+	// the same lock as the addition.
+	if c.myWindow+n < c.myWindow {
+		c.windowMu.Unlock()
+		return errors.New("ssh: window extension overflows")
+	}
+	c.myWindow += n
 	c.windowMu.Unlock()
 	return c.sendMessage(windowAdjustMsg{
-		AdditionalBytes: uint32(n),
+		AdditionalBytes: n,
 	})
 }
 
+func (c *channel) SetAutoWindowAdjust(auto bool) {
+	c.windowMu.Lock()
+	c.manualWindow = !auto
+	c.windowMu.Unlock()
+}
+
+func (c *channel) ExtendWindow(n uint32) error {
+	return c.adjustWindow(n)
+}
+
 func (c *channel) ReadExtended(data []byte, extended uint32) (n int, err error) {
 	switch extended {
 	case 1:
@@ -353,7 +412,11 @@ func (c *channel) ReadExtended(data []byte, extended uint32) (n int, err error)
 		return 0, fmt.Errorf("ssh: extended code %d unimplemented", extended)
 	}
 
-	if n > 0 {
+	c.windowMu.Lock()
+	manual := c.manualWindow
+	c.windowMu.Unlock()
+
+	if n > 0 && !manual {
 		err = c.adjustWindow(uint32(n))
 		// sendWindowAdjust can return io.EOF if the remote
 		// peer has closed the connection, however we want to
@@ -448,6 +511,10 @@ func (c *channel) handlePacket(packet []byte) error {
 		}
 
 		c.incomingRequests <- &req
+	case *channelRequestSuccessMsg, *channelRequestFailureMsg:
+		if atomic.CompareAndSwapInt32(&c.awaitingReply, 1, 0) {
+			c.msg <- msg
+		}
 	default:
 		c.msg <- msg
 	}
@@ -518,6 +585,9 @@ func (ch *channel) Reject(reason RejectionReason, message string) error {
 		Language: "en",
 	}
 	ch.decided = true
+	// The peer forgets about the channel once it sees the failure,
+	// so there is no close handshake to wait for.
+	ch.mux.chanList.remove(ch.localId)
 	return ch.sendMessage(reject)
 }
 
@@ -583,7 +653,11 @@ func (ch *channel) SendRequest(name string, wantReply bool, payload []byte) (boo
 		RequestSpecificData: payload,
 	}
 
+	if wantReply {
+		atomic.StoreInt32(&ch.awaitingReply, 1)
+	}
 	if err := ch.sendMessage(msg); err != nil {
+		atomic.StoreInt32(&ch.awaitingReply, 0)
 		return false, err
 	}
 
@@ -597,6 +671,8 @@ func (ch *channel) SendRequest(name string, wantReply bool, payload []byte) (boo
 			return false, nil
 		case *channelRequestSuccessMsg:
 			return true, nil
+		case *unimplementedMsg:
+			return false, ErrUnimplemented
 		default:
 			return false, fmt.Errorf("ssh: unexpected response to channel request: %#v", m)
 		}
@@ -605,6 +681,33 @@ func (ch *channel) SendRequest(name string, wantReply bool, payload []byte) (boo
 	return false, nil
 }
 
+// SendExitStatus sends an "exit-status" request on ch carrying the exit
+// code of the command run on a session channel. See RFC 4254, section
+// 6.10.
+func SendExitStatus(ch Channel, code uint32) error {
+	_, err := ch.SendRequest("exit-status", false, Marshal(&exitStatusMsg{
+		Status: code,
+	}))
+	return err
+}
+
+// SendExitSignal sends an "exit-signal" request on ch, reporting that
+// the command run on a session channel was terminated by signal, given
+// without the "SIG" prefix. See RFC 4254, section 6.10.
+func SendExitSignal(ch Channel, signal string, coreDumped bool, msg, lang string) error {
+	_, err := ch.SendRequest("exit-signal", false, Marshal(&exitSignalMsg{
+		Signal:     signal,
+		CoreDumped: coreDumped,
+		Errmsg:     msg,
+		Lang:       lang,
+	}))
+	return err
+}
+
+func (ch *channel) MaxPacketSize() uint32 {
+	return ch.maxRemotePayload
+}
+
 // ackRequest either sends an ack or nack to the channel request.
 func (ch *channel) ackRequest(ok bool) error {
 	if !ch.decided {
@@ -16,6 +16,9 @@ import (
 	"hash"
 	"io"
 	"io/ioutil"
+
+	"golang.org/x/crypto/internal/chacha20"
+	"golang.org/x/crypto/poly1305"
 )
 
 const (
@@ -31,8 +34,34 @@ const (
 	// length fields do not overflow, so it should remain well
 	// below 4G.
 	maxPacket = 256 * 1024
+
+	// minPacketLimit and maxPacketLimit bound Config.MaxPacketLength.
+	// RFC 4253 section 6.1 requires packets with a total length of
+	// 35000 bytes to be accepted. The upper bound keeps the length
+	// arithmetic of the packetCiphers far from overflowing.
+	minPacketLimit = 35000
+	maxPacketLimit = 16 << 20
 )
 
+// packetLimit is embedded in the packetCiphers to hold the largest
+// packet length they read. Incoming lengths are checked against it
+// before any buffer is allocated for the packet.
+type packetLimit struct {
+	limit uint32
+}
+
+func (l *packetLimit) setMaxPacket(n uint32) {
+	l.limit = n
+}
+
+// maxReadPacket returns the limit, which is maxPacket unless it was set.
+func (l *packetLimit) maxReadPacket() uint32 {
+	if l.limit == 0 {
+		return maxPacket
+	}
+	return l.limit
+}
+
 // noneCipher implements cipher.Stream and provides no encryption. It is used
 // by the transport before the first key-exchange.
 type noneCipher struct{}
@@ -114,7 +143,12 @@ var cipherModes = map[string]*streamCipherMode{
 	// AES-GCM is not a stream cipher, so it is constructed with a
 	// special case. If we add any more non-stream ciphers, we
 	// should invest a cleaner way to do this.
-	gcmCipherID: {16, 12, 0, nil},
+	gcmCipherID:    {16, 12, 0, nil},
+	gcm256CipherID: {32, 12, 0, nil},
+
+	// chacha20-poly1305@openssh.com is an AEAD too, keyed with two
+	// 256-bit keys, and has no IV.
+	chacha20Poly1305ID: {64, 0, 0, nil},
 
 	// CBC mode is insecure and so is not included in the default config.
 	// (See http://www.isg.rhul.ac.uk/~kp/SandPfinal.pdf). If absolutely
@@ -133,6 +167,8 @@ const prefixLen = 5
 
 // streamPacketCipher is a packetCipher using a stream cipher.
 type streamPacketCipher struct {
+	packetLimit
+
 	mac    hash.Hash
 	cipher cipher.Stream
 	etm    bool
@@ -180,11 +216,11 @@ func (s *streamPacketCipher) readPacket(seqNum uint32, r io.Reader) ([]byte, err
 		return nil, errors.New("ssh: invalid packet length, packet too small")
 	}
 
-	if length > maxPacket {
+	if length > s.maxReadPacket() {
 		return nil, errors.New("ssh: invalid packet length, packet too large")
 	}
 
-	// the maxPacket check above ensures that length-1+macSize
+	// the maxReadPacket check above ensures that length-1+macSize
 	// does not overflow.
 	if uint32(cap(s.packetData)) < length-1+macSize {
 		s.packetData = make([]byte, length-1+macSize)
@@ -297,11 +333,29 @@ func (s *streamPacketCipher) writePacket(seqNum uint32, w io.Writer, rand io.Rea
 	return nil
 }
 
+// gcmMaxInvocations is the number of packets a gcmCipher processes
+// before refusing to process more. The nonce is a 4 byte fixed field
+// followed by an 8 byte invocation counter that is incremented for each
+// packet (RFC 5647, section 7.1); reusing a nonce with the same key
+// would reveal the authentication key. The handshake transport rekeys
+// long before this, after packetRekeyThreshold packets, which replaces
+// the cipher; the limit is a safeguard should the rekey not happen.
+const gcmMaxInvocations = 1 << 32
+
+var errGCMInvocationsExhausted = errors.New("ssh: GCM invocation counter exhausted, a key exchange is required")
+
 type gcmCipher struct {
+	packetLimit
+
 	aead   cipher.AEAD
 	prefix [4]byte
 	iv     []byte
 	buf    []byte
+
+	// invocations counts the packets processed with this key;
+	// maxInvocations is the limit.
+	invocations    uint64
+	maxInvocations uint64
 }
 
 func newGCMCipher(iv, key, macKey []byte) (packetCipher, error) {
@@ -316,14 +370,19 @@ func newGCMCipher(iv, key, macKey []byte) (packetCipher, error) {
 	}
 
 	return &gcmCipher{
-		aead: aead,
-		iv:   iv,
+		aead:           aead,
+		iv:             iv,
+		maxInvocations: gcmMaxInvocations,
 	}, nil
 }
 
 const gcmTagSize = 16
 
 func (c *gcmCipher) writePacket(seqNum uint32, w io.Writer, rand io.Reader, packet []byte) error {
+	if c.invocations >= c.maxInvocations {
+		return errGCMInvocationsExhausted
+	}
+
 	// Pad out to multiple of 16 bytes. This is different from the
 	// stream cipher because that encrypts the length too.
 	padding := byte(packetSizeMultiple - (1+len(packet))%packetSizeMultiple)
@@ -357,7 +416,10 @@ func (c *gcmCipher) writePacket(seqNum uint32, w io.Writer, rand io.Reader, pack
 	return nil
 }
 
+// incIV increments the invocation counter in the last 8 bytes of the
+// nonce, modulo 2^64, leaving the fixed field untouched.
 func (c *gcmCipher) incIV() {
+	c.invocations++
 	for i := 4 + 7; i >= 4; i-- {
 		c.iv[i]++
 		if c.iv[i] != 0 {
@@ -367,11 +429,14 @@ func (c *gcmCipher) incIV() {
 }
 
 func (c *gcmCipher) readPacket(seqNum uint32, r io.Reader) ([]byte, error) {
+	if c.invocations >= c.maxInvocations {
+		return nil, errGCMInvocationsExhausted
+	}
 	if _, err := io.ReadFull(r, c.prefix[:]); err != nil {
 		return nil, err
 	}
 	length := binary.BigEndian.Uint32(c.prefix[:])
-	if length > maxPacket {
+	if length > c.maxReadPacket() {
 		return nil, errors.New("ssh: max packet length exceeded.")
 	}
 
@@ -407,6 +472,8 @@ func (c *gcmCipher) readPacket(seqNum uint32, r io.Reader) ([]byte, error) {
 
 // cbcCipher implements aes128-cbc cipher defined in RFC 4253 section 6.1
 type cbcCipher struct {
+	packetLimit
+
 	mac       hash.Hash
 	macSize   uint32
 	decrypter cipher.BlockMode
@@ -508,11 +575,11 @@ func (c *cbcCipher) readPacketLeaky(seqNum uint32, r io.Reader) ([]byte, error)
 		return nil, err
 	}
 
-	c.oracleCamouflage = maxPacket + 4 + c.macSize - firstBlockLength
+	c.oracleCamouflage = c.maxReadPacket() + 4 + c.macSize - firstBlockLength
 
 	c.decrypter.CryptBlocks(firstBlock, firstBlock)
 	length := binary.BigEndian.Uint32(firstBlock[:4])
-	if length > maxPacket {
+	if length > c.maxReadPacket() {
 		return nil, cbcError("ssh: packet too large")
 	}
 	if length+4 < maxUInt32(cbcMinPacketSize, blockSize) {
@@ -627,3 +694,145 @@ func (c *cbcCipher) writePacket(seqNum uint32, w io.Writer, rand io.Reader, pack
 
 	return nil
 }
+
+// chacha20Poly1305Cipher implements the chacha20-poly1305@openssh.com
+// AEAD, which is described in OpenSSH's PROTOCOL.chacha20poly1305 and in
+//
+//	https://tools.ietf.org/html/draft-josefsson-ssh-chacha20-poly1305-openssh-00
+//
+// Two ChaCha20 instances are used, with the original 64-bit nonce and
+// counter layout: one keyed with the second half of the key encrypts the
+// packet length, the other, keyed with the first half, derives the
+// Poly1305 key from block 0 and encrypts the packet from block 1. The
+// nonce is the packet sequence number, so the transport's sequence
+// numbering must never be reset without a rekey.
+type chacha20Poly1305Cipher struct {
+	packetLimit
+
+	lengthKey  [32]byte
+	contentKey [32]byte
+	buf        []byte
+}
+
+func newChaCha20Cipher(key []byte) (packetCipher, error) {
+	if len(key) != 64 {
+		return nil, fmt.Errorf("ssh: invalid key length %d for %s", len(key), chacha20Poly1305ID)
+	}
+
+	c := &chacha20Poly1305Cipher{
+		buf: make([]byte, 256),
+	}
+	copy(c.contentKey[:], key[:32])
+	copy(c.lengthKey[:], key[32:])
+	return c, nil
+}
+
+// chacha20Counter returns the ChaCha20 input block for the given block
+// counter and packet sequence number: a 64-bit little-endian counter
+// followed by the sequence number as a 64-bit big-endian nonce.
+func chacha20Counter(block byte, seqNum uint32) *[16]byte {
+	var counter [16]byte
+	counter[0] = block
+	binary.BigEndian.PutUint64(counter[8:], uint64(seqNum))
+	return &counter
+}
+
+// chacha20PolyKeyInput is encrypted to obtain the Poly1305 key.
+var chacha20PolyKeyInput [32]byte
+
+func (c *chacha20Poly1305Cipher) readPacket(seqNum uint32, r io.Reader) ([]byte, error) {
+	var polyKey [32]byte
+	chacha20.XORKeyStream(polyKey[:], chacha20PolyKeyInput[:], chacha20Counter(0, seqNum), &c.contentKey)
+
+	encryptedLength := c.buf[:4]
+	if _, err := io.ReadFull(r, encryptedLength); err != nil {
+		return nil, err
+	}
+
+	var lenBytes [4]byte
+	chacha20.XORKeyStream(lenBytes[:], encryptedLength, chacha20Counter(0, seqNum), &c.lengthKey)
+
+	length := binary.BigEndian.Uint32(lenBytes[:])
+	if length > c.maxReadPacket() {
+		return nil, errors.New("ssh: max packet length exceeded.")
+	}
+
+	contentEnd := 4 + length
+	packetEnd := contentEnd + poly1305.TagSize
+	if uint32(cap(c.buf)) < packetEnd {
+		c.buf = make([]byte, packetEnd)
+		copy(c.buf, encryptedLength)
+	} else {
+		c.buf = c.buf[:packetEnd]
+	}
+
+	if _, err := io.ReadFull(r, c.buf[4:packetEnd]); err != nil {
+		return nil, err
+	}
+
+	// The tag covers the encrypted length and packet, and is checked
+	// before anything is decrypted.
+	var mac [poly1305.TagSize]byte
+	copy(mac[:], c.buf[contentEnd:packetEnd])
+	if !poly1305.Verify(&mac, c.buf[:contentEnd], &polyKey) {
+		return nil, errors.New("ssh: MAC failure")
+	}
+
+	plain := c.buf[4:contentEnd]
+	chacha20.XORKeyStream(plain, plain, chacha20Counter(1, seqNum), &c.contentKey)
+
+	if len(plain) == 0 {
+		return nil, errors.New("ssh: empty packet")
+	}
+	padding := plain[0]
+	if padding < 4 {
+		// padding is a byte, so it automatically satisfies
+		// the maximum size, which is 255.
+		return nil, fmt.Errorf("ssh: illegal padding %d", padding)
+	}
+
+	if int(padding)+1 >= len(plain) {
+		return nil, fmt.Errorf("ssh: padding %d too large", padding)
+	}
+
+	return plain[1 : len(plain)-int(padding)], nil
+}
+
+func (c *chacha20Poly1305Cipher) writePacket(seqNum uint32, w io.Writer, rand io.Reader, payload []byte) error {
+	var polyKey [32]byte
+	chacha20.XORKeyStream(polyKey[:], chacha20PolyKeyInput[:], chacha20Counter(0, seqNum), &c.contentKey)
+
+	// There is no block size, so pad to a multiple of 8 bytes, as
+	// RFC 4253, section 6 requires at least.
+	const paddingMultiple = 8
+	padding := paddingMultiple - (1+len(payload))%paddingMultiple
+	if padding < 4 {
+		padding += paddingMultiple
+	}
+
+	// length (4 bytes), padding length (1), payload, padding, tag.
+	totalLength := 4 + 1 + len(payload) + padding + poly1305.TagSize
+	if cap(c.buf) < totalLength {
+		c.buf = make([]byte, totalLength)
+	} else {
+		c.buf = c.buf[:totalLength]
+	}
+
+	binary.BigEndian.PutUint32(c.buf, uint32(1+len(payload)+padding))
+	chacha20.XORKeyStream(c.buf[:4], c.buf[:4], chacha20Counter(0, seqNum), &c.lengthKey)
+	c.buf[4] = byte(padding)
+	copy(c.buf[5:], payload)
+	packetEnd := 5 + len(payload) + padding
+	if _, err := io.ReadFull(rand, c.buf[5+len(payload):packetEnd]); err != nil {
+		return err
+	}
+
+	chacha20.XORKeyStream(c.buf[4:packetEnd], c.buf[4:packetEnd], chacha20Counter(1, seqNum), &c.contentKey)
+
+	var mac [poly1305.TagSize]byte
+	poly1305.Sum(&mac, c.buf[:packetEnd], &polyKey)
+	copy(c.buf[packetEnd:], mac[:])
+
+	_, err := w.Write(c.buf)
+	return err
+}
@@ -9,6 +9,7 @@ import (
 	"crypto"
 	"crypto/aes"
 	"crypto/rand"
+	"encoding/hex"
 	"testing"
 )
 
@@ -21,9 +22,9 @@ func TestDefaultCiphersExist(t *testing.T) {
 }
 
 func TestPacketCiphers(t *testing.T) {
-	// Still test aes128cbc cipher although it's commented out.
+	// Still test aes128cbc cipher although it's not enabled by default.
+	defer func(m *streamCipherMode) { cipherModes[aes128cbcID] = m }(cipherModes[aes128cbcID])
 	cipherModes[aes128cbcID] = &streamCipherMode{16, aes.BlockSize, 0, nil}
-	defer delete(cipherModes, aes128cbcID)
 
 	for cipher := range cipherModes {
 		for mac := range macModes {
@@ -65,9 +66,53 @@ func TestPacketCiphers(t *testing.T) {
 	}
 }
 
+func TestPacketCiphersMaxPacket(t *testing.T) {
+	defer func(m *streamCipherMode) { cipherModes[aes128cbcID] = m }(cipherModes[aes128cbcID])
+	cipherModes[aes128cbcID] = &streamCipherMode{16, aes.BlockSize, 0, nil}
+
+	input := make([]byte, 50000)
+	for cipher := range cipherModes {
+		kr := &kexResult{Hash: crypto.SHA1}
+		algs := directionAlgorithms{
+			Cipher:      cipher,
+			MAC:         "hmac-sha2-256",
+			Compression: "none",
+		}
+		for _, tt := range []struct {
+			limit uint32
+			ok    bool
+		}{
+			{40000, false},
+			{60000, true},
+		} {
+			client, err := newPacketCipher(clientKeys, algs, kr)
+			if err != nil {
+				t.Fatalf("newPacketCipher(client, %q): %v", cipher, err)
+			}
+			server, err := newPacketCipher(clientKeys, algs, kr)
+			if err != nil {
+				t.Fatalf("newPacketCipher(server, %q): %v", cipher, err)
+			}
+			server.setMaxPacket(tt.limit)
+
+			buf := &bytes.Buffer{}
+			if err := client.writePacket(0, buf, rand.Reader, input); err != nil {
+				t.Fatalf("writePacket(%q): %v", cipher, err)
+			}
+			packet, err := server.readPacket(0, buf)
+			if tt.ok && (err != nil || len(packet) != len(input)) {
+				t.Errorf("readPacket(%q) with limit %d: got %d bytes, %v", cipher, tt.limit, len(packet), err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("readPacket(%q) with limit %d accepted a %d byte packet", cipher, tt.limit, len(input))
+			}
+		}
+	}
+}
+
 func TestCBCOracleCounterMeasure(t *testing.T) {
+	defer func(m *streamCipherMode) { cipherModes[aes128cbcID] = m }(cipherModes[aes128cbcID])
 	cipherModes[aes128cbcID] = &streamCipherMode{16, aes.BlockSize, 0, nil}
-	defer delete(cipherModes, aes128cbcID)
 
 	kr := &kexResult{Hash: crypto.SHA1}
 	algs := directionAlgorithms{
@@ -127,3 +172,155 @@ func TestCBCOracleCounterMeasure(t *testing.T) {
 		lastRead = bytesRead
 	}
 }
+
+func newTestGCMPair(t *testing.T, cipherID string, iv []byte) (client, server *gcmCipher) {
+	kr := &kexResult{Hash: crypto.SHA256}
+	algs := directionAlgorithms{
+		Cipher:      cipherID,
+		MAC:         "hmac-sha2-256",
+		Compression: "none",
+	}
+	for _, c := range []**gcmCipher{&client, &server} {
+		pc, err := newPacketCipher(clientKeys, algs, kr)
+		if err != nil {
+			t.Fatalf("newPacketCipher(%q): %v", cipherID, err)
+		}
+		*c = pc.(*gcmCipher)
+		if iv != nil {
+			copy((*c).iv, iv)
+		}
+	}
+	return client, server
+}
+
+func TestGCMInvocationCounter(t *testing.T) {
+	for _, cipherID := range []string{gcmCipherID, gcm256CipherID} {
+		// Start just below a carry across the low bytes of the counter.
+		iv := []byte{1, 2, 3, 4, 0, 0, 0, 0, 0, 0xff, 0xff, 0xf0}
+		client, server := newTestGCMPair(t, cipherID, iv)
+
+		seen := make(map[string]bool)
+		buf := &bytes.Buffer{}
+		for i := 0; i < 1000; i++ {
+			nonce := string(client.iv)
+			if seen[nonce] {
+				t.Fatalf("%s: nonce %x repeated after %d packets", cipherID, client.iv, i)
+			}
+			seen[nonce] = true
+
+			if err := client.writePacket(uint32(i), buf, rand.Reader, []byte("packet")); err != nil {
+				t.Fatalf("%s: writePacket: %v", cipherID, err)
+			}
+			if p, err := server.readPacket(uint32(i), buf); err != nil || string(p) != "packet" {
+				t.Fatalf("%s: readPacket: %q, %v", cipherID, p, err)
+			}
+			if !bytes.Equal(client.iv, server.iv) {
+				t.Fatalf("%s: nonces out of sync: %x != %x", cipherID, client.iv, server.iv)
+			}
+		}
+
+		want := []byte{1, 2, 3, 4, 0, 0, 0, 0, 0, 0xff, 0xff, 0xf0}
+		n := uint64(0xfffff0) + 1000
+		for i := 11; i >= 4; i-- {
+			want[i] = byte(n)
+			n >>= 8
+		}
+		if !bytes.Equal(client.iv, want) {
+			t.Errorf("%s: got nonce %x after 1000 packets, want %x", cipherID, client.iv, want)
+		}
+		if client.invocations != 1000 {
+			t.Errorf("%s: got %d invocations, want 1000", cipherID, client.invocations)
+		}
+	}
+}
+
+func TestGCMInvocationCounterWraps(t *testing.T) {
+	c := &gcmCipher{iv: []byte{1, 2, 3, 4, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}}
+	c.incIV()
+	if want := []byte{1, 2, 3, 4, 0, 0, 0, 0, 0, 0, 0, 0}; !bytes.Equal(c.iv, want) {
+		t.Errorf("got nonce %x, want %x; the fixed field must not change", c.iv, want)
+	}
+}
+
+func TestGCMInvocationLimit(t *testing.T) {
+	client, server := newTestGCMPair(t, gcmCipherID, nil)
+	client.maxInvocations = 3
+	server.maxInvocations = 3
+
+	buf := &bytes.Buffer{}
+	for i := 0; i < 3; i++ {
+		if err := client.writePacket(uint32(i), buf, rand.Reader, []byte("packet")); err != nil {
+			t.Fatalf("writePacket: %v", err)
+		}
+		if _, err := server.readPacket(uint32(i), buf); err != nil {
+			t.Fatalf("readPacket: %v", err)
+		}
+	}
+	if err := client.writePacket(3, buf, rand.Reader, []byte("packet")); err != errGCMInvocationsExhausted {
+		t.Errorf("writePacket past the limit: got %v, want %v", err, errGCMInvocationsExhausted)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("writePacket past the limit wrote %d bytes", buf.Len())
+	}
+	if _, err := server.readPacket(3, buf); err != errGCMInvocationsExhausted {
+		t.Errorf("readPacket past the limit: got %v, want %v", err, errGCMInvocationsExhausted)
+	}
+
+	// The handshake transport rekeys well before the limit.
+	if packetRekeyThreshold >= gcmMaxInvocations {
+		t.Errorf("packetRekeyThreshold %d does not rekey before gcmMaxInvocations %d", packetRekeyThreshold, gcmMaxInvocations)
+	}
+}
+
+// chacha20Poly1305Vector is a packet encrypted with the 64-byte key 00 01
+// 02 .. 3f as sequence number 7, with zero padding. It was computed with
+// an independent implementation of OpenSSH's construction, following
+// PROTOCOL.chacha20poly1305: the encrypted length, the encrypted packet,
+// then the Poly1305 tag.
+const chacha20Poly1305Vector = "a39afcb2" + // length
+	"2e4315434e8f592d0440ce83b2fdb25940da30367ee33d83" + // packet
+	"6a3430ef1d734e90b2e3b0e4717ba829" // tag
+
+func TestChaCha20Poly1305Vector(t *testing.T) {
+	key := make([]byte, 64)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	payload := []byte("\x05\x00\x00\x00\x0cssh-userauth")
+
+	c, err := newChaCha20Cipher(key)
+	if err != nil {
+		t.Fatalf("newChaCha20Cipher: %v", err)
+	}
+	buf := &bytes.Buffer{}
+	if err := c.writePacket(7, buf, bytes.NewReader(make([]byte, 16)), payload); err != nil {
+		t.Fatalf("writePacket: %v", err)
+	}
+	if got := hex.EncodeToString(buf.Bytes()); got != chacha20Poly1305Vector {
+		t.Errorf("writePacket:\ngot  %s\nwant %s", got, chacha20Poly1305Vector)
+	}
+
+	packet, _ := hex.DecodeString(chacha20Poly1305Vector)
+	got, err := c.readPacket(7, bytes.NewReader(packet))
+	if err != nil {
+		t.Fatalf("readPacket: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("readPacket: got %q, want %q", got, payload)
+	}
+
+	// The sequence number is the nonce, so the packet does not
+	// authenticate under another one.
+	if _, err := c.readPacket(8, bytes.NewReader(packet)); err == nil {
+		t.Error("readPacket succeeded with the wrong sequence number")
+	}
+
+	// Neither does it with any bit flipped, including in the length.
+	for _, i := range []int{0, 3, 4, len(packet) - 17, len(packet) - 1} {
+		tampered := append([]byte(nil), packet...)
+		tampered[i] ^= 0x80
+		if _, err := c.readPacket(7, bytes.NewReader(tampered)); err == nil {
+			t.Errorf("readPacket succeeded with byte %d modified", i)
+		}
+	}
+}
@@ -6,10 +6,13 @@ package ssh
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net"
+	"os"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -21,6 +24,28 @@ type Client struct {
 	forwards        forwardList // forwarded tcpip connections from the remote side
 	mu              sync.Mutex
 	channelHandlers map[string]chan NewChannel
+	unknownHandler  chan NewChannel
+}
+
+// Shutdown gracefully shuts down the connection. It stops accepting and
+// opening new channels, sends a no-more-sessions@openssh.com request to
+// the server, and waits for all open channels to be closed before
+// closing the underlying network connection. If ctx expires before the
+// channels have drained, the connection is closed anyway and the
+// context's error is returned. If the Client wraps a Conn from outside
+// this package, Shutdown just closes it.
+func (c *Client) Shutdown(ctx context.Context) error {
+	return shutdownConn(ctx, c.Conn)
+}
+
+// SendRequestContext is like SendRequest, but stops waiting for the
+// reply and returns the context's error once ctx is done. The reply of
+// an abandoned request is discarded when it arrives. If the Client
+// wraps a Conn from outside this package, the request is sent from a
+// separate goroutine, which keeps waiting for the reply after
+// SendRequestContext has returned.
+func (c *Client) SendRequestContext(ctx context.Context, name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	return sendRequestContext(ctx, c.Conn, name, wantReply, payload)
 }
 
 // HandleChannelOpen returns a channel on which NewChannel requests
@@ -46,6 +71,44 @@ func (c *Client) HandleChannelOpen(channelType string) <-chan NewChannel {
 	return ch
 }
 
+// HandleUnknownChannelOpens returns a channel on which NewChannel requests
+// are sent for all the types that have no handler registered with
+// HandleChannelOpen. Without it, such requests are rejected with
+// UnknownChannelType. The receiver may accept them or reject them with a
+// reason of its choice, and can use this to log unexpected requests from the
+// server. If it already was called, nil is returned. The channel is closed
+// when the connection is closed.
+func (c *Client) HandleUnknownChannelOpens() <-chan NewChannel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.channelHandlers == nil {
+		// The SSH channel has been closed.
+		c := make(chan NewChannel)
+		close(c)
+		return c
+	}
+
+	if c.unknownHandler != nil {
+		return nil
+	}
+
+	c.unknownHandler = make(chan NewChannel, chanSize)
+	return c.unknownHandler
+}
+
+// UnderlyingConn returns the network connection that the client runs
+// over, as dialed by Dial or passed to NewClientConn. It is meant for
+// socket options, diagnostics and deadlines. Reading from or writing to
+// it corrupts the SSH stream, and closing it tears down the client; use
+// Close for that. It returns nil for a Client created by NewClient on a
+// Conn that was not established by this package.
+func (c *Client) UnderlyingConn() net.Conn {
+	if conn, ok := c.Conn.(*connection); ok {
+		return conn.sshConn.conn
+	}
+	return nil
+}
+
 // NewClient creates a Client on top of the given connection.
 func NewClient(c Conn, chans <-chan NewChannel, reqs <-chan *Request) *Client {
 	conn := &Client{
@@ -81,9 +144,9 @@ func NewClientConn(c net.Conn, addr string, config *ClientConfig) (Conn, <-chan
 
 	if err := conn.clientHandshake(addr, &fullConf); err != nil {
 		c.Close()
-		return nil, nil, nil, fmt.Errorf("ssh: handshake failed: %v", err)
+		return nil, nil, nil, classifyHandshakeError(fmt.Errorf("ssh: handshake failed: %w", err))
 	}
-	conn.mux = newMux(conn.transport)
+	conn.mux = newClientMux(conn.transport, newAuthContext(&fullConf, conn.transport).handleBanner)
 	return conn, conn.mux.incomingChannels, conn.mux.incomingRequests, nil
 }
 
@@ -96,13 +159,15 @@ func (c *connection) clientHandshake(dialAddress string, config *ClientConfig) e
 		c.clientVersion = []byte(packageVersion)
 	}
 	var err error
-	c.serverVersion, err = exchangeVersions(c.sshConn.conn, c.clientVersion)
+	c.serverVersion, err = exchangeVersions(c.sshConn.conn, c.clientVersion, config.VersionExchangeWriter, true)
 	if err != nil {
 		return err
 	}
 
-	c.transport = newClientTransport(
-		newTransport(c.sshConn.conn, config.Rand, true /* is client */),
+	tr := newTransport(c.sshConn.conn, config.Rand, true /* is client */)
+	tr.setPacketTrace(config.PacketTraceCallback)
+	tr.setMaxPacket(config.MaxPacketLength)
+	c.transport = newClientTransport(tr,
 		c.clientVersion, c.serverVersion, config, dialAddress, c.sshConn.RemoteAddr())
 	if err := c.transport.waitSession(); err != nil {
 		return err
@@ -133,6 +198,65 @@ func (c *Client) NewSession() (*Session, error) {
 	return newSession(ch, in)
 }
 
+// hostKeysProveRequest asks the server to prove that it holds the
+// private halves of a list of host keys. See the OpenSSH PROTOCOL
+// file, section 2.5.
+const hostKeysProveRequest = "hostkeys-prove-00@openssh.com"
+
+// hostKeyProofData returns the data that a server signs with key to
+// prove possession of it on the connection identified by sessionID.
+func hostKeyProofData(sessionID []byte, key PublicKey) []byte {
+	var data []byte
+	data = appendString(data, hostKeysProveRequest)
+	data = appendString(data, string(sessionID))
+	data = appendString(data, string(key.Marshal()))
+	return data
+}
+
+// ProveHostKeys asks the server to prove ownership of the given host
+// keys, typically ones it announced in addition to the key used for
+// the key exchange. It returns the keys whose proofs were valid for
+// this session; only those should be added to a list of known hosts.
+func (c *Client) ProveHostKeys(keys []PublicKey) ([]PublicKey, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	var payload []byte
+	for _, k := range keys {
+		payload = appendString(payload, string(k.Marshal()))
+	}
+
+	ok, reply, err := c.SendRequest(hostKeysProveRequest, true, payload)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("ssh: server refused to prove host keys")
+	}
+
+	sessionID := c.SessionID()
+	var proven []PublicKey
+	for _, k := range keys {
+		var sigBytes []byte
+		sigBytes, reply, ok = parseString(reply)
+		if !ok {
+			return nil, errors.New("ssh: short host key proof reply")
+		}
+		sig, rest, ok := parseSignatureBody(sigBytes)
+		if !ok || len(rest) > 0 {
+			continue
+		}
+		if k.Verify(hostKeyProofData(sessionID, k), sig) == nil {
+			proven = append(proven, k)
+		}
+	}
+	if len(reply) > 0 {
+		return nil, errors.New("ssh: trailing data in host key proof reply")
+	}
+	return proven, nil
+}
+
 func (c *Client) handleGlobalRequests(incoming <-chan *Request) {
 	for r := range incoming {
 		// This handles keepalive messages and matches
@@ -146,6 +270,9 @@ func (c *Client) handleChannelOpens(in <-chan NewChannel) {
 	for ch := range in {
 		c.mu.Lock()
 		handler := c.channelHandlers[ch.ChannelType()]
+		if handler == nil {
+			handler = c.unknownHandler
+		}
 		c.mu.Unlock()
 
 		if handler != nil {
@@ -160,6 +287,9 @@ func (c *Client) handleChannelOpens(in <-chan NewChannel) {
 		close(ch)
 	}
 	c.channelHandlers = nil
+	if c.unknownHandler != nil {
+		close(c.unknownHandler)
+	}
 	c.mu.Unlock()
 }
 
@@ -169,7 +299,11 @@ func (c *Client) handleChannelOpens(in <-chan NewChannel) {
 // to incoming channels and requests, use net.Dial with NewClientConn
 // instead.
 func Dial(network, addr string, config *ClientConfig) (*Client, error) {
-	conn, err := net.DialTimeout(network, addr, config.Timeout)
+	d := net.Dialer{
+		Timeout: config.Timeout,
+		Control: config.Control,
+	}
+	conn, err := d.Dial(network, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -180,6 +314,53 @@ func Dial(network, addr string, config *ClientConfig) (*Client, error) {
 	return NewClient(c, chans, reqs), nil
 }
 
+// DialHappyEyeballs is like Dial for a TCP address, but it is suitable for
+// hosts that have both IPv4 and IPv6 addresses. The connection is made by
+// net.Dialer, which does the RFC 6555 "Happy Eyeballs" race: if the
+// addresses of the preferred family do not connect within a short delay,
+// the other family is tried in parallel, and the first connection to
+// complete is used for the SSH handshake. The HostKeyCallback receives the
+// address that was actually connected to.
+//
+// Cancelling ctx aborts both the dial and the handshake, but has no effect
+// once the Client is returned.
+func DialHappyEyeballs(ctx context.Context, addr string, config *ClientConfig) (*Client, error) {
+	// The zero FallbackDelay selects net.Dialer's default delay before
+	// the other family is tried, currently 300 ms.
+	d := net.Dialer{
+		Timeout: config.Timeout,
+		Control: config.Control,
+	}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	stop := make(chan struct{})
+	aborted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			aborted <- true
+		case <-stop:
+			aborted <- false
+		}
+	}()
+	c, chans, reqs, err := NewClientConn(conn, addr, config)
+	close(stop)
+	if <-aborted {
+		if err == nil {
+			c.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(c, chans, reqs), nil
+}
+
 // HostKeyCallback is the function type used for verifying server
 // keys.  A HostKeyCallback must return nil if the host key is OK, or
 // an error to reject it. It receives the hostname as passed to Dial
@@ -215,15 +396,59 @@ type ClientConfig struct {
 
 	// HostKeyAlgorithms lists the key types that the client will
 	// accept from the server as host key, in order of
-	// preference. If empty, a reasonable default is used. Any
-	// string returned from PublicKey.Type method may be used, or
-	// any of the CertAlgoXxxx and KeyAlgoXxxx constants.
+	// preference. If empty, a reasonable default is used, which
+	// leaves out the LegacyAlgorithms. Any string returned from
+	// PublicKey.Type method may be used, or any of the CertAlgoXxxx
+	// and KeyAlgoXxxx constants.
 	HostKeyAlgorithms []string
 
 	// Timeout is the maximum amount of time for the TCP connection to establish.
 	//
 	// A Timeout of zero means no timeout.
 	Timeout time.Duration
+
+	// Control, if non-nil, is called by Dial after creating the
+	// network connection but before dialing, just like
+	// net.Dialer.Control. It can be used to set socket options such
+	// as SO_KEEPALIVE or TCP_NODELAY on the underlying socket.
+	Control func(network, address string, c syscall.RawConn) error
+
+	// BannerCallback, if non-nil, is called with the banner messages
+	// the server sends during authentication (RFC 4252 section 5.4).
+	// It is called for every banner, wherever it falls in the
+	// authentication exchange, and also for banners that servers send
+	// after authentication has succeeded. Banners are only passed on
+	// once the server's host key has been accepted by HostKeyCallback,
+	// so text from an unverified server is never displayed. If
+	// BannerCallback returns an error before authentication has
+	// succeeded, authentication is aborted; later errors are ignored.
+	// Banners after authentication are passed on from the goroutine
+	// that reads the connection, so BannerCallback must not block: no
+	// channel makes progress until it returns. BannerDisplayStderr can
+	// be used to print banners on standard error.
+	BannerCallback BannerCallback
+
+	// BannerLanguageCallback, if non-nil, is used instead of
+	// BannerCallback and also receives the language tag the server
+	// sent with each banner.
+	BannerLanguageCallback BannerLanguageCallback
+}
+
+// BannerCallback is the function type used for handling the banner
+// sent by the server during authentication.
+type BannerCallback func(message string) error
+
+// BannerLanguageCallback is like BannerCallback, but also receives the
+// RFC 3066 language tag of the banner, which may be empty.
+type BannerLanguageCallback func(message, language string) error
+
+// BannerDisplayStderr returns a function that can be used for
+// ClientConfig.BannerCallback to display banners on os.Stderr.
+func BannerDisplayStderr() BannerCallback {
+	return func(banner string) error {
+		_, err := os.Stderr.WriteString(banner)
+		return err
+	}
 }
 
 // InsecureIgnoreHostKey returns a function that can be used for
@@ -244,7 +469,7 @@ func (f *fixedHostKey) check(hostname string, remote net.Addr, key PublicKey) er
 		return fmt.Errorf("ssh: required host key was nil")
 	}
 	if !bytes.Equal(key.Marshal(), f.key.Marshal()) {
-		return fmt.Errorf("ssh: host key mismatch")
+		return ErrHostKeyMismatch
 	}
 	return nil
 }
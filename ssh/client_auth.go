@@ -9,6 +9,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"sync"
 )
 
 // clientAuthenticate authenticates with the remote server. See RFC 4252.
@@ -17,7 +19,8 @@ func (c *connection) clientAuthenticate(config *ClientConfig) error {
 	if err := c.transport.writePacket(Marshal(&serviceRequestMsg{serviceUserAuth})); err != nil {
 		return err
 	}
-	packet, err := c.transport.readPacket()
+	ctx := newAuthContext(config, c.transport)
+	packet, err := readSkippingBanners(c.transport, ctx)
 	if err != nil {
 		return err
 	}
@@ -31,9 +34,8 @@ func (c *connection) clientAuthenticate(config *ClientConfig) error {
 	tried := make(map[string]bool)
 	var lastMethods []string
 
-	sessionID := c.transport.getSessionID()
 	for auth := AuthMethod(new(noneAuth)); auth != nil; {
-		ok, methods, err := auth.auth(sessionID, config.User, c.transport, config.Rand)
+		ok, methods, err := auth.auth(ctx, config.User, c.transport, config.Rand)
 		if err != nil {
 			return err
 		}
@@ -63,7 +65,7 @@ func (c *connection) clientAuthenticate(config *ClientConfig) error {
 			}
 		}
 	}
-	return fmt.Errorf("ssh: unable to authenticate, attempted methods %v, no supported methods remain", keys(tried))
+	return fmt.Errorf("%w, attempted methods %v, no supported methods remain", ErrAuthFailed, keys(tried))
 }
 
 func keys(m map[string]bool) []string {
@@ -75,6 +77,35 @@ func keys(m map[string]bool) []string {
 	return s
 }
 
+// authContext holds what the authentication methods know about the
+// connection, besides its packets.
+type authContext struct {
+	// sessionID is the session identifier, which public key
+	// signatures cover. It is nil until the first key exchange has
+	// completed.
+	sessionID []byte
+
+	// host is the address passed to Dial or NewClientConn, and
+	// hostKeyAlgo the host key algorithm of the first key exchange.
+	host        string
+	hostKeyAlgo string
+
+	bannerCallback         BannerCallback
+	bannerLanguageCallback BannerLanguageCallback
+}
+
+// newAuthContext returns the authentication context for config on the
+// client transport t.
+func newAuthContext(config *ClientConfig, t *handshakeTransport) *authContext {
+	return &authContext{
+		sessionID:              t.getSessionID(),
+		host:                   t.dialAddress,
+		hostKeyAlgo:            t.sessionHostKeyAlgo,
+		bannerCallback:         config.BannerCallback,
+		bannerLanguageCallback: config.BannerLanguageCallback,
+	}
+}
+
 // An AuthMethod represents an instance of an RFC 4252 authentication method.
 type AuthMethod interface {
 	// auth authenticates user over transport t.
@@ -82,7 +113,7 @@ type AuthMethod interface {
 	// If authentication is not successful, a []string of alternative
 	// method names is returned. If the slice is nil, it will be ignored
 	// and the previous set of possible methods will be reused.
-	auth(session []byte, user string, p packetConn, rand io.Reader) (bool, []string, error)
+	auth(ctx *authContext, user string, p packetConn, rand io.Reader) (bool, []string, error)
 
 	// method returns the RFC 4252 method name.
 	method() string
@@ -91,7 +122,7 @@ type AuthMethod interface {
 // "none" authentication, RFC 4252 section 5.2.
 type noneAuth int
 
-func (n *noneAuth) auth(session []byte, user string, c packetConn, rand io.Reader) (bool, []string, error) {
+func (n *noneAuth) auth(ctx *authContext, user string, c packetConn, rand io.Reader) (bool, []string, error) {
 	if err := c.writePacket(Marshal(&userAuthRequestMsg{
 		User:    user,
 		Service: serviceSSH,
@@ -100,7 +131,7 @@ func (n *noneAuth) auth(session []byte, user string, c packetConn, rand io.Reade
 		return false, nil, err
 	}
 
-	return handleAuthResponse(c)
+	return handleAuthResponse(c, ctx)
 }
 
 func (n *noneAuth) method() string {
@@ -111,7 +142,7 @@ func (n *noneAuth) method() string {
 // a function call, e.g. by prompting the user.
 type passwordCallback func() (password string, err error)
 
-func (cb passwordCallback) auth(session []byte, user string, c packetConn, rand io.Reader) (bool, []string, error) {
+func (cb passwordCallback) auth(ctx *authContext, user string, c packetConn, rand io.Reader) (bool, []string, error) {
 	type passwordAuthMsg struct {
 		User     string `sshtype:"50"`
 		Service  string
@@ -138,7 +169,7 @@ func (cb passwordCallback) auth(session []byte, user string, c packetConn, rand
 		return false, nil, err
 	}
 
-	return handleAuthResponse(c)
+	return handleAuthResponse(c, ctx)
 }
 
 func (cb passwordCallback) method() string {
@@ -178,7 +209,7 @@ func (cb publicKeyCallback) method() string {
 	return "publickey"
 }
 
-func (cb publicKeyCallback) auth(session []byte, user string, c packetConn, rand io.Reader) (bool, []string, error) {
+func (cb publicKeyCallback) auth(ctx *authContext, user string, c packetConn, rand io.Reader) (bool, []string, error) {
 	// Authentication is performed by sending an enquiry to test if a key is
 	// acceptable to the remote. If the key is acceptable, the client will
 	// attempt to authenticate with the valid key.  If not the client will repeat
@@ -188,11 +219,18 @@ func (cb publicKeyCallback) auth(session []byte, user string, c packetConn, rand
 	if err != nil {
 		return false, nil, err
 	}
+	success, _, methods, err := tryPublicKeys(signers, ctx, user, c, rand)
+	return success, methods, err
+}
+
+// tryPublicKeys offers signers in turn until one of them authenticates
+// user, and returns it.
+func tryPublicKeys(signers []Signer, ctx *authContext, user string, c packetConn, rand io.Reader) (bool, Signer, []string, error) {
 	var methods []string
 	for _, signer := range signers {
-		ok, err := validateKey(signer.PublicKey(), user, c)
+		ok, err := validateKey(signer.PublicKey(), user, c, ctx)
 		if err != nil {
-			return false, nil, err
+			return false, nil, nil, err
 		}
 		if !ok {
 			continue
@@ -200,13 +238,13 @@ func (cb publicKeyCallback) auth(session []byte, user string, c packetConn, rand
 
 		pub := signer.PublicKey()
 		pubKey := pub.Marshal()
-		sign, err := signer.Sign(rand, buildDataSignedForAuth(session, userAuthRequestMsg{
+		sign, err := signer.Sign(rand, buildDataSignedForAuth(ctx.sessionID, userAuthRequestMsg{
 			User:    user,
 			Service: serviceSSH,
-			Method:  cb.method(),
+			Method:  "publickey",
 		}, []byte(pub.Type()), pubKey))
 		if err != nil {
-			return false, nil, err
+			return false, nil, nil, err
 		}
 
 		// manually wrap the serialized signature in a string
@@ -216,7 +254,7 @@ func (cb publicKeyCallback) auth(session []byte, user string, c packetConn, rand
 		msg := publickeyAuthMsg{
 			User:     user,
 			Service:  serviceSSH,
-			Method:   cb.method(),
+			Method:   "publickey",
 			HasSig:   true,
 			Algoname: pub.Type(),
 			PubKey:   pubKey,
@@ -224,24 +262,24 @@ func (cb publicKeyCallback) auth(session []byte, user string, c packetConn, rand
 		}
 		p := Marshal(&msg)
 		if err := c.writePacket(p); err != nil {
-			return false, nil, err
+			return false, nil, nil, err
 		}
 		var success bool
-		success, methods, err = handleAuthResponse(c)
+		success, methods, err = handleAuthResponse(c, ctx)
 		if err != nil {
-			return false, nil, err
+			return false, nil, nil, err
 		}
 
 		// If authentication succeeds or the list of available methods does not
 		// contain the "publickey" method, do not attempt to authenticate with any
 		// other keys.  According to RFC 4252 Section 7, the latter can occur when
 		// additional authentication methods are required.
-		if success || !containsMethod(methods, cb.method()) {
-			return success, methods, err
+		if success || !containsMethod(methods, "publickey") {
+			return success, signer, methods, err
 		}
 	}
 
-	return false, methods, nil
+	return false, nil, methods, nil
 }
 
 func containsMethod(methods []string, method string) bool {
@@ -255,7 +293,7 @@ func containsMethod(methods []string, method string) bool {
 }
 
 // validateKey validates the key provided is acceptable to the server.
-func validateKey(key PublicKey, user string, c packetConn) (bool, error) {
+func validateKey(key PublicKey, user string, c packetConn, ctx *authContext) (bool, error) {
 	pubKey := key.Marshal()
 	msg := publickeyAuthMsg{
 		User:     user,
@@ -269,35 +307,31 @@ func validateKey(key PublicKey, user string, c packetConn) (bool, error) {
 		return false, err
 	}
 
-	return confirmKeyAck(key, c)
+	return confirmKeyAck(key, c, ctx)
 }
 
-func confirmKeyAck(key PublicKey, c packetConn) (bool, error) {
+func confirmKeyAck(key PublicKey, c packetConn, ctx *authContext) (bool, error) {
 	pubKey := key.Marshal()
 	algoname := key.Type()
 
-	for {
-		packet, err := c.readPacket()
-		if err != nil {
+	packet, err := readSkippingBanners(c, ctx)
+	if err != nil {
+		return false, err
+	}
+	switch packet[0] {
+	case msgUserAuthPubKeyOk:
+		var msg userAuthPubKeyOkMsg
+		if err := Unmarshal(packet, &msg); err != nil {
 			return false, err
 		}
-		switch packet[0] {
-		case msgUserAuthBanner:
-			// TODO(gpaul): add callback to present the banner to the user
-		case msgUserAuthPubKeyOk:
-			var msg userAuthPubKeyOkMsg
-			if err := Unmarshal(packet, &msg); err != nil {
-				return false, err
-			}
-			if msg.Algo != algoname || !bytes.Equal(msg.PubKey, pubKey) {
-				return false, nil
-			}
-			return true, nil
-		case msgUserAuthFailure:
+		if msg.Algo != algoname || !bytes.Equal(msg.PubKey, pubKey) {
 			return false, nil
-		default:
-			return false, unexpectedMessageError(msgUserAuthSuccess, packet[0])
 		}
+		return true, nil
+	case msgUserAuthFailure:
+		return false, nil
+	default:
+		return false, unexpectedMessageError(msgUserAuthSuccess, packet[0])
 	}
 }
 
@@ -313,30 +347,178 @@ func PublicKeysCallback(getSigners func() (signers []Signer, err error)) AuthMet
 	return publicKeyCallback(getSigners)
 }
 
-// handleAuthResponse returns whether the preceding authentication request succeeded
-// along with a list of remaining authentication methods to try next and
-// an error if an unexpected response was received.
-func handleAuthResponse(c packetConn) (bool, []string, error) {
+// IdentityCache remembers which key last authenticated a user to a host,
+// so that Identities can offer it first on the next connection.
+// Implementations may persist the keys, and must be safe for concurrent use.
+type IdentityCache interface {
+	// Get returns the key that last authenticated user to host, or nil.
+	Get(host, user string) PublicKey
+
+	// Put records that key authenticated user to host.
+	Put(host, user string, key PublicKey)
+}
+
+type identityCacheKey struct {
+	host, user string
+}
+
+type memoryIdentityCache struct {
+	mu   sync.Mutex
+	keys map[identityCacheKey]PublicKey
+}
+
+// NewIdentityCache returns an IdentityCache that is kept in memory.
+func NewIdentityCache() IdentityCache {
+	return &memoryIdentityCache{keys: make(map[identityCacheKey]PublicKey)}
+}
+
+func (m *memoryIdentityCache) Get(host, user string) PublicKey {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.keys[identityCacheKey{host, user}]
+}
+
+func (m *memoryIdentityCache) Put(host, user string, key PublicKey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[identityCacheKey{host, user}] = key
+}
+
+type identitiesAuth struct {
+	signers []Signer
+	cache   IdentityCache
+}
+
+// Identities returns an AuthMethod that offers the given keys, most
+// promising first, to make fewer attempts on servers that limit them. The
+// key that last succeeded according to cache comes first, followed by the
+// keys of the same type as the server's host key, and then the others in
+// the given order. Hosts are identified by the address passed to Dial or
+// NewClientConn. cache may be nil.
+func Identities(cache IdentityCache, signers ...Signer) AuthMethod {
+	return &identitiesAuth{signers: signers, cache: cache}
+}
+
+func (a *identitiesAuth) method() string {
+	return "publickey"
+}
+
+func (a *identitiesAuth) auth(ctx *authContext, user string, c packetConn, rand io.Reader) (bool, []string, error) {
+	var last PublicKey
+	if a.cache != nil {
+		last = a.cache.Get(ctx.host, user)
+	}
+
+	signers := orderSigners(a.signers, last, ctx.hostKeyAlgo)
+	success, signer, methods, err := tryPublicKeys(signers, ctx, user, c, rand)
+	if success && a.cache != nil {
+		a.cache.Put(ctx.host, user, signer.PublicKey())
+	}
+	return success, methods, err
+}
+
+// orderSigners returns signers sorted so that the one for last comes
+// first, followed by those whose type matches the host key algorithm.
+func orderSigners(signers []Signer, last PublicKey, hostKeyAlgo string) []Signer {
+	for privAlgo, certAlgo := range certAlgoNames {
+		if certAlgo == hostKeyAlgo {
+			hostKeyAlgo = privAlgo
+		}
+	}
+	var lastKey []byte
+	if last != nil {
+		lastKey = last.Marshal()
+	}
+	rank := func(s Signer) int {
+		pub := s.PublicKey()
+		switch {
+		case lastKey != nil && bytes.Equal(pub.Marshal(), lastKey):
+			return 0
+		case pub.Type() == hostKeyAlgo:
+			return 1
+		}
+		return 2
+	}
+
+	ordered := append([]Signer(nil), signers...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return rank(ordered[i]) < rank(ordered[j])
+	})
+	return ordered
+}
+
+// handleBanner passes a userauth banner to the client's
+// BannerLanguageCallback or BannerCallback. Banners that arrive before
+// the first key exchange, and thus the host key check, has completed
+// are dropped, as are all banners if ctx is nil.
+func (ctx *authContext) handleBanner(packet []byte) error {
+	var msg userAuthBannerMsg
+	if err := Unmarshal(packet, &msg); err != nil {
+		return err
+	}
+
+	if ctx == nil || ctx.sessionID == nil {
+		return nil
+	}
+	if ctx.bannerLanguageCallback != nil {
+		return ctx.bannerLanguageCallback(msg.Message, msg.Language)
+	}
+	if ctx.bannerCallback != nil {
+		return ctx.bannerCallback(msg.Message)
+	}
+	return nil
+}
+
+// readSkippingBanners reads the next packet from c that is not a userauth
+// banner, passing the banners it skips to ctx.handleBanner. Servers may
+// send a banner at any point of the authentication exchange, even ahead
+// of their service accept message.
+func readSkippingBanners(c packetConn, ctx *authContext) ([]byte, error) {
 	for {
 		packet, err := c.readPacket()
 		if err != nil {
-			return false, nil, err
+			return nil, err
+		}
+		if packet[0] != msgUserAuthBanner {
+			return packet, nil
 		}
+		if err := ctx.handleBanner(packet); err != nil {
+			return nil, err
+		}
+	}
+}
 
-		switch packet[0] {
-		case msgUserAuthBanner:
-			// TODO: add callback to present the banner to the user
-		case msgUserAuthFailure:
-			var msg userAuthFailureMsg
-			if err := Unmarshal(packet, &msg); err != nil {
-				return false, nil, err
-			}
-			return false, msg.Methods, nil
-		case msgUserAuthSuccess:
-			return true, nil, nil
-		default:
-			return false, nil, unexpectedMessageError(msgUserAuthSuccess, packet[0])
+// handleAuthResponse returns whether the preceding authentication request succeeded
+// along with a list of remaining authentication methods to try next and
+// an error if an unexpected response was received.
+func handleAuthResponse(c packetConn, ctx *authContext) (bool, []string, error) {
+	packet, err := readSkippingBanners(c, ctx)
+	if err != nil {
+		return false, nil, err
+	}
+
+	switch packet[0] {
+	case msgUserAuthFailure:
+		var msg userAuthFailureMsg
+		if err := Unmarshal(packet, &msg); err != nil {
+			return false, nil, err
+		}
+		return false, msg.Methods, nil
+	case msgUserAuthSuccess:
+		return true, nil, nil
+	case msgUnimplemented:
+		// The server does not know the method; try another.
+		return false, nil, nil
+	case msgUserAuthPasswdChangeReq:
+		// Of the methods that come here, only password
+		// authentication may be answered like this.
+		var msg userAuthPasswdChangeReqMsg
+		if err := Unmarshal(packet, &msg); err != nil {
+			return false, nil, err
 		}
+		return false, nil, &PasswordExpiredError{Prompt: msg.Prompt, Language: msg.Language}
+	default:
+		return false, nil, unexpectedMessageError(msgUserAuthSuccess, packet[0])
 	}
 }
 
@@ -359,7 +541,7 @@ func (cb KeyboardInteractiveChallenge) method() string {
 	return "keyboard-interactive"
 }
 
-func (cb KeyboardInteractiveChallenge) auth(session []byte, user string, c packetConn, rand io.Reader) (bool, []string, error) {
+func (cb KeyboardInteractiveChallenge) auth(ctx *authContext, user string, c packetConn, rand io.Reader) (bool, []string, error) {
 	type initiateMsg struct {
 		User       string `sshtype:"50"`
 		Service    string
@@ -377,16 +559,13 @@ func (cb KeyboardInteractiveChallenge) auth(session []byte, user string, c packe
 	}
 
 	for {
-		packet, err := c.readPacket()
+		packet, err := readSkippingBanners(c, ctx)
 		if err != nil {
 			return false, nil, err
 		}
 
 		// like handleAuthResponse, but with less options.
 		switch packet[0] {
-		case msgUserAuthBanner:
-			// TODO: Print banners during userauth.
-			continue
 		case msgUserAuthInfoRequest:
 			// OK
 		case msgUserAuthFailure:
@@ -456,9 +635,9 @@ type retryableAuthMethod struct {
 	maxTries   int
 }
 
-func (r *retryableAuthMethod) auth(session []byte, user string, c packetConn, rand io.Reader) (ok bool, methods []string, err error) {
+func (r *retryableAuthMethod) auth(ctx *authContext, user string, c packetConn, rand io.Reader) (ok bool, methods []string, err error) {
 	for i := 0; r.maxTries <= 0 || i < r.maxTries; i++ {
-		ok, methods, err = r.authMethod.auth(session, user, c, rand)
+		ok, methods, err = r.authMethod.auth(ctx, user, c, rand)
 		if ok || err != nil { // either success or error terminate
 			return ok, methods, err
 		}
@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -84,6 +85,40 @@ func tryAuth(t *testing.T, config *ClientConfig) error {
 	return err
 }
 
+func TestServerBannerCallback(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	serverConf := &ServerConfig{
+		NoClientAuth: true,
+		BannerCallback: func(conn ConnMetadata) string {
+			return "Welcome, " + conn.User()
+		},
+	}
+	serverConf.AddHostKey(testSigners["rsa"])
+	go NewServerConn(c1, serverConf)
+
+	var banners []string
+	clientConf := &ClientConfig{
+		User:            "testuser",
+		HostKeyCallback: InsecureIgnoreHostKey(),
+		BannerCallback: func(message string) error {
+			banners = append(banners, message)
+			return nil
+		},
+	}
+	if _, _, _, err := NewClientConn(c2, "", clientConf); err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+	if len(banners) != 1 || banners[0] != "Welcome, testuser" {
+		t.Errorf("got banners %q, want [\"Welcome, testuser\"]", banners)
+	}
+}
+
 func TestClientAuthPublicKey(t *testing.T) {
 	config := &ClientConfig{
 		User: "testuser",
@@ -495,7 +530,7 @@ func TestClientAuthMaxAuthTries(t *testing.T) {
 	}
 	serverConfig.AddHostKey(testSigners["rsa"])
 
-	expectedErr := fmt.Errorf("ssh: handshake failed: %v", &disconnectMsg{
+	expectedErr := fmt.Errorf("ssh: handshake failed: %v", &DisconnectError{
 		Reason:  2,
 		Message: "too many authentication failures",
 	})
@@ -558,7 +593,7 @@ func TestClientAuthMaxAuthTriesPublicKey(t *testing.T) {
 		t.Fatalf("unable to dial remote side: %s", err)
 	}
 
-	expectedErr := fmt.Errorf("ssh: handshake failed: %v", &disconnectMsg{
+	expectedErr := fmt.Errorf("ssh: handshake failed: %v", &DisconnectError{
 		Reason:  2,
 		Message: "too many authentication failures",
 	})
@@ -626,3 +661,190 @@ func TestClientAuthErrorList(t *testing.T) {
 		}
 	}
 }
+
+func TestHandleBannerResponse(t *testing.T) {
+	var got []string
+	ctx := &authContext{
+		bannerCallback: func(message string) error {
+			got = append(got, message)
+			return nil
+		},
+	}
+	banner := Marshal(&userAuthBannerMsg{Message: "early"})
+	if err := ctx.handleBanner(banner); err != nil {
+		t.Fatalf("handleBanner: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("banner %q was passed on before the host key was verified", got)
+	}
+
+	ctx.sessionID = []byte("session")
+	if err := ctx.handleBanner(Marshal(&userAuthBannerMsg{Message: "hello"})); err != nil {
+		t.Fatalf("handleBanner: %v", err)
+	}
+	if len(got) != 1 || got[0] != "hello" {
+		t.Errorf("got banners %q, want [hello]", got)
+	}
+
+	wantErr := errors.New("banner rejected")
+	ctx.bannerCallback = func(string) error { return wantErr }
+	if err := ctx.handleBanner(banner); err != wantErr {
+		t.Errorf("got error %v, want %v", err, wantErr)
+	}
+}
+
+func TestHandleBannerResponseLanguage(t *testing.T) {
+	var gotMsg, gotLang string
+	ctx := &authContext{
+		sessionID: []byte("session"),
+		bannerCallback: func(message string) error {
+			t.Errorf("BannerCallback called with %q", message)
+			return nil
+		},
+		bannerLanguageCallback: func(message, language string) error {
+			gotMsg, gotLang = message, language
+			return nil
+		},
+	}
+	banner := Marshal(&userAuthBannerMsg{Message: "bonjour", Language: "fr"})
+	if err := ctx.handleBanner(banner); err != nil {
+		t.Fatalf("handleBanner: %v", err)
+	}
+	if gotMsg != "bonjour" || gotLang != "fr" {
+		t.Errorf("got banner %q in %q, want \"bonjour\" in \"fr\"", gotMsg, gotLang)
+	}
+}
+
+func TestBannersThroughoutAuth(t *testing.T) {
+	var banners []string
+	config := &ClientConfig{
+		User: "testuser",
+		Auth: []AuthMethod{Password("pw")},
+		BannerCallback: func(message string) error {
+			banners = append(banners, message)
+			return nil
+		},
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	}
+	client, server, err := handshakePair(config, "addr", false)
+	if err != nil {
+		t.Fatalf("handshakePair: %v", err)
+	}
+	defer client.Close()
+	defer server.Close()
+
+	// The server sends a banner ahead of each of its answers, the
+	// last one after authentication has succeeded.
+	go func() {
+		for i, reply := range [][]byte{
+			Marshal(&serviceAcceptMsg{Service: serviceUserAuth}),
+			Marshal(&userAuthFailureMsg{Methods: []string{"password"}}),
+			{msgUserAuthSuccess},
+			Marshal(&globalRequestFailureMsg{}),
+		} {
+			if _, err := server.readPacket(); err != nil {
+				return
+			}
+			banner := Marshal(&userAuthBannerMsg{Message: fmt.Sprintf("banner %d", i)})
+			if err := server.writePacket(banner); err != nil {
+				return
+			}
+			if err := server.writePacket(reply); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn := &connection{transport: client}
+	if err := conn.clientAuthenticate(config); err != nil {
+		t.Fatalf("clientAuthenticate: %v", err)
+	}
+	m := newClientMux(client, newAuthContext(config, client).handleBanner)
+	if ok, _, err := m.SendRequest("sync", true, nil); ok || err != nil {
+		t.Fatalf("SendRequest: got %v, %v, want a failure", ok, err)
+	}
+
+	want := []string{"banner 0", "banner 1", "banner 2", "banner 3"}
+	if !reflect.DeepEqual(banners, want) {
+		t.Errorf("got banners %q, want %q", banners, want)
+	}
+}
+
+func TestOrderSigners(t *testing.T) {
+	signers := []Signer{testSigners["dsa"], testSigners["ecdsa"], testSigners["rsa"], testSigners["ed25519"]}
+	got := orderSigners(signers, testPublicKeys["ed25519"], CertAlgoRSAv01)
+	want := []Signer{testSigners["ed25519"], testSigners["rsa"], testSigners["dsa"], testSigners["ecdsa"]}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("position %d: got %s key, want %s", i, got[i].PublicKey().Type(), want[i].PublicKey().Type())
+		}
+	}
+	if signers[0] != testSigners["dsa"] {
+		t.Error("orderSigners modified its argument")
+	}
+}
+
+func TestAuthMethodIdentities(t *testing.T) {
+	cache := NewIdentityCache()
+	config := &ClientConfig{
+		User: "testuser",
+		Auth: []AuthMethod{
+			Identities(cache, testSigners["dsa"], testSigners["ecdsa"], testSigners["rsa"]),
+		},
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	}
+	if err := tryAuth(t, config); err != nil {
+		t.Fatalf("unable to dial remote side: %s", err)
+	}
+	if key := cache.Get("", "testuser"); key == nil || !bytes.Equal(key.Marshal(), testPublicKeys["rsa"].Marshal()) {
+		t.Errorf("cache holds %v, want the rsa key", key)
+	}
+	if key := cache.Get("", "otheruser"); key != nil {
+		t.Errorf("cache holds %v for another user", key)
+	}
+}
+
+func TestSecurityKeyFlagsPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		flags byte
+		perms *Permissions
+		ok    bool
+	}{
+		{"touch", SKFlagUserPresent, nil, true},
+		{"no touch", 0, nil, false},
+		{"no touch allowed", 0, &Permissions{Extensions: map[string]string{"no-touch-required": ""}}, true},
+		{"touch without verification", SKFlagUserPresent, &Permissions{CriticalOptions: map[string]string{"verify-required": ""}}, false},
+		{"touch and verification", SKFlagUserPresent | SKFlagUserVerified, &Permissions{CriticalOptions: map[string]string{"verify-required": ""}}, true},
+		{"verification without touch", SKFlagUserVerified, &Permissions{CriticalOptions: map[string]string{"verify-required": ""}}, false},
+	} {
+		signer := newSKTestSigner(t, KeyAlgoSKED25519, tt.flags)
+
+		c1, c2, err := netPipe()
+		if err != nil {
+			t.Fatalf("netPipe: %v", err)
+		}
+		serverConfig := &ServerConfig{
+			PublicKeyCallback: func(conn ConnMetadata, key PublicKey) (*Permissions, error) {
+				if !bytes.Equal(key.Marshal(), signer.PublicKey().Marshal()) {
+					return nil, errors.New("unknown key")
+				}
+				return tt.perms, nil
+			},
+		}
+		serverConfig.AddHostKey(testSigners["rsa"])
+		go newServer(c1, serverConfig)
+
+		clientConfig := &ClientConfig{
+			User:            "testuser",
+			Auth:            []AuthMethod{PublicKeys(signer)},
+			HostKeyCallback: InsecureIgnoreHostKey(),
+		}
+		_, _, _, err = NewClientConn(c2, "", clientConfig)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v, want success %v", tt.name, err, tt.ok)
+		}
+		c1.Close()
+		c2.Close()
+	}
+}
@@ -5,9 +5,16 @@
 package ssh
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
 	"net"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func testClientVersion(t *testing.T, config *ClientConfig, expected string) {
@@ -79,3 +86,436 @@ func TestHostKeyCheck(t *testing.T) {
 		}
 	}
 }
+
+func TestPacketTraceCallback(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	serverConf := &ServerConfig{
+		NoClientAuth: true,
+	}
+	serverConf.AddHostKey(testSigners["rsa"])
+	go NewServerConn(c1, serverConf)
+
+	var mu sync.Mutex
+	counts := map[Direction]map[byte]int{
+		DirectionSent:     {},
+		DirectionReceived: {},
+	}
+	clientConf := &ClientConfig{
+		User:            "user",
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	}
+	clientConf.PacketTraceCallback = func(dir Direction, msgType byte, length int) {
+		if length <= 0 {
+			t.Errorf("%s packet %d: got length %d", dir, msgType, length)
+		}
+		mu.Lock()
+		counts[dir][msgType]++
+		mu.Unlock()
+	}
+
+	conn, _, _, err := NewClientConn(c2, "", clientConf)
+	if err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+	conn.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, msgType := range []byte{msgKexInit, msgNewKeys, msgServiceRequest, msgUserAuthRequest} {
+		if counts[DirectionSent][msgType] == 0 {
+			t.Errorf("no sent packet of type %d traced", msgType)
+		}
+	}
+	for _, msgType := range []byte{msgKexInit, msgNewKeys, msgServiceAccept, msgUserAuthSuccess} {
+		if counts[DirectionReceived][msgType] == 0 {
+			t.Errorf("no received packet of type %d traced", msgType)
+		}
+	}
+}
+
+func TestDialControl(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		serverConf := &ServerConfig{
+			NoClientAuth: true,
+		}
+		serverConf.AddHostKey(testSigners["rsa"])
+		NewServerConn(c, serverConf)
+	}()
+
+	var called bool
+	clientConf := &ClientConfig{
+		User:            "user",
+		HostKeyCallback: InsecureIgnoreHostKey(),
+		Control: func(network, address string, c syscall.RawConn) error {
+			called = true
+			if address != l.Addr().String() {
+				t.Errorf("got address %q, want %q", address, l.Addr())
+			}
+			return c.Control(func(fd uintptr) {})
+		},
+	}
+	client, err := Dial("tcp", l.Addr().String(), clientConf)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	client.Close()
+	if !called {
+		t.Error("Control was not called")
+	}
+
+	wantErr := errors.New("control failed")
+	clientConf.Control = func(network, address string, c syscall.RawConn) error {
+		return wantErr
+	}
+	if _, err := Dial("tcp", l.Addr().String(), clientConf); err == nil || !strings.Contains(err.Error(), wantErr.Error()) {
+		t.Errorf("got error %v, want %v", err, wantErr)
+	}
+}
+
+func TestProveHostKeys(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	// The server holds the ecdsa and ed25519 keys, but answers for
+	// the dsa key with a signature made by the ecdsa key.
+	signers := map[string]Signer{
+		string(testPublicKeys["ecdsa"].Marshal()):   testSigners["ecdsa"],
+		string(testPublicKeys["ed25519"].Marshal()): testSigners["ed25519"],
+		string(testPublicKeys["dsa"].Marshal()):     testSigners["ecdsa"],
+	}
+
+	serverConf := &ServerConfig{
+		NoClientAuth: true,
+	}
+	serverConf.AddHostKey(testSigners["rsa"])
+	go func() {
+		conn, _, reqs, err := NewServerConn(c1, serverConf)
+		if err != nil {
+			return
+		}
+		for req := range reqs {
+			if req.Type != hostKeysProveRequest {
+				req.Reply(false, nil)
+				continue
+			}
+			var reply []byte
+			payload := req.Payload
+			for len(payload) > 0 {
+				var blob []byte
+				blob, payload, _ = parseString(payload)
+				key, _ := ParsePublicKey(blob)
+				sig, err := signers[string(blob)].Sign(rand.Reader, hostKeyProofData(conn.SessionID(), key))
+				if err != nil {
+					t.Errorf("Sign: %v", err)
+				}
+				reply = appendString(reply, string(Marshal(sig)))
+			}
+			req.Reply(true, reply)
+		}
+	}()
+
+	clientConf := &ClientConfig{
+		User:            "user",
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	}
+	conn, chans, reqs, err := NewClientConn(c2, "", clientConf)
+	if err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+	client := NewClient(conn, chans, reqs)
+	defer client.Close()
+
+	keys := []PublicKey{testPublicKeys["ecdsa"], testPublicKeys["dsa"], testPublicKeys["ed25519"]}
+	proven, err := client.ProveHostKeys(keys)
+	if err != nil {
+		t.Fatalf("ProveHostKeys: %v", err)
+	}
+	if len(proven) != 2 || !bytes.Equal(proven[0].Marshal(), keys[0].Marshal()) || !bytes.Equal(proven[1].Marshal(), keys[2].Marshal()) {
+		t.Errorf("got %d proven keys, want the ecdsa and ed25519 keys", len(proven))
+	}
+}
+
+func TestDialHappyEyeballs(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				serverConf := &ServerConfig{
+					NoClientAuth: true,
+				}
+				serverConf.AddHostKey(testSigners["rsa"])
+				NewServerConn(c, serverConf)
+			}()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	addr := net.JoinHostPort("localhost", port)
+	var remote net.Addr
+	clientConf := &ClientConfig{
+		User: "user",
+		HostKeyCallback: func(hostname string, r net.Addr, key PublicKey) error {
+			if hostname != addr {
+				t.Errorf("got hostname %q, want %q", hostname, addr)
+			}
+			remote = r
+			return nil
+		},
+	}
+	client, err := DialHappyEyeballs(context.Background(), addr, clientConf)
+	if err != nil {
+		t.Fatalf("DialHappyEyeballs: %v", err)
+	}
+	client.Close()
+	if remote == nil || remote.String() != l.Addr().String() {
+		t.Errorf("HostKeyCallback got remote address %v, want %v", remote, l.Addr())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := DialHappyEyeballs(ctx, addr, clientConf); err == nil {
+		t.Error("DialHappyEyeballs succeeded with a cancelled context")
+	}
+}
+
+func TestDialHappyEyeballsCancelHandshake(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	// The server reads the client version, but never answers.
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- c
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		c := <-accepted
+		defer c.Close()
+		readVersion(c)
+		cancel()
+	}()
+	clientConf := &ClientConfig{
+		User:            "user",
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	}
+	if _, err := DialHappyEyeballs(ctx, l.Addr().String(), clientConf); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestClientUnderlyingConn(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		serverConf := &ServerConfig{
+			NoClientAuth: true,
+		}
+		serverConf.AddHostKey(testSigners["rsa"])
+		if conn, _, reqs, err := NewServerConn(c, serverConf); err == nil {
+			go DiscardRequests(reqs)
+			conn.Wait()
+		}
+	}()
+
+	client, err := Dial("tcp", l.Addr().String(), &ClientConfig{
+		User:            "user",
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	tcpConn, ok := client.UnderlyingConn().(*net.TCPConn)
+	if !ok {
+		t.Fatalf("got underlying conn %T, want *net.TCPConn", client.UnderlyingConn())
+	}
+	if tcpConn.RemoteAddr().String() != l.Addr().String() {
+		t.Errorf("got remote address %v, want %v", tcpConn.RemoteAddr(), l.Addr())
+	}
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		t.Errorf("SetKeepAlive: %v", err)
+	}
+	// The client still works after tuning the socket.
+	if _, _, err := client.SendRequest("ping@example.com", true, nil); err != nil {
+		t.Errorf("SendRequest: %v", err)
+	}
+
+	if c := (&Client{}).UnderlyingConn(); c != nil {
+		t.Errorf("got underlying conn %v for a Client without one", c)
+	}
+}
+
+// closeRecorder is a Conn from outside the package: it only records
+// whether it was closed.
+type closeRecorder struct {
+	Conn
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestClientShutdownFallback(t *testing.T) {
+	conn := &closeRecorder{}
+	client := &Client{Conn: conn}
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if !conn.closed {
+		t.Errorf("Shutdown did not close a Conn without graceful shutdown support")
+	}
+}
+
+// blockingRequester is a Conn from outside the package whose global
+// requests are answered only once release is closed.
+type blockingRequester struct {
+	Conn
+	release chan struct{}
+}
+
+func (c *blockingRequester) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	<-c.release
+	return true, []byte(name), nil
+}
+
+func TestClientSendRequestContextFallback(t *testing.T) {
+	conn := &blockingRequester{release: make(chan struct{})}
+	client := &Client{Conn: conn}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := client.SendRequestContext(ctx, "slow", true, nil); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(conn.release)
+	ok, payload, err := client.SendRequestContext(context.Background(), "fast", true, nil)
+	if err != nil {
+		t.Fatalf("SendRequestContext: %v", err)
+	}
+	if !ok || string(payload) != "fast" {
+		t.Errorf("got %v, %q, want true, %q", ok, payload, "fast")
+	}
+}
+
+func TestHandleUnknownChannelOpens(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	serverConf := &ServerConfig{
+		NoClientAuth: true,
+	}
+	serverConf.AddHostKey(testSigners["rsa"])
+	serverErrs := make(chan error, 2)
+	start := make(chan struct{})
+	go func() {
+		conn, _, reqs, err := NewServerConn(c1, serverConf)
+		if err != nil {
+			serverErrs <- err
+			return
+		}
+		go DiscardRequests(reqs)
+		<-start
+		for _, chanType := range []string{"known@example.com", "vendor@example.com"} {
+			ch, in, err := conn.OpenChannel(chanType, nil)
+			if err == nil {
+				go DiscardRequests(in)
+				ch.Close()
+			}
+			serverErrs <- err
+		}
+	}()
+
+	clientConf := &ClientConfig{
+		User:            "user",
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	}
+	conn, chans, reqs, err := NewClientConn(c2, "", clientConf)
+	if err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+	client := NewClient(conn, chans, reqs)
+	defer client.Close()
+
+	known := client.HandleChannelOpen("known@example.com")
+	unknown := client.HandleUnknownChannelOpens()
+	if client.HandleUnknownChannelOpens() != nil {
+		t.Error("second HandleUnknownChannelOpens returned a channel")
+	}
+	close(start)
+
+	newCh := <-known
+	ch, _, err := newCh.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	ch.Close()
+	if err := <-serverErrs; err != nil {
+		t.Fatalf("OpenChannel: %v", err)
+	}
+
+	newCh = <-unknown
+	if got := newCh.ChannelType(); got != "vendor@example.com" {
+		t.Errorf("got channel type %q, want vendor@example.com", got)
+	}
+	newCh.Reject(Prohibited, "not today")
+	err = <-serverErrs
+	if openErr, ok := err.(*OpenChannelError); !ok || openErr.Reason != Prohibited || openErr.Message != "not today" {
+		t.Errorf("got error %v, want the rejection", err)
+	}
+}
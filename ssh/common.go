@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"sort"
 	"sync"
 
 	_ "crypto/sha1"
@@ -24,44 +25,153 @@ const (
 	serviceSSH      = "ssh-connection"
 )
 
-// supportedCiphers specifies the supported ciphers in preference order.
+// supportedCiphers specifies the default ciphers in preference order.
+// Weak ciphers are left out; see legacyCiphers.
 var supportedCiphers = []string{
+	chacha20Poly1305ID,
 	"aes128-ctr", "aes192-ctr", "aes256-ctr",
-	"aes128-gcm@openssh.com",
-	"arcfour256", "arcfour128",
+	gcmCipherID, gcm256CipherID,
 }
 
-// supportedKexAlgos specifies the supported key-exchange algorithms in
+// supportedKexAlgos specifies the default key-exchange algorithms in
 // preference order.
 var supportedKexAlgos = []string{
 	kexAlgoCurve25519SHA256,
+	kexAlgoSNTRUP761X25519SHA512OpenSSH, kexAlgoSNTRUP761X25519SHA512,
 	// P384 and P521 are not constant-time yet, but since we don't
 	// reuse ephemeral keys, using them for ECDH should be OK.
 	kexAlgoECDH256, kexAlgoECDH384, kexAlgoECDH521,
-	kexAlgoDH14SHA1, kexAlgoDH1SHA1,
+	kexAlgoDHGEXSHA256,
+	kexAlgoDH16SHA512, kexAlgoDH18SHA512,
 }
 
-// supportedHostKeyAlgos specifies the supported host-key algorithms (i.e. methods
+// supportedHostKeyAlgos specifies the default host-key algorithms (i.e. methods
 // of authenticating servers) in preference order.
+// KeyAlgoRSA is kept despite its SHA-1 signatures, as RSA host keys would
+// not work at all without it.
 var supportedHostKeyAlgos = []string{
-	CertAlgoRSAv01, CertAlgoDSAv01, CertAlgoECDSA256v01,
+	CertAlgoRSAv01, CertAlgoECDSA256v01,
 	CertAlgoECDSA384v01, CertAlgoECDSA521v01, CertAlgoED25519v01,
 
 	KeyAlgoECDSA256, KeyAlgoECDSA384, KeyAlgoECDSA521,
-	KeyAlgoRSA, KeyAlgoDSA,
+	KeyAlgoRSA,
 
 	KeyAlgoED25519,
 }
 
 // supportedMACs specifies a default set of MAC algorithms in preference order.
-// This is based on RFC 4253, section 6.4, but with hmac-md5 variants removed
-// because they have reached the end of their useful life.
+// This is based on RFC 4253, section 6.4, but with hmac-md5 and hmac-sha1
+// variants removed because they have reached the end of their useful life.
 var supportedMACs = []string{
-	"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256", "hmac-sha1", "hmac-sha1-96",
+	"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256",
 }
 
+// The legacy algorithms are implemented, but only negotiated when they
+// are listed explicitly in a Config or ClientConfig.
+var (
+	legacyCiphers = []string{
+		"arcfour256", "arcfour128", "arcfour",
+		aes128cbcID, tripledescbcID,
+	}
+	legacyMACs         = []string{"hmac-sha1", "hmac-sha1-96"}
+	legacyKexAlgos     = []string{kexAlgoDH14SHA1, kexAlgoDH1SHA1}
+	legacyHostKeyAlgos = []string{CertAlgoDSAv01, KeyAlgoDSA}
+)
+
 var supportedCompressions = []string{compressionNone}
 
+// Algorithms lists algorithm names by their use in the protocol.
+type Algorithms struct {
+	Ciphers      []string
+	MACs         []string
+	KeyExchanges []string
+	HostKeys     []string
+}
+
+// LegacyAlgorithms returns the algorithms that this package implements
+// but leaves out of its defaults because they are considered weak: the
+// arcfour and CBC ciphers, the SHA-1 MACs and Diffie-Hellman key
+// exchanges, and DSA host keys. Callers that must talk to old peers can
+// opt into them by appending them to the defaults, as in
+//
+//	config.Ciphers = append(ssh.DefaultAlgorithms().Ciphers, ssh.LegacyAlgorithms().Ciphers...)
+//
+// ssh-rsa is not part of this set, because it is still used by default;
+// see DefaultAlgorithms.
+func LegacyAlgorithms() Algorithms {
+	return Algorithms{
+		Ciphers:      append([]string(nil), legacyCiphers...),
+		MACs:         append([]string(nil), legacyMACs...),
+		KeyExchanges: append([]string(nil), legacyKexAlgos...),
+		HostKeys:     append([]string(nil), legacyHostKeyAlgos...),
+	}
+}
+
+// DefaultAlgorithms returns the algorithms used when the corresponding
+// Config or ClientConfig fields are empty, in preference order. It
+// excludes the LegacyAlgorithms. The host keys include ssh-rsa, which
+// signs with SHA-1, because it is the only signature algorithm this
+// package implements for RSA keys.
+func DefaultAlgorithms() Algorithms {
+	return Algorithms{
+		Ciphers:      append([]string(nil), supportedCiphers...),
+		MACs:         append([]string(nil), supportedMACs...),
+		KeyExchanges: append([]string(nil), supportedKexAlgos...),
+		HostKeys:     append([]string(nil), supportedHostKeyAlgos...),
+	}
+}
+
+// SupportedCiphers returns the names of all ciphers this package
+// implements: the ones used by default, in preference order, followed
+// by the ones that must be enabled explicitly in Config.Ciphers.
+func SupportedCiphers() []string {
+	var extra []string
+	for name := range cipherModes {
+		extra = append(extra, name)
+	}
+	return appendMissing(supportedCiphers, extra)
+}
+
+// SupportedMACs returns the names of all MAC algorithms this package
+// implements, the default ones first, in preference order.
+func SupportedMACs() []string {
+	var extra []string
+	for name := range macModes {
+		extra = append(extra, name)
+	}
+	return appendMissing(supportedMACs, extra)
+}
+
+// SupportedKexAlgos returns the names of all key exchange algorithms
+// this package implements, in preference order.
+func SupportedKexAlgos() []string {
+	var extra []string
+	for name := range kexAlgoMap {
+		extra = append(extra, name)
+	}
+	return appendMissing(supportedKexAlgos, extra)
+}
+
+// SupportedHostKeyAlgos returns the names of all host key algorithms,
+// including certificate algorithms, that this package implements, the
+// default ones first, in preference order.
+func SupportedHostKeyAlgos() []string {
+	return appendMissing(supportedHostKeyAlgos, legacyHostKeyAlgos)
+}
+
+// appendMissing returns a copy of names followed by the sorted entries
+// of extra that are not already in names.
+func appendMissing(names, extra []string) []string {
+	result := append([]string(nil), names...)
+	sort.Strings(extra)
+	for _, e := range extra {
+		if !containsMethod(names, e) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
 // hashFuncs keeps the mapping of supported algorithms to their respective
 // hashes needed for signature verification.
 var hashFuncs = map[string]crypto.Hash{
@@ -96,7 +206,7 @@ func findCommon(what string, client []string, server []string) (common string, e
 			}
 		}
 	}
-	return "", fmt.Errorf("ssh: no common algorithm for %s; client offered: %v, server offered: %v", what, client, server)
+	return "", fmt.Errorf("%w for %s; client offered: %v, server offered: %v", ErrNoCommonAlgorithm, what, client, server)
 }
 
 type directionAlgorithms struct {
@@ -111,7 +221,7 @@ func (a *directionAlgorithms) rekeyBytes() int64 {
 	// 2^(BLOCKSIZE/4) blocks. For all AES flavors BLOCKSIZE is
 	// 128.
 	switch a.Cipher {
-	case "aes128-ctr", "aes192-ctr", "aes256-ctr", gcmCipherID, aes128cbcID:
+	case "aes128-ctr", "aes192-ctr", "aes256-ctr", gcmCipherID, gcm256CipherID, aes128cbcID:
 		return 16 * (1 << 32)
 
 	}
@@ -182,7 +292,10 @@ const minRekeyThreshold uint64 = 256
 type Config struct {
 	// Rand provides the source of entropy for cryptographic
 	// primitives. If Rand is nil, the cryptographic random reader
-	// in package crypto/rand will be used.
+	// in package crypto/rand will be used. The ML-KEM keys and
+	// ciphertexts of the mlkem768x25519-sha256 key exchange are
+	// always drawn from crypto/rand, as package crypto/mlkem takes
+	// no reader.
 	Rand io.Reader
 
 	// The maximum number of bytes sent or received after which a
@@ -190,17 +303,43 @@ type Config struct {
 	// unspecified, a size suitable for the chosen cipher is used.
 	RekeyThreshold uint64
 
+	// MaxPacketLength is the largest packet, in bytes, that is
+	// accepted from the peer. A packet that claims to be longer
+	// fails the connection before any memory is allocated for it.
+	// If unspecified, 256 KiB is used, like OpenSSH does. Values
+	// below the 35000 bytes that RFC 4253 requires to be accepted
+	// are raised to it, and values above 16 MiB are lowered to it.
+	MaxPacketLength uint32
+
 	// The allowed key exchanges algorithms. If unspecified then a
-	// default set of algorithms is used.
+	// default set of algorithms is used, which leaves out the
+	// LegacyAlgorithms.
 	KeyExchanges []string
 
 	// The allowed cipher algorithms. If unspecified then a sensible
-	// default is used.
+	// default is used, which leaves out the LegacyAlgorithms.
 	Ciphers []string
 
 	// The allowed MAC algorithms. If unspecified then a sensible default
-	// is used.
+	// is used, which leaves out the LegacyAlgorithms.
 	MACs []string
+
+	// PacketTraceCallback, if non-nil, is called for every packet
+	// sent or received on the connection, including key exchange
+	// and ignore messages. Only the message type and the payload
+	// length are reported; the payload itself is never exposed, so
+	// the callback cannot leak secrets. It is called from the
+	// goroutines doing the I/O and must not block.
+	PacketTraceCallback func(dir Direction, msgType byte, length int)
+
+	// VersionExchangeWriter, if non-nil, writes the version line
+	// at the start of the connection, instead of writing version
+	// followed by "\r\n". It must write version exactly, as it is
+	// part of the key exchange hash, terminated by "\r\n". A
+	// server may write other lines, which must not start with
+	// "SSH-", before it (RFC 4253, section 4.2); a client must
+	// not.
+	VersionExchangeWriter func(w io.Writer, version string) error
 }
 
 // SetDefaults sets sensible values for unset fields in config. This is
@@ -230,6 +369,14 @@ func (c *Config) SetDefaults() {
 		c.MACs = supportedMACs
 	}
 
+	if c.MaxPacketLength == 0 {
+		c.MaxPacketLength = maxPacket
+	} else if c.MaxPacketLength < minPacketLimit {
+		c.MaxPacketLength = minPacketLimit
+	} else if c.MaxPacketLength > maxPacketLimit {
+		c.MaxPacketLength = maxPacketLimit
+	}
+
 	if c.RekeyThreshold == 0 {
 		// cipher specific default
 	} else if c.RekeyThreshold < minRekeyThreshold {
@@ -0,0 +1,86 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"testing"
+)
+
+func TestSupportedAlgorithms(t *testing.T) {
+	ciphers := SupportedCiphers()
+	for i, name := range supportedCiphers {
+		if ciphers[i] != name {
+			t.Errorf("SupportedCiphers()[%d] = %q, want default %q first", i, ciphers[i], name)
+		}
+	}
+	if len(ciphers) != len(cipherModes) {
+		t.Errorf("SupportedCiphers() returned %d ciphers, want %d", len(ciphers), len(cipherModes))
+	}
+	for _, name := range ciphers {
+		if cipherModes[name] == nil {
+			t.Errorf("SupportedCiphers() returned unknown cipher %q", name)
+		}
+	}
+	if !containsMethod(ciphers, tripledescbcID) {
+		t.Errorf("SupportedCiphers() is missing %q, which is not enabled by default", tripledescbcID)
+	}
+
+	for _, name := range SupportedMACs() {
+		if macModes[name] == nil {
+			t.Errorf("SupportedMACs() returned unknown MAC %q", name)
+		}
+	}
+	kex := SupportedKexAlgos()
+	if len(kex) != len(kexAlgoMap) {
+		t.Errorf("SupportedKexAlgos() returned %d algorithms, want %d", len(kex), len(kexAlgoMap))
+	}
+	for _, name := range kex {
+		if kexAlgoMap[name] == nil {
+			t.Errorf("SupportedKexAlgos() returned unknown algorithm %q", name)
+		}
+	}
+
+	// The results are copies.
+	hostKeyAlgos := SupportedHostKeyAlgos()
+	hostKeyAlgos[0] = "modified"
+	if SupportedHostKeyAlgos()[0] == "modified" || supportedHostKeyAlgos[0] == "modified" {
+		t.Error("modifying the result of SupportedHostKeyAlgos changed the defaults")
+	}
+	SupportedCiphers()[0] = "modified"
+	if supportedCiphers[0] == "modified" {
+		t.Error("modifying the result of SupportedCiphers changed the defaults")
+	}
+}
+
+func TestLegacyAlgorithms(t *testing.T) {
+	legacy, defaults := LegacyAlgorithms(), DefaultAlgorithms()
+	for _, l := range [][2][]string{
+		{legacy.Ciphers, defaults.Ciphers},
+		{legacy.MACs, defaults.MACs},
+		{legacy.KeyExchanges, defaults.KeyExchanges},
+		{legacy.HostKeys, defaults.HostKeys},
+	} {
+		for _, name := range l[0] {
+			if containsMethod(l[1], name) {
+				t.Errorf("legacy algorithm %q is used by default", name)
+			}
+		}
+	}
+
+	supported := append(append(append(SupportedCiphers(), SupportedMACs()...), SupportedKexAlgos()...), SupportedHostKeyAlgos()...)
+	for _, name := range append(append(append(legacy.Ciphers, legacy.MACs...), legacy.KeyExchanges...), legacy.HostKeys...) {
+		if !containsMethod(supported, name) {
+			t.Errorf("legacy algorithm %q is not supported", name)
+		}
+	}
+
+	var c Config
+	c.SetDefaults()
+	for _, name := range c.Ciphers {
+		if containsMethod(legacy.Ciphers, name) {
+			t.Errorf("SetDefaults enabled legacy cipher %q", name)
+		}
+	}
+}
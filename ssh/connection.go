@@ -5,6 +5,7 @@
 package ssh
 
 import (
+	"context"
 	"fmt"
 	"net"
 )
@@ -74,6 +75,57 @@ type Conn interface {
 	//   Disconnect
 }
 
+// shutdowner is implemented by the Conns of this package, which can
+// drain their channels before closing.
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// shutdownConn gracefully shuts down c if it supports it, and closes
+// it otherwise.
+func shutdownConn(ctx context.Context, c Conn) error {
+	if s, ok := c.(shutdowner); ok {
+		return s.Shutdown(ctx)
+	}
+	return c.Close()
+}
+
+// contextRequester is implemented by the Conns of this package, which
+// can abandon a pending global request.
+type contextRequester interface {
+	SendRequestContext(ctx context.Context, name string, wantReply bool, payload []byte) (bool, []byte, error)
+}
+
+type requestResult struct {
+	ok      bool
+	payload []byte
+	err     error
+}
+
+// sendRequestContext sends a global request on c, and returns the
+// context's error once ctx is done. If c cannot abandon the request
+// itself, SendRequest keeps running in the background and its result
+// is dropped.
+func sendRequestContext(ctx context.Context, c Conn, name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	if r, ok := c.(contextRequester); ok {
+		return r.SendRequestContext(ctx, name, wantReply, payload)
+	}
+	if err := ctx.Err(); err != nil {
+		return false, nil, err
+	}
+	done := make(chan requestResult, 1)
+	go func() {
+		ok, payload, err := c.SendRequest(name, wantReply, payload)
+		done <- requestResult{ok, payload, err}
+	}()
+	select {
+	case r := <-done:
+		return r.ok, r.payload, r.err
+	case <-ctx.Done():
+		return false, nil, ctx.Err()
+	}
+}
+
 // DiscardRequests consumes and rejects all requests from the
 // passed-in channel.
 func DiscardRequests(in <-chan *Request) {
@@ -0,0 +1,101 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"errors"
+	"net"
+)
+
+// These errors classify common connection failures. The errors returned
+// by this package carry more detail, and should be compared with
+// errors.Is rather than ==.
+var (
+	// ErrAuthFailed means that the client could not authenticate with
+	// any of its methods. It matches the client's error, and the
+	// ServerAuthError of a server that gave up on the client.
+	ErrAuthFailed = errors.New("ssh: unable to authenticate")
+
+	// ErrHostKeyMismatch means that the server's host key differs from
+	// the one that was expected, as reported by FixedHostKey and by
+	// knownhosts.KeyError.
+	ErrHostKeyMismatch = errors.New("ssh: host key mismatch")
+
+	// ErrNoCommonAlgorithm means that the two sides could not agree on
+	// an algorithm during key exchange.
+	ErrNoCommonAlgorithm = errors.New("ssh: no common algorithm")
+
+	// ErrHandshakeTimeout means that a deadline set on the underlying
+	// net.Conn expired before the handshake completed. The error also
+	// unwraps to the net.Error that reported the timeout.
+	ErrHandshakeTimeout = errors.New("ssh: handshake timed out")
+
+	// ErrPasswordExpired means that the server refused the password
+	// because it has expired and must be changed first. It matches
+	// the PasswordExpiredError returned by the password AuthMethods.
+	ErrPasswordExpired = errors.New("ssh: password expired")
+
+	// ErrUnimplemented is returned for a request that the peer
+	// answered with SSH_MSG_UNIMPLEMENTED, as some minimal servers
+	// do for requests they do not know. The connection stays usable.
+	ErrUnimplemented = errors.New("ssh: request not implemented by peer")
+)
+
+// PasswordExpiredError is returned when the server answers password
+// authentication with a password change request (RFC 4252, section 8),
+// which servers send when the password has expired. This package does
+// not change passwords, so authentication stops there; the
+// PasswordExpiredError matches ErrPasswordExpired.
+type PasswordExpiredError struct {
+	// Prompt is the server's message to display to the user.
+	Prompt string
+
+	// Language is the RFC 3066 language tag of Prompt.
+	Language string
+}
+
+func (e *PasswordExpiredError) Error() string {
+	if e.Prompt == "" {
+		return ErrPasswordExpired.Error()
+	}
+	return ErrPasswordExpired.Error() + ": " + e.Prompt
+}
+
+// Is reports whether target is ErrPasswordExpired.
+func (e *PasswordExpiredError) Is(target error) bool {
+	return target == ErrPasswordExpired
+}
+
+// handshakeTimeoutError wraps a timeout from the network connection
+// during the handshake. It is a net.Error, like the error it wraps, so
+// that callers can still check Timeout.
+type handshakeTimeoutError struct {
+	err error
+}
+
+func (e *handshakeTimeoutError) Error() string { return e.err.Error() }
+
+func (e *handshakeTimeoutError) Unwrap() error { return e.err }
+
+func (e *handshakeTimeoutError) Is(target error) bool { return target == ErrHandshakeTimeout }
+
+func (e *handshakeTimeoutError) Timeout() bool { return true }
+
+func (e *handshakeTimeoutError) Temporary() bool {
+	if netErr, ok := e.err.(net.Error); ok {
+		return netErr.Temporary()
+	}
+	return false
+}
+
+// classifyHandshakeError marks network timeouts in err, a handshake
+// failure, as ErrHandshakeTimeout.
+func classifyHandshakeError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &handshakeTimeoutError{err}
+	}
+	return err
+}
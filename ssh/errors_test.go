@@ -0,0 +1,121 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestErrAuthFailed(t *testing.T) {
+	config := &ClientConfig{
+		User:            "testuser",
+		Auth:            []AuthMethod{Password("wrong")},
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	}
+	err := tryAuth(t, config)
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("got error %v, want one matching ErrAuthFailed", err)
+	}
+	if errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("auth failure matches ErrHostKeyMismatch")
+	}
+
+	if !errors.Is(&ServerAuthError{}, ErrAuthFailed) {
+		t.Error("ServerAuthError does not match ErrAuthFailed")
+	}
+}
+
+func TestErrHostKeyMismatch(t *testing.T) {
+	config := &ClientConfig{
+		User:            "testuser",
+		Auth:            []AuthMethod{Password(clientPassword)},
+		HostKeyCallback: FixedHostKey(testPublicKeys["ecdsa"]),
+	}
+	if err := tryAuth(t, config); !errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("got error %v, want one matching ErrHostKeyMismatch", err)
+	}
+}
+
+func TestErrNoCommonAlgorithm(t *testing.T) {
+	config := &ClientConfig{
+		User:            "testuser",
+		Auth:            []AuthMethod{Password(clientPassword)},
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	}
+	config.Ciphers = []string{"unknown-cipher"}
+	if err := tryAuth(t, config); !errors.Is(err, ErrNoCommonAlgorithm) {
+		t.Errorf("got error %v, want one matching ErrNoCommonAlgorithm", err)
+	}
+}
+
+func TestErrHandshakeTimeout(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	// The server never answers.
+	c2.SetDeadline(time.Now().Add(50 * time.Millisecond))
+	_, _, _, err = NewClientConn(c2, "", &ClientConfig{
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	})
+	if !errors.Is(err, ErrHandshakeTimeout) {
+		t.Fatalf("got error %v, want one matching ErrHandshakeTimeout", err)
+	}
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("got error %v, want a net.Error timeout", err)
+	}
+}
+
+func TestErrHandshakeTimeoutServer(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	// The client never answers.
+	c2.SetDeadline(time.Now().Add(50 * time.Millisecond))
+	serverConf := &ServerConfig{NoClientAuth: true}
+	serverConf.AddHostKey(testSigners["ecdsa"])
+	_, _, _, err = NewServerConn(c2, serverConf)
+	if !errors.Is(err, ErrHandshakeTimeout) {
+		t.Fatalf("got error %v, want one matching ErrHandshakeTimeout", err)
+	}
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("got error %v, want a net.Error timeout", err)
+	}
+}
+
+func TestErrPasswordExpired(t *testing.T) {
+	c, s := memPipe()
+	defer c.Close()
+	defer s.Close()
+
+	go func() {
+		if _, err := s.readPacket(); err != nil {
+			return
+		}
+		s.writePacket(Marshal(&userAuthPasswdChangeReqMsg{
+			Prompt:   "Your password has expired.",
+			Language: "en",
+		}))
+	}()
+
+	_, _, err := Password("old").auth(nil, "user", c, nil)
+	if !errors.Is(err, ErrPasswordExpired) {
+		t.Fatalf("got error %v, want one matching ErrPasswordExpired", err)
+	}
+	var expired *PasswordExpiredError
+	if !errors.As(err, &expired) || expired.Prompt != "Your password has expired." || expired.Language != "en" {
+		t.Errorf("got %#v, want the server's prompt", err)
+	}
+}
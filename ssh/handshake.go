@@ -50,6 +50,12 @@ type handshakeTransport struct {
 	// connection.
 	hostKeys []Signer
 
+	// gexModuli holds the groups a server offers for
+	// diffie-hellman-group-exchange; maxGexBits, if non-zero, caps
+	// their size.
+	gexModuli  []GexModulus
+	maxGexBits uint32
+
 	// hostKeyAlgorithms is non-empty if we are the client. In that case,
 	// we accept these key types from the server as host key.
 	hostKeyAlgorithms []string
@@ -89,6 +95,9 @@ type handshakeTransport struct {
 
 	// The session ID or nil if first kex did not complete yet.
 	sessionID []byte
+
+	// The host key algorithm of the first kex.
+	sessionHostKeyAlgo string
 }
 
 type pendingKex struct {
@@ -115,6 +124,15 @@ func newHandshakeTransport(conn keyingTransport, config *Config, clientVersion,
 	return t
 }
 
+// sentRequest returns the request that was sent with seqNum, if the
+// underlying transport remembers it.
+func (t *handshakeTransport) sentRequest(seqNum uint32) []byte {
+	if s, ok := t.conn.(requestRecorder); ok {
+		return s.sentRequest(seqNum)
+	}
+	return nil
+}
+
 func newClientTransport(conn keyingTransport, clientVersion, serverVersion []byte, config *ClientConfig, dialAddr string, addr net.Addr) *handshakeTransport {
 	t := newHandshakeTransport(conn, &config.Config, clientVersion, serverVersion)
 	t.dialAddress = dialAddr
@@ -133,6 +151,10 @@ func newClientTransport(conn keyingTransport, clientVersion, serverVersion []byt
 func newServerTransport(conn keyingTransport, clientVersion, serverVersion []byte, config *ServerConfig) *handshakeTransport {
 	t := newHandshakeTransport(conn, &config.Config, clientVersion, serverVersion)
 	t.hostKeys = config.hostKeys
+	t.gexModuli = config.GexModuli
+	if config.MaxGexBits > 0 {
+		t.maxGexBits = uint32(config.MaxGexBits)
+	}
 	go t.readLoop()
 	go t.kexLoop()
 	return t
@@ -426,6 +448,27 @@ func (t *handshakeTransport) readOnePacket(first bool) ([]byte, error) {
 	return successPacket, nil
 }
 
+// kexAlgos returns the key exchange algorithms to offer. A server
+// can only do group exchange if it has groups to choose from, and
+// does not offer fixed groups larger than maxGexBits.
+func (t *handshakeTransport) kexAlgos() []string {
+	if len(t.hostKeys) == 0 || (len(t.gexModuli) > 0 && t.maxGexBits == 0) {
+		return t.config.KeyExchanges
+	}
+
+	var algos []string
+	for _, algo := range t.config.KeyExchanges {
+		if algo == kexAlgoDHGEXSHA256 && len(t.gexModuli) == 0 {
+			continue
+		}
+		if group, ok := kexAlgoMap[algo].(*dhGroup); ok && t.maxGexBits > 0 && group.p.BitLen() > int(t.maxGexBits) {
+			continue
+		}
+		algos = append(algos, algo)
+	}
+	return algos
+}
+
 // sendKexInit sends a key change message.
 func (t *handshakeTransport) sendKexInit() error {
 	t.mu.Lock()
@@ -439,7 +482,7 @@ func (t *handshakeTransport) sendKexInit() error {
 	}
 
 	msg := &kexInitMsg{
-		KexAlgos:                t.config.KeyExchanges,
+		KexAlgos:                t.kexAlgos(),
 		CiphersClientServer:     t.config.Ciphers,
 		CiphersServerClient:     t.config.Ciphers,
 		MACsClientServer:        t.config.MACs,
@@ -586,6 +629,7 @@ func (t *handshakeTransport) enterKeyExchange(otherInitPacket []byte) error {
 
 	if t.sessionID == nil {
 		t.sessionID = result.H
+		t.sessionHostKeyAlgo = t.algorithms.hostKey
 	}
 	result.SessionID = t.sessionID
 
@@ -612,6 +656,10 @@ func (t *handshakeTransport) server(kex kexAlgorithm, algs *algorithms, magics *
 		}
 	}
 
+	if gex, ok := kex.(*dhGEXSHA); ok {
+		kex = gex.withModuli(t.gexModuli, t.maxGexBits)
+	}
+
 	r, err := kex.Server(t.conn, t.config.Rand, magics, hostKey)
 	return r, err
 }
@@ -0,0 +1,31 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package hostaddr writes host addresses the way OpenSSH records them in
+// known_hosts files. It is shared by package ssh and package
+// golang.org/x/crypto/ssh/knownhosts.
+package hostaddr
+
+import (
+	"net"
+	"strings"
+)
+
+// Normalize returns address, a host name or address with an optional
+// port, in the form used in known_hosts: the port is left out if it is
+// 22, and the host is bracketed otherwise or if it is an IPv6 address.
+func Normalize(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+		port = "22"
+	}
+	entry := host
+	if port != "22" {
+		entry = "[" + entry + "]:" + port
+	} else if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+		entry = "[" + entry + "]"
+	}
+	return entry
+}
@@ -0,0 +1,722 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sntrup761 implements the Streamlined NTRU Prime sntrup761 key
+// encapsulation mechanism, as used by the
+// sntrup761x25519-sha512@openssh.com key exchange.
+//
+// This code is a port of the public domain "ref" implementation from
+// SUPERCOP, which is also the one shipped with OpenSSH.
+package sntrup761
+
+import (
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"io"
+)
+
+const (
+	p   = 761
+	q   = 4591
+	w   = 286
+	q12 = (q - 1) / 2
+
+	roundedBytes = 1007
+	rqBytes      = 1158
+	smallBytes   = (p + 3) / 4
+	hashBytes    = 32
+	confirmBytes = 32
+
+	secretKeysBytes  = 2 * smallBytes
+	inputsBytes      = smallBytes
+	ciphertextsBytes = roundedBytes
+)
+
+const (
+	// PublicKeySize is the size of an encoded public key.
+	PublicKeySize = rqBytes
+
+	// PrivateKeySize is the size of an encoded private key.
+	PrivateKeySize = secretKeysBytes + PublicKeySize + inputsBytes + hashBytes
+
+	// CiphertextSize is the size of an encapsulated key.
+	CiphertextSize = ciphertextsBytes + confirmBytes
+
+	// SharedKeySize is the size of the shared key.
+	SharedKeySize = 32
+)
+
+// small holds an element of F3, represented as -1, 0 or 1.
+type small int8
+
+// fq holds an element of Fq, represented in -q12...q12.
+type fq int16
+
+// ----- arithmetic mod 3 and mod q, in constant time
+
+// uint32DivmodUint14 returns x/m and x%m. m must be in 1...16383.
+func uint32DivmodUint14(x uint32, m uint16) (uint32, uint16) {
+	v := uint32(0x80000000) / uint32(m)
+
+	qpart := uint32((uint64(x) * uint64(v)) >> 31)
+	x -= qpart * uint32(m)
+	quot := qpart
+
+	qpart = uint32((uint64(x) * uint64(v)) >> 31)
+	x -= qpart * uint32(m)
+	quot += qpart
+
+	x -= uint32(m)
+	quot++
+	mask := -(x >> 31)
+	x += mask & uint32(m)
+	quot += mask
+
+	return quot, uint16(x)
+}
+
+func uint32ModUint14(x uint32, m uint16) uint16 {
+	_, r := uint32DivmodUint14(x, m)
+	return r
+}
+
+// int32ModUint14 returns x mod m in 0...m-1.
+func int32ModUint14(x int32, m uint16) uint16 {
+	_, ur := uint32DivmodUint14(0x80000000+uint32(x), m)
+	_, ur2 := uint32DivmodUint14(0x80000000, m)
+	ur -= ur2
+	mask := -(ur >> 15)
+	ur += mask & m
+	return ur
+}
+
+// int16NonzeroMask returns -1 if x != 0, and 0 otherwise.
+func int16NonzeroMask(x int16) int {
+	v := uint32(uint16(x))
+	v = -v
+	v >>= 31
+	return -int(v)
+}
+
+// int16NegativeMask returns -1 if x < 0, and 0 otherwise.
+func int16NegativeMask(x int16) int {
+	return -int(uint16(x) >> 15)
+}
+
+func f3Freeze(x int16) small {
+	return small(int32ModUint14(int32(x)+1, 3)) - 1
+}
+
+func fqFreeze(x int32) fq {
+	return fq(int32(int32ModUint14(x+q12, q)) - q12)
+}
+
+// fqRecip returns 1/a1, computed as a1^(q-2).
+func fqRecip(a1 fq) fq {
+	ai := a1
+	for i := 1; i < q-2; i++ {
+		ai = fqFreeze(int32(a1) * int32(ai))
+	}
+	return ai
+}
+
+// ----- polynomials mod x^p-x-1
+
+// weightwMask returns 0 if r has weight w, and -1 otherwise.
+func weightwMask(r []small) int {
+	weight := 0
+	for i := 0; i < p; i++ {
+		weight += int(r[i] & 1)
+	}
+	return int16NonzeroMask(int16(weight - w))
+}
+
+func r3FromRq(out []small, r []fq) {
+	for i := 0; i < p; i++ {
+		out[i] = f3Freeze(int16(r[i]))
+	}
+}
+
+// r3Mult sets h = f*g in the ring R3.
+func r3Mult(h, f, g []small) {
+	var fg [p + p - 1]small
+	for i := 0; i < p; i++ {
+		var result small
+		for j := 0; j <= i; j++ {
+			result = f3Freeze(int16(result) + int16(f[j])*int16(g[i-j]))
+		}
+		fg[i] = result
+	}
+	for i := p; i < p+p-1; i++ {
+		var result small
+		for j := i - p + 1; j < p; j++ {
+			result = f3Freeze(int16(result) + int16(f[j])*int16(g[i-j]))
+		}
+		fg[i] = result
+	}
+	for i := p + p - 2; i >= p; i-- {
+		fg[i-p] = f3Freeze(int16(fg[i-p]) + int16(fg[i]))
+		fg[i-p+1] = f3Freeze(int16(fg[i-p+1]) + int16(fg[i]))
+	}
+	copy(h, fg[:p])
+}
+
+// r3Recip sets out = 1/in in the ring R3. It returns 0 on success and
+// -1 if in is not invertible.
+func r3Recip(out, in []small) int {
+	var f, g, v, r [p + 1]small
+
+	r[0] = 1
+	f[0] = 1
+	f[p-1] = -1
+	f[p] = -1
+	for i := 0; i < p; i++ {
+		g[p-1-i] = in[i]
+	}
+
+	delta := 1
+	for loop := 0; loop < 2*p-1; loop++ {
+		for i := p; i > 0; i-- {
+			v[i] = v[i-1]
+		}
+		v[0] = 0
+
+		sign := -int16(g[0]) * int16(f[0])
+		swap := int16NegativeMask(int16(-delta)) & int16NonzeroMask(int16(g[0]))
+		delta ^= swap & (delta ^ -delta)
+		delta++
+
+		for i := 0; i < p+1; i++ {
+			t := small(swap) & (f[i] ^ g[i])
+			f[i] ^= t
+			g[i] ^= t
+			t = small(swap) & (v[i] ^ r[i])
+			v[i] ^= t
+			r[i] ^= t
+		}
+
+		for i := 0; i < p+1; i++ {
+			g[i] = f3Freeze(int16(g[i]) + sign*int16(f[i]))
+		}
+		for i := 0; i < p+1; i++ {
+			r[i] = f3Freeze(int16(r[i]) + sign*int16(v[i]))
+		}
+
+		for i := 0; i < p; i++ {
+			g[i] = g[i+1]
+		}
+		g[p] = 0
+	}
+
+	sign := f[0]
+	for i := 0; i < p; i++ {
+		out[i] = sign * v[p-1-i]
+	}
+
+	return int16NonzeroMask(int16(delta))
+}
+
+// rqMultSmall sets h = f*g in the ring Rq.
+func rqMultSmall(h, f []fq, g []small) {
+	var fg [p + p - 1]fq
+	for i := 0; i < p; i++ {
+		var result fq
+		for j := 0; j <= i; j++ {
+			result = fqFreeze(int32(result) + int32(f[j])*int32(g[i-j]))
+		}
+		fg[i] = result
+	}
+	for i := p; i < p+p-1; i++ {
+		var result fq
+		for j := i - p + 1; j < p; j++ {
+			result = fqFreeze(int32(result) + int32(f[j])*int32(g[i-j]))
+		}
+		fg[i] = result
+	}
+	for i := p + p - 2; i >= p; i-- {
+		fg[i-p] = fqFreeze(int32(fg[i-p]) + int32(fg[i]))
+		fg[i-p+1] = fqFreeze(int32(fg[i-p+1]) + int32(fg[i]))
+	}
+	copy(h, fg[:p])
+}
+
+// rqMult3 sets h = 3f in the ring Rq.
+func rqMult3(h, f []fq) {
+	for i := 0; i < p; i++ {
+		h[i] = fqFreeze(3 * int32(f[i]))
+	}
+}
+
+// rqRecip3 sets out = 1/(3*in) in the ring Rq. It returns 0 on success
+// and -1 if in is not invertible.
+func rqRecip3(out []fq, in []small) int {
+	var f, g, v, r [p + 1]fq
+
+	r[0] = fqRecip(3)
+	f[0] = 1
+	f[p-1] = -1
+	f[p] = -1
+	for i := 0; i < p; i++ {
+		g[p-1-i] = fq(in[i])
+	}
+
+	delta := 1
+	for loop := 0; loop < 2*p-1; loop++ {
+		for i := p; i > 0; i-- {
+			v[i] = v[i-1]
+		}
+		v[0] = 0
+
+		swap := int16NegativeMask(int16(-delta)) & int16NonzeroMask(int16(g[0]))
+		delta ^= swap & (delta ^ -delta)
+		delta++
+
+		for i := 0; i < p+1; i++ {
+			t := fq(swap) & (f[i] ^ g[i])
+			f[i] ^= t
+			g[i] ^= t
+			t = fq(swap) & (v[i] ^ r[i])
+			v[i] ^= t
+			r[i] ^= t
+		}
+
+		f0 := int32(f[0])
+		g0 := int32(g[0])
+		for i := 0; i < p+1; i++ {
+			g[i] = fqFreeze(f0*int32(g[i]) - g0*int32(f[i]))
+		}
+		for i := 0; i < p+1; i++ {
+			r[i] = fqFreeze(f0*int32(r[i]) - g0*int32(v[i]))
+		}
+
+		for i := 0; i < p; i++ {
+			g[i] = g[i+1]
+		}
+		g[p] = 0
+	}
+
+	scale := int32(fqRecip(f[0]))
+	for i := 0; i < p; i++ {
+		out[i] = fqFreeze(scale * int32(v[p-1-i]))
+	}
+
+	return int16NonzeroMask(int16(delta))
+}
+
+// round rounds each coefficient of a to a multiple of 3.
+func round(out, a []fq) {
+	for i := 0; i < p; i++ {
+		out[i] = a[i] - fq(f3Freeze(int16(a[i])))
+	}
+}
+
+// ----- sorting, to generate short polynomials
+
+// sortUint32 sorts x in constant time, using the djbsort network.
+func sortUint32(x []uint32) {
+	n := len(x)
+	if n < 2 {
+		return
+	}
+
+	// Flip the sign bits so that the values sort as signed integers.
+	y := make([]int32, n)
+	for i := range x {
+		y[i] = int32(x[i] ^ 0x80000000)
+	}
+
+	minmax := func(a, b *int32) {
+		ab := *b ^ *a
+		c := *b - *a
+		c ^= ab & (c ^ *b)
+		c >>= 31
+		c &= ab
+		*a ^= c
+		*b ^= c
+	}
+
+	top := 1
+	for top < n-top {
+		top += top
+	}
+	for pp := top; pp > 0; pp >>= 1 {
+		for i := 0; i < n-pp; i++ {
+			if i&pp == 0 {
+				minmax(&y[i], &y[i+pp])
+			}
+		}
+		i := 0
+		for qq := top; qq > pp; qq >>= 1 {
+			for ; i < n-qq; i++ {
+				if i&pp == 0 {
+					a := y[i+pp]
+					for r := qq; r > pp; r >>= 1 {
+						minmax(&a, &y[i+r])
+					}
+					y[i+pp] = a
+				}
+			}
+		}
+	}
+
+	for i := range x {
+		x[i] = uint32(y[i]) ^ 0x80000000
+	}
+}
+
+// shortFromList turns a list of random numbers into a polynomial with
+// exactly w nonzero coefficients.
+func shortFromList(out []small, in []uint32) {
+	var l [p]uint32
+	for i := 0; i < w; i++ {
+		l[i] = in[i] &^ 1
+	}
+	for i := w; i < p; i++ {
+		l[i] = (in[i] &^ 2) | 1
+	}
+	sortUint32(l[:])
+	for i := 0; i < p; i++ {
+		out[i] = small(l[i]&3) - 1
+	}
+}
+
+// ----- randomness
+
+func urandom32(rand io.Reader, n int) ([]uint32, error) {
+	buf := make([]byte, 4*n)
+	if _, err := io.ReadFull(rand, buf); err != nil {
+		return nil, err
+	}
+	out := make([]uint32, n)
+	for i := range out {
+		c := buf[4*i:]
+		out[i] = uint32(c[0]) | uint32(c[1])<<8 | uint32(c[2])<<16 | uint32(c[3])<<24
+	}
+	return out, nil
+}
+
+func shortRandom(rand io.Reader, out []small) error {
+	l, err := urandom32(rand, p)
+	if err != nil {
+		return err
+	}
+	shortFromList(out, l)
+	return nil
+}
+
+func smallRandom(rand io.Reader, out []small) error {
+	l, err := urandom32(rand, p)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < p; i++ {
+		out[i] = small(((l[i]&0x3fffffff)*3)>>30) - 1
+	}
+	return nil
+}
+
+// ----- Streamlined NTRU Prime core
+
+func keyGen(rand io.Reader, h []fq, f, ginv []small) error {
+	var g [p]small
+	for {
+		if err := smallRandom(rand, g[:]); err != nil {
+			return err
+		}
+		if r3Recip(ginv, g[:]) == 0 {
+			break
+		}
+	}
+
+	if err := shortRandom(rand, f); err != nil {
+		return err
+	}
+
+	var finv [p]fq
+	rqRecip3(finv[:], f) // always works
+	rqMultSmall(h, finv[:], g[:])
+	return nil
+}
+
+func encrypt(c []fq, r []small, h []fq) {
+	var hr [p]fq
+	rqMultSmall(hr[:], h, r)
+	round(c, hr[:])
+}
+
+func decrypt(r []small, c []fq, f, ginv []small) {
+	var cf, cf3 [p]fq
+	var e, ev [p]small
+
+	rqMultSmall(cf[:], c, f)
+	rqMult3(cf3[:], cf[:])
+	r3FromRq(e[:], cf3[:])
+	r3Mult(ev[:], e[:], ginv)
+
+	mask := small(weightwMask(ev[:])) // 0 if weight w, else -1
+	for i := 0; i < w; i++ {
+		r[i] = ((ev[i] ^ 1) &^ mask) ^ 1
+	}
+	for i := w; i < p; i++ {
+		r[i] = ev[i] &^ mask
+	}
+}
+
+// ----- encoding of vectors of integers in 0...M[i]-1
+
+func encode(out []byte, r, m []uint16) []byte {
+	if len(m) == 1 {
+		r0, m0 := r[0], m[0]
+		for m0 > 1 {
+			out = append(out, byte(r0))
+			r0 >>= 8
+			m0 = (m0 + 255) >> 8
+		}
+		return out
+	}
+
+	n := len(m)
+	r2 := make([]uint16, (n+1)/2)
+	m2 := make([]uint16, (n+1)/2)
+	i := 0
+	for ; i < n-1; i += 2 {
+		m0 := uint32(m[i])
+		rr := uint32(r[i]) + uint32(r[i+1])*m0
+		mm := uint32(m[i+1]) * m0
+		for mm >= 16384 {
+			out = append(out, byte(rr))
+			rr >>= 8
+			mm = (mm + 255) >> 8
+		}
+		r2[i/2] = uint16(rr)
+		m2[i/2] = uint16(mm)
+	}
+	if i < n {
+		r2[i/2] = r[i]
+		m2[i/2] = m[i]
+	}
+	return encode(out, r2, m2)
+}
+
+func decode(out []uint16, s []byte, m []uint16) {
+	if len(m) == 1 {
+		switch {
+		case m[0] == 1:
+			out[0] = 0
+		case m[0] <= 256:
+			out[0] = uint32ModUint14(uint32(s[0]), m[0])
+		default:
+			out[0] = uint32ModUint14(uint32(s[0])+uint32(s[1])<<8, m[0])
+		}
+		return
+	}
+
+	n := len(m)
+	r2 := make([]uint16, (n+1)/2)
+	m2 := make([]uint16, (n+1)/2)
+	bottomr := make([]uint16, n/2)
+	bottomt := make([]uint32, n/2)
+	i := 0
+	for ; i < n-1; i += 2 {
+		mm := uint32(m[i]) * uint32(m[i+1])
+		switch {
+		case mm > 256*16383:
+			bottomt[i/2] = 256 * 256
+			bottomr[i/2] = uint16(s[0]) + 256*uint16(s[1])
+			s = s[2:]
+			m2[i/2] = uint16((((mm + 255) >> 8) + 255) >> 8)
+		case mm >= 16384:
+			bottomt[i/2] = 256
+			bottomr[i/2] = uint16(s[0])
+			s = s[1:]
+			m2[i/2] = uint16((mm + 255) >> 8)
+		default:
+			bottomt[i/2] = 1
+			bottomr[i/2] = 0
+			m2[i/2] = uint16(mm)
+		}
+	}
+	if i < n {
+		m2[i/2] = m[i]
+	}
+
+	decode(r2, s, m2)
+
+	o := 0
+	for i = 0; i < n-1; i += 2 {
+		rr := uint32(bottomr[i/2]) + bottomt[i/2]*uint32(r2[i/2])
+		r1, r0 := uint32DivmodUint14(rr, m[i])
+		out[o] = r0
+		out[o+1] = uint32ModUint14(r1, m[i+1]) // only needed for invalid inputs
+		o += 2
+	}
+	if i < n {
+		out[o] = r2[i/2]
+	}
+}
+
+// ----- encoding of polynomials
+
+func rqEncode(r []fq) []byte {
+	var rr, m [p]uint16
+	for i := 0; i < p; i++ {
+		rr[i] = uint16(int32(r[i]) + q12)
+		m[i] = q
+	}
+	return encode(make([]byte, 0, rqBytes), rr[:], m[:])
+}
+
+func rqDecode(r []fq, s []byte) {
+	var rr, m [p]uint16
+	for i := 0; i < p; i++ {
+		m[i] = q
+	}
+	decode(rr[:], s, m[:])
+	for i := 0; i < p; i++ {
+		r[i] = fq(int32(rr[i]) - q12)
+	}
+}
+
+func roundedEncode(r []fq) []byte {
+	var rr, m [p]uint16
+	for i := 0; i < p; i++ {
+		rr[i] = uint16(((int32(r[i]) + q12) * 10923) >> 15)
+		m[i] = (q + 2) / 3
+	}
+	return encode(make([]byte, 0, roundedBytes), rr[:], m[:])
+}
+
+func roundedDecode(r []fq, s []byte) {
+	var rr, m [p]uint16
+	for i := 0; i < p; i++ {
+		m[i] = (q + 2) / 3
+	}
+	decode(rr[:], s, m[:])
+	for i := 0; i < p; i++ {
+		r[i] = fq(int32(rr[i])*3 - q12)
+	}
+}
+
+func smallEncode(f []small) []byte {
+	s := make([]byte, 0, smallBytes)
+	for i := 0; i < p/4; i++ {
+		x := byte(f[4*i]+1) | byte(f[4*i+1]+1)<<2 | byte(f[4*i+2]+1)<<4 | byte(f[4*i+3]+1)<<6
+		s = append(s, x)
+	}
+	return append(s, byte(f[p-1]+1))
+}
+
+func smallDecode(f []small, s []byte) {
+	for i := 0; i < p/4; i++ {
+		x := s[i]
+		f[4*i] = small(x&3) - 1
+		f[4*i+1] = small((x>>2)&3) - 1
+		f[4*i+2] = small((x>>4)&3) - 1
+		f[4*i+3] = small((x>>6)&3) - 1
+	}
+	f[p-1] = small(s[p/4]&3) - 1
+}
+
+// ----- hashing
+
+// hashPrefix returns the first 32 bytes of SHA-512(b || in).
+func hashPrefix(b byte, in ...[]byte) []byte {
+	h := sha512.New()
+	h.Write([]byte{b})
+	for _, x := range in {
+		h.Write(x)
+	}
+	return h.Sum(nil)[:hashBytes]
+}
+
+// hashConfirm returns the confirmation hash of rEnc. cache is
+// hashPrefix(4, pk).
+func hashConfirm(rEnc, cache []byte) []byte {
+	return hashPrefix(2, hashPrefix(3, rEnc), cache)
+}
+
+func hashSession(b byte, y, z []byte) []byte {
+	return hashPrefix(b, hashPrefix(3, y), z)
+}
+
+// hide encrypts r to pk and appends the confirmation hash.
+func hide(r []small, pk, cache []byte) (c, rEnc []byte) {
+	var h, ct [p]fq
+	rEnc = smallEncode(r)
+	rqDecode(h[:], pk)
+	encrypt(ct[:], r, h[:])
+	c = roundedEncode(ct[:])
+	c = append(c, hashConfirm(rEnc, cache)...)
+	return c, rEnc
+}
+
+// ----- KEM
+
+// GenerateKey generates a new key pair, using entropy from rand.
+func GenerateKey(rand io.Reader) (publicKey, privateKey []byte, err error) {
+	var h [p]fq
+	var f, v [p]small
+	if err := keyGen(rand, h[:], f[:], v[:]); err != nil {
+		return nil, nil, err
+	}
+	publicKey = rqEncode(h[:])
+
+	rho := make([]byte, inputsBytes)
+	if _, err := io.ReadFull(rand, rho); err != nil {
+		return nil, nil, err
+	}
+
+	privateKey = make([]byte, 0, PrivateKeySize)
+	privateKey = append(privateKey, smallEncode(f[:])...)
+	privateKey = append(privateKey, smallEncode(v[:])...)
+	privateKey = append(privateKey, publicKey...)
+	privateKey = append(privateKey, rho...)
+	privateKey = append(privateKey, hashPrefix(4, publicKey)...)
+	return publicKey, privateKey, nil
+}
+
+// Encapsulate generates a shared key and encrypts it to publicKey,
+// using entropy from rand.
+func Encapsulate(rand io.Reader, publicKey []byte) (ciphertext, sharedKey []byte, err error) {
+	if len(publicKey) != PublicKeySize {
+		return nil, nil, errors.New("sntrup761: bad public key length")
+	}
+
+	var r [p]small
+	if err := shortRandom(rand, r[:]); err != nil {
+		return nil, nil, err
+	}
+	c, rEnc := hide(r[:], publicKey, hashPrefix(4, publicKey))
+	return c, hashSession(1, rEnc, c), nil
+}
+
+// Decapsulate returns the shared key encapsulated in ciphertext. As
+// required for IND-CCA2 security, an invalid ciphertext yields a
+// pseudorandom key rather than an error.
+func Decapsulate(privateKey, ciphertext []byte) (sharedKey []byte, err error) {
+	if len(privateKey) != PrivateKeySize {
+		return nil, errors.New("sntrup761: bad private key length")
+	}
+	if len(ciphertext) != CiphertextSize {
+		return nil, errors.New("sntrup761: bad ciphertext length")
+	}
+
+	sk := privateKey[:secretKeysBytes]
+	pk := privateKey[secretKeysBytes : secretKeysBytes+PublicKeySize]
+	rho := privateKey[secretKeysBytes+PublicKeySize : secretKeysBytes+PublicKeySize+inputsBytes]
+	cache := privateKey[secretKeysBytes+PublicKeySize+inputsBytes:]
+
+	var f, v, r [p]small
+	var c [p]fq
+	smallDecode(f[:], sk)
+	smallDecode(v[:], sk[smallBytes:])
+	roundedDecode(c[:], ciphertext)
+	decrypt(r[:], c[:], f[:], v[:])
+
+	cnew, rEnc := hide(r[:], pk, cache)
+	ok := subtle.ConstantTimeCompare(ciphertext, cnew)
+	subtle.ConstantTimeCopy(1-ok, rEnc, rho)
+	return hashSession(byte(ok), rEnc, ciphertext), nil
+}
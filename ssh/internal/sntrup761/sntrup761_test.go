@@ -0,0 +1,188 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sntrup761
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	mathrand "math/rand"
+	"sort"
+	"testing"
+)
+
+func TestSortUint32(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 17, 64, p} {
+		x := make([]uint32, n)
+		for i := range x {
+			x[i] = mathrand.Uint32()
+		}
+		want := append([]uint32(nil), x...)
+		sort.Slice(want, func(i, j int) bool { return want[i] < want[j] })
+
+		sortUint32(x)
+		for i := range x {
+			if x[i] != want[i] {
+				t.Fatalf("n=%d: element %d is %x, want %x", n, i, x[i], want[i])
+			}
+		}
+	}
+}
+
+func TestDivmod(t *testing.T) {
+	for _, m := range []uint16{1, 3, 1531, q, 16383} {
+		for i := 0; i < 1000; i++ {
+			x := mathrand.Uint32()
+			quot, r := uint32DivmodUint14(x, m)
+			if quot != x/uint32(m) || uint32(r) != x%uint32(m) {
+				t.Fatalf("%d divmod %d: got %d, %d", x, m, quot, r)
+			}
+
+			y := int32(x)
+			want := y % int32(m)
+			if want < 0 {
+				want += int32(m)
+			}
+			if got := int32ModUint14(y, m); int32(got) != want {
+				t.Fatalf("%d mod %d: got %d, want %d", y, m, got, want)
+			}
+		}
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	var r, r2 [p]fq
+	for i := range r {
+		r[i] = fq(mathrand.Intn(q) - q12)
+	}
+	enc := rqEncode(r[:])
+	if len(enc) != rqBytes {
+		t.Fatalf("encoded Rq element is %d bytes, want %d", len(enc), rqBytes)
+	}
+	rqDecode(r2[:], enc)
+	if r != r2 {
+		t.Error("Rq element does not survive encoding")
+	}
+
+	round(r[:], r[:])
+	enc = roundedEncode(r[:])
+	if len(enc) != roundedBytes {
+		t.Fatalf("encoded rounded element is %d bytes, want %d", len(enc), roundedBytes)
+	}
+	roundedDecode(r2[:], enc)
+	if r != r2 {
+		t.Error("rounded element does not survive encoding")
+	}
+
+	var s, s2 [p]small
+	if err := shortRandom(rand.Reader, s[:]); err != nil {
+		t.Fatal(err)
+	}
+	if weightwMask(s[:]) != 0 {
+		t.Error("short polynomial has the wrong weight")
+	}
+	smallDecode(s2[:], smallEncode(s[:]))
+	if s != s2 {
+		t.Error("small element does not survive encoding")
+	}
+}
+
+func TestKEM(t *testing.T) {
+	pk, sk, err := GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if len(pk) != PublicKeySize || len(sk) != PrivateKeySize {
+		t.Fatalf("got key sizes %d, %d", len(pk), len(sk))
+	}
+
+	for i := 0; i < 3; i++ {
+		c, k, err := Encapsulate(rand.Reader, pk)
+		if err != nil {
+			t.Fatalf("Encapsulate: %v", err)
+		}
+		if len(c) != CiphertextSize || len(k) != SharedKeySize {
+			t.Fatalf("got ciphertext of %d bytes, key of %d bytes", len(c), len(k))
+		}
+
+		k2, err := Decapsulate(sk, c)
+		if err != nil {
+			t.Fatalf("Decapsulate: %v", err)
+		}
+		if !bytes.Equal(k, k2) {
+			t.Fatal("decapsulated key does not match")
+		}
+
+		c[0] ^= 1
+		k3, err := Decapsulate(sk, c)
+		if err != nil {
+			t.Fatalf("Decapsulate: %v", err)
+		}
+		if bytes.Equal(k, k3) {
+			t.Fatal("tampered ciphertext yields the same key")
+		}
+	}
+}
+
+// lcgReader is a deterministic source of bytes for known-answer tests:
+// each byte is the top byte of the next state of a 64-bit LCG.
+type lcgReader struct{ state uint64 }
+
+func (l *lcgReader) Read(b []byte) (int, error) {
+	for i := range b {
+		l.state = l.state*6364136223846793005 + 1442695040888963407
+		b[i] = byte(l.state >> 56)
+	}
+	return len(b), nil
+}
+
+// TestKEMKnownAnswer pins the output for the lcgReader streams below.
+// These are regression values computed with this package, not the
+// published KAT. They catch changes in how the randomness is consumed
+// or the keys are encoded, but say nothing about interoperability with
+// other implementations.
+func TestKEMKnownAnswer(t *testing.T) {
+	const (
+		pkSHA256 = "251116e44c9a3e3a7055da465a15b7274fb602108d2e85f6155d569cfe00c7dc"
+		skSHA256 = "42d0e5e1e03392a24284a5e61d9eb5aaadd3e2654b66cbe2bda48d247fa217ef"
+		ctSHA256 = "53c2ebb7573d6f0fa9a44370f345eeaa47d4ab67420b6bde531a2bceed00fad7"
+		key      = "0a1207ec439f7d59b9f6516028df0d7ef0d8cd3da33821a49a4dbc8c5725ca46"
+	)
+	digest := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+
+	pk, sk, err := GenerateKey(&lcgReader{0xd75b3c6bc36bba41})
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if got := digest(pk); got != pkSHA256 {
+		t.Errorf("public key has SHA-256 %s, want %s", got, pkSHA256)
+	}
+	if got := digest(sk); got != skSHA256 {
+		t.Errorf("private key has SHA-256 %s, want %s", got, skSHA256)
+	}
+
+	c, k, err := Encapsulate(&lcgReader{2}, pk)
+	if err != nil {
+		t.Fatalf("Encapsulate: %v", err)
+	}
+	if got := digest(c); got != ctSHA256 {
+		t.Errorf("ciphertext has SHA-256 %s, want %s", got, ctSHA256)
+	}
+	if got := hex.EncodeToString(k); got != key {
+		t.Errorf("got shared key %s, want %s", got, key)
+	}
+
+	k2, err := Decapsulate(sk, c)
+	if err != nil {
+		t.Fatalf("Decapsulate: %v", err)
+	}
+	if got := hex.EncodeToString(k2); got != key {
+		t.Errorf("decapsulated shared key %s, want %s", got, key)
+	}
+}
@@ -19,6 +19,9 @@ func TestKexes(t *testing.T) {
 	}
 
 	for name, kex := range kexAlgoMap {
+		if gex, ok := kex.(*dhGEXSHA); ok {
+			kex = gex.withModuli(testModuli(t), 0)
+		}
 		a, b := memPipe()
 
 		s := make(chan kexResultErr, 1)
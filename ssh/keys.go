@@ -7,10 +7,14 @@ package ssh
 import (
 	"bytes"
 	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
 	"crypto/dsa"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/md5"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
@@ -25,6 +29,8 @@ import (
 	"strings"
 
 	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/pkcs12"
+	"golang.org/x/crypto/ssh/internal/hostaddr"
 )
 
 // These constants represent the algorithm names for key types supported by this
@@ -36,6 +42,11 @@ const (
 	KeyAlgoECDSA384 = "ecdsa-sha2-nistp384"
 	KeyAlgoECDSA521 = "ecdsa-sha2-nistp521"
 	KeyAlgoED25519  = "ssh-ed25519"
+
+	// Keys held by FIDO/U2F security keys, see PROTOCOL.u2f in the
+	// OpenSSH source.
+	KeyAlgoSKECDSA256 = "sk-ecdsa-sha2-nistp256@openssh.com"
+	KeyAlgoSKED25519  = "sk-ssh-ed25519@openssh.com"
 )
 
 // parsePubKey parses a public key of the given algorithm.
@@ -50,6 +61,10 @@ func parsePubKey(in []byte, algo string) (pubKey PublicKey, rest []byte, err err
 		return parseECDSA(in)
 	case KeyAlgoED25519:
 		return parseED25519(in)
+	case KeyAlgoSKECDSA256:
+		return parseSKECDSA(in)
+	case KeyAlgoSKED25519:
+		return parseSKEd25519(in)
 	case CertAlgoRSAv01, CertAlgoDSAv01, CertAlgoECDSA256v01, CertAlgoECDSA384v01, CertAlgoECDSA521v01, CertAlgoED25519v01:
 		cert, err := parseCert(in, certToPrivAlgo(algo))
 		if err != nil {
@@ -258,7 +273,11 @@ func ParsePublicKey(in []byte) (out PublicKey, err error) {
 }
 
 // MarshalAuthorizedKey serializes key for inclusion in an OpenSSH
-// authorized_keys file. The return value ends with newline.
+// authorized_keys file. The return value is the key type and the
+// base64 encoded key, without options or comment, and ends with
+// newline; it is the form ParseAuthorizedKey reads back. The same
+// serialization makes up the key field of a known_hosts line, see
+// KnownHostsLine.
 func MarshalAuthorizedKey(key PublicKey) []byte {
 	b := &bytes.Buffer{}
 	b.WriteString(key.Type())
@@ -270,6 +289,17 @@ func MarshalAuthorizedKey(key PublicKey) []byte {
 	return b.Bytes()
 }
 
+// KnownHostsLine returns the line, without trailing newline, that
+// records key as the host key of addr in an OpenSSH known_hosts file,
+// and that ParseKnownHosts reads back. addr is a host name or address
+// with an optional port; as in OpenSSH, the port is left out if it is
+// 22, and the host is bracketed otherwise. The
+// golang.org/x/crypto/ssh/knownhosts package has further helpers for
+// known_hosts files.
+func KnownHostsLine(addr string, key PublicKey) string {
+	return hostaddr.Normalize(addr) + " " + string(bytes.TrimSuffix(MarshalAuthorizedKey(key), []byte{'\n'}))
+}
+
 // PublicKey is an abstraction of different types of public keys.
 type PublicKey interface {
 	// Type returns the key's type, e.g. "ssh-rsa".
@@ -622,29 +652,291 @@ func (key *ecdsaPublicKey) Verify(data []byte, sig *Signature) error {
 	h.Write(data)
 	digest := h.Sum(nil)
 
-	// Per RFC 5656, section 3.1.2,
-	// The ecdsa_signature_blob value has the following specific encoding:
-	//    mpint    r
-	//    mpint    s
-	var ecSig struct {
-		R *big.Int
-		S *big.Int
-	}
-
-	if err := Unmarshal(sig.Blob, &ecSig); err != nil {
+	r, s, err := parseECDSASignatureBlob(sig.Blob)
+	if err != nil {
 		return err
 	}
 
-	if ecdsa.Verify((*ecdsa.PublicKey)(key), digest, ecSig.R, ecSig.S) {
+	if ecdsa.Verify((*ecdsa.PublicKey)(key), digest, r, s) {
 		return nil
 	}
 	return errors.New("ssh: signature did not verify")
 }
 
+// ecdsaSignature is the ecdsa_signature_blob of RFC 5656, section
+// 3.1.2.
+type ecdsaSignature struct {
+	R *big.Int
+	S *big.Int
+}
+
+// asn1Signature is the DER encoding of DSA and ECDSA signatures
+// produced by crypto.Signer implementations.
+type asn1Signature struct {
+	R, S *big.Int
+}
+
+// parseECDSASignatureBlob parses an SSH ECDSA signature blob. Only the
+// canonical encoding, two positive, minimally encoded mpints, is
+// accepted, so that a signature cannot be altered without invalidating
+// it.
+func parseECDSASignatureBlob(blob []byte) (r, s *big.Int, err error) {
+	var ecSig ecdsaSignature
+	if err := Unmarshal(blob, &ecSig); err != nil {
+		var der asn1Signature
+		if rest, derErr := asn1.Unmarshal(blob, &der); derErr == nil && len(rest) == 0 {
+			return nil, nil, errors.New("ssh: ECDSA signature is DER encoded; see ECDSASignatureFromDER")
+		}
+		return nil, nil, err
+	}
+	if ecSig.R.Sign() <= 0 || ecSig.S.Sign() <= 0 {
+		return nil, nil, errors.New("ssh: ECDSA signature values must be positive")
+	}
+	if !bytes.Equal(Marshal(&ecSig), blob) {
+		return nil, nil, errors.New("ssh: ECDSA signature is not canonically encoded")
+	}
+	return ecSig.R, ecSig.S, nil
+}
+
+// ECDSASignatureFromDER converts an ASN.1 DER encoded ECDSA signature,
+// as returned by crypto.Signer implementations for ECDSA keys, into
+// the blob of an SSH signature. The result is suitable for the Blob
+// field of a Signature whose Format is the key's type.
+func ECDSASignatureFromDER(der []byte) ([]byte, error) {
+	var sig asn1Signature
+	rest, err := asn1.Unmarshal(der, &sig)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("ssh: trailing data after ECDSA signature")
+	}
+	if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 {
+		return nil, errors.New("ssh: ECDSA signature values must be positive")
+	}
+	return Marshal(&ecdsaSignature{sig.R, sig.S}), nil
+}
+
 func (k *ecdsaPublicKey) CryptoPublicKey() crypto.PublicKey {
 	return (*ecdsa.PublicKey)(k)
 }
 
+// Flags that security keys report in their signatures.
+const (
+	// SKFlagUserPresent is set if the user touched the security key.
+	SKFlagUserPresent = 0x01
+
+	// SKFlagUserVerified is set if the security key verified the
+	// user, for instance with a PIN.
+	SKFlagUserVerified = 0x04
+)
+
+// skFields are the fields that follow the blob of a security key
+// signature. A Signer for a security key returns them, marshaled, in the
+// Rest field of its signatures.
+type skFields struct {
+	Flags   byte
+	Counter uint32
+}
+
+// parseSKFields parses the flags and counter of a security key signature.
+func parseSKFields(sig *Signature) (*skFields, error) {
+	var f skFields
+	if err := Unmarshal(sig.Rest, &f); err != nil {
+		return nil, errors.New("ssh: invalid security key signature")
+	}
+	return &f, nil
+}
+
+// skSignedData returns the data that a security key signs for data: the
+// hash of the application, the flags and counter, and the hash of data.
+func skSignedData(application string, data []byte, sig *Signature) ([]byte, error) {
+	f, err := parseSKFields(sig)
+	if err != nil {
+		return nil, err
+	}
+	appDigest := sha256.Sum256([]byte(application))
+	dataDigest := sha256.Sum256(data)
+
+	signed := append([]byte(nil), appDigest[:]...)
+	signed = append(signed, f.Flags)
+	signed = appendU32(signed, f.Counter)
+	return append(signed, dataDigest[:]...), nil
+}
+
+// skECDSAPublicKey is an ECDSA P-256 key held by a security key.
+type skECDSAPublicKey struct {
+	// application is the FIDO application string, usually "ssh:".
+	application string
+	ecdsa.PublicKey
+}
+
+func (k *skECDSAPublicKey) Type() string {
+	return KeyAlgoSKECDSA256
+}
+
+func parseSKECDSA(in []byte) (out PublicKey, rest []byte, err error) {
+	var w struct {
+		Curve       string
+		KeyBytes    []byte
+		Application string
+		Rest        []byte `ssh:"rest"`
+	}
+
+	if err := Unmarshal(in, &w); err != nil {
+		return nil, nil, err
+	}
+
+	if w.Curve != "nistp256" {
+		return nil, nil, errors.New("ssh: unsupported curve")
+	}
+	key := &skECDSAPublicKey{application: w.Application}
+	key.Curve = elliptic.P256()
+	key.X, key.Y = elliptic.Unmarshal(key.Curve, w.KeyBytes)
+	if key.X == nil || key.Y == nil {
+		return nil, nil, errors.New("ssh: invalid curve point")
+	}
+	return key, w.Rest, nil
+}
+
+func (k *skECDSAPublicKey) Marshal() []byte {
+	w := struct {
+		Name        string
+		ID          string
+		Key         []byte
+		Application string
+	}{
+		k.Type(),
+		"nistp256",
+		elliptic.Marshal(k.Curve, k.X, k.Y),
+		k.application,
+	}
+	return Marshal(&w)
+}
+
+func (k *skECDSAPublicKey) Verify(data []byte, sig *Signature) error {
+	if sig.Format != k.Type() {
+		return fmt.Errorf("ssh: signature type %s for key type %s", sig.Format, k.Type())
+	}
+
+	signed, err := skSignedData(k.application, data, sig)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(signed)
+
+	r, s, err := parseECDSASignatureBlob(sig.Blob)
+	if err != nil {
+		return err
+	}
+
+	if ecdsa.Verify(&k.PublicKey, digest[:], r, s) {
+		return nil
+	}
+	return errors.New("ssh: signature did not verify")
+}
+
+func (k *skECDSAPublicKey) CryptoPublicKey() crypto.PublicKey {
+	return &k.PublicKey
+}
+
+// skEd25519PublicKey is an Ed25519 key held by a security key.
+type skEd25519PublicKey struct {
+	// application is the FIDO application string, usually "ssh:".
+	application string
+	ed25519.PublicKey
+}
+
+func (k *skEd25519PublicKey) Type() string {
+	return KeyAlgoSKED25519
+}
+
+func parseSKEd25519(in []byte) (out PublicKey, rest []byte, err error) {
+	var w struct {
+		KeyBytes    []byte
+		Application string
+		Rest        []byte `ssh:"rest"`
+	}
+
+	if err := Unmarshal(in, &w); err != nil {
+		return nil, nil, err
+	}
+
+	if len(w.KeyBytes) != ed25519.PublicKeySize {
+		return nil, nil, errors.New("ssh: invalid ed25519 key size")
+	}
+	return &skEd25519PublicKey{
+		application: w.Application,
+		PublicKey:   ed25519.PublicKey(w.KeyBytes),
+	}, w.Rest, nil
+}
+
+func (k *skEd25519PublicKey) Marshal() []byte {
+	w := struct {
+		Name        string
+		KeyBytes    []byte
+		Application string
+	}{
+		KeyAlgoSKED25519,
+		[]byte(k.PublicKey),
+		k.application,
+	}
+	return Marshal(&w)
+}
+
+func (k *skEd25519PublicKey) Verify(data []byte, sig *Signature) error {
+	if sig.Format != k.Type() {
+		return fmt.Errorf("ssh: signature type %s for key type %s", sig.Format, k.Type())
+	}
+
+	signed, err := skSignedData(k.application, data, sig)
+	if err != nil {
+		return err
+	}
+
+	if ok := ed25519.Verify(k.PublicKey, signed, sig.Blob); !ok {
+		return errors.New("ssh: signature did not verify")
+	}
+	return nil
+}
+
+func (k *skEd25519PublicKey) CryptoPublicKey() crypto.PublicKey {
+	return k.PublicKey
+}
+
+// GenerateHostKey generates a new private key of the given type and
+// returns a Signer for it, suitable for ServerConfig.AddHostKey. It is
+// meant for test servers and embedded servers that create their host
+// key at startup. The supported types are "ed25519", "ecdsa-p256",
+// "ecdsa-p384", "ecdsa-p521", "rsa-2048", "rsa-3072" and "rsa-4096".
+// An empty keyType selects "ed25519".
+func GenerateHostKey(keyType string) (Signer, error) {
+	var key interface{}
+	var err error
+	switch keyType {
+	case "", "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case "ecdsa-p256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ecdsa-p521":
+		key, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "rsa-2048":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "rsa-3072":
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+	case "rsa-4096":
+		key, err = rsa.GenerateKey(rand.Reader, 4096)
+	default:
+		return nil, fmt.Errorf("ssh: unsupported host key type %q", keyType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewSignerFromKey(key)
+}
+
 // NewSignerFromKey takes an *rsa.PrivateKey, *dsa.PrivateKey,
 // *ecdsa.PrivateKey or any other crypto.Signer and returns a
 // corresponding Signer instance. ECDSA keys must use P-256, P-384 or
@@ -720,27 +1012,23 @@ func (s *wrappedSigner) Sign(rand io.Reader, data []byte) (*Signature, error) {
 	// for ECDSA and DSA, but that's not the encoding expected by SSH, so
 	// re-encode.
 	switch s.pubKey.(type) {
-	case *ecdsaPublicKey, *dsaPublicKey:
-		type asn1Signature struct {
-			R, S *big.Int
+	case *ecdsaPublicKey:
+		signature, err = ECDSASignatureFromDER(signature)
+		if err != nil {
+			return nil, err
 		}
+	case *dsaPublicKey:
 		asn1Sig := new(asn1Signature)
 		_, err := asn1.Unmarshal(signature, asn1Sig)
 		if err != nil {
 			return nil, err
 		}
 
-		switch s.pubKey.(type) {
-		case *ecdsaPublicKey:
-			signature = Marshal(asn1Sig)
-
-		case *dsaPublicKey:
-			signature = make([]byte, 40)
-			r := asn1Sig.R.Bytes()
-			s := asn1Sig.S.Bytes()
-			copy(signature[20-len(r):20], r)
-			copy(signature[40-len(s):40], s)
-		}
+		signature = make([]byte, 40)
+		r := asn1Sig.R.Bytes()
+		s := asn1Sig.S.Bytes()
+		copy(signature[20-len(r):20], r)
+		copy(signature[40-len(s):40], s)
 	}
 
 	return &Signature{
@@ -793,6 +1081,49 @@ func ParsePrivateKeyWithPassphrase(pemBytes, passPhrase []byte) (Signer, error)
 	return NewSignerFromKey(key)
 }
 
+// ParsePKCS12 returns a Signer for the private key stored in a PKCS#12
+// (.pfx or .p12) file, along with the certificates the file contains. The
+// file must hold exactly one RSA or ECDSA private key.
+func ParsePKCS12(data, password []byte) (Signer, []*x509.Certificate, error) {
+	blocks, err := pkcs12.ToPEM(data, string(password))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var key interface{}
+	var certs []*x509.Certificate
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			c, err := x509.ParseCertificates(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			certs = append(certs, c...)
+		case "PRIVATE KEY":
+			if key != nil {
+				return nil, nil, errors.New("ssh: PKCS#12 data contains more than one private key")
+			}
+			// pkcs12 converts RSA keys to PKCS#1 and ECDSA keys to
+			// SEC 1, but does not say which one it produced.
+			if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+				if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+					return nil, nil, errors.New("ssh: unsupported private key in PKCS#12 data")
+				}
+			}
+		}
+	}
+	if key == nil {
+		return nil, nil, errors.New("ssh: no private key found in PKCS#12 data")
+	}
+
+	signer, err := NewSignerFromKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return signer, certs, nil
+}
+
 // encryptedBlock tells whether a private key is
 // encrypted by examining its Proc-Type header
 // for a mention of ENCRYPTED
@@ -801,6 +1132,91 @@ func encryptedBlock(block *pem.Block) bool {
 	return strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED")
 }
 
+// pemCipher is a block cipher used in CBC mode to encrypt legacy PEM
+// private keys.
+type pemCipher struct {
+	keySize  int
+	newBlock func(key []byte) (cipher.Block, error)
+}
+
+// pemCiphers are the ciphers for the DEK-Info header that OpenSSL and
+// the tools modelled after it produce.
+var pemCiphers = map[string]pemCipher{
+	"AES-128-CBC":  {16, aes.NewCipher},
+	"AES-192-CBC":  {24, aes.NewCipher},
+	"AES-256-CBC":  {32, aes.NewCipher},
+	"DES-EDE3-CBC": {24, des.NewTripleDESCipher},
+	"DES-CBC":      {8, des.NewCipher},
+}
+
+// decryptPEMBlock decrypts a PEM block encrypted with passPhrase in the
+// legacy OpenSSL format of RFC 1421, where the DEK-Info header names the
+// cipher and holds the IV. The key is derived from the passphrase with
+// OpenSSL's EVP_BytesToKey, using MD5, one iteration, and the first
+// eight bytes of the IV as salt. A wrong passphrase is usually detected
+// by the padding check, and reported as x509.IncorrectPasswordError.
+func decryptPEMBlock(block *pem.Block, passPhrase []byte) ([]byte, error) {
+	dek, ok := block.Headers["DEK-Info"]
+	if !ok {
+		return nil, errors.New("ssh: encrypted private key has no DEK-Info header")
+	}
+	i := strings.IndexByte(dek, ',')
+	if i < 0 {
+		return nil, fmt.Errorf("ssh: malformed DEK-Info header %q", dek)
+	}
+	name, ivHex := strings.TrimSpace(dek[:i]), strings.TrimSpace(dek[i+1:])
+	c, ok := pemCiphers[strings.ToUpper(name)]
+	if !ok {
+		return nil, fmt.Errorf("ssh: unsupported private key cipher %q", name)
+	}
+	iv, err := hex.DecodeString(ivHex)
+	if err != nil {
+		return nil, fmt.Errorf("ssh: malformed DEK-Info IV: %v", err)
+	}
+	if len(iv) < 8 {
+		return nil, errors.New("ssh: DEK-Info IV has the wrong length")
+	}
+
+	var key []byte
+	var digest []byte
+	for len(key) < c.keySize {
+		h := md5.New()
+		h.Write(digest)
+		h.Write(passPhrase)
+		h.Write(iv[:8])
+		digest = h.Sum(nil)
+		key = append(key, digest...)
+	}
+	blockCipher, err := c.newBlock(key[:c.keySize])
+	if err != nil {
+		return nil, err
+	}
+
+	bs := blockCipher.BlockSize()
+	if len(iv) != bs {
+		return nil, errors.New("ssh: DEK-Info IV has the wrong length")
+	}
+	data := block.Bytes
+	if len(data) == 0 || len(data)%bs != 0 {
+		return nil, errors.New("ssh: encrypted private key is not a whole number of blocks")
+	}
+	buf := make([]byte, len(data))
+	cipher.NewCBCDecrypter(blockCipher, iv).CryptBlocks(buf, data)
+
+	// Check the PKCS #7 padding, which is where a wrong passphrase
+	// shows up.
+	pad := int(buf[len(buf)-1])
+	if pad == 0 || pad > bs {
+		return nil, x509.IncorrectPasswordError
+	}
+	for _, b := range buf[len(buf)-pad:] {
+		if int(b) != pad {
+			return nil, x509.IncorrectPasswordError
+		}
+	}
+	return buf[:len(buf)-pad], nil
+}
+
 // ParseRawPrivateKey returns a private key from a PEM encoded private key. It
 // supports RSA (PKCS#1), DSA (OpenSSL), and ECDSA private keys.
 func ParseRawPrivateKey(pemBytes []byte) (interface{}, error) {
@@ -828,8 +1244,10 @@ func ParseRawPrivateKey(pemBytes []byte) (interface{}, error) {
 }
 
 // ParseRawPrivateKeyWithPassphrase returns a private key decrypted with
-// passphrase from a PEM encoded private key. If wrong passphrase, return
-// x509.IncorrectPasswordError.
+// passphrase from a PEM encoded private key. Keys encrypted with the
+// Proc-Type and DEK-Info headers of older OpenSSL versions may use
+// AES-128-CBC, AES-192-CBC, AES-256-CBC, DES-EDE3-CBC or DES-CBC. If wrong
+// passphrase, return x509.IncorrectPasswordError.
 func ParseRawPrivateKeyWithPassphrase(pemBytes, passPhrase []byte) (interface{}, error) {
 	block, _ := pem.Decode(pemBytes)
 	if block == nil {
@@ -838,15 +1256,10 @@ func ParseRawPrivateKeyWithPassphrase(pemBytes, passPhrase []byte) (interface{},
 	buf := block.Bytes
 
 	if encryptedBlock(block) {
-		if x509.IsEncryptedPEMBlock(block) {
-			var err error
-			buf, err = x509.DecryptPEMBlock(block, passPhrase)
-			if err != nil {
-				if err == x509.IncorrectPasswordError {
-					return nil, err
-				}
-				return nil, fmt.Errorf("ssh: cannot decode encrypted private keys: %v", err)
-			}
+		var err error
+		buf, err = decryptPEMBlock(block, passPhrase)
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -11,9 +11,13 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"math/big"
 	"reflect"
 	"strings"
 	"testing"
@@ -107,6 +111,84 @@ func TestKeySignVerify(t *testing.T) {
 	}
 }
 
+func TestECDSASignatureEncoding(t *testing.T) {
+	priv := testPrivateKeys["ecdsa"].(*ecdsa.PrivateKey)
+	pub := testPublicKeys["ecdsa"]
+
+	data := []byte("sign me")
+	h := ecHash(priv.Curve).New()
+	h.Write(data)
+	der, err := priv.Sign(rand.Reader, h.Sum(nil), ecHash(priv.Curve))
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	blob, err := ECDSASignatureFromDER(der)
+	if err != nil {
+		t.Fatalf("ECDSASignatureFromDER: %v", err)
+	}
+	if err := pub.Verify(data, &Signature{Format: pub.Type(), Blob: blob}); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	err = pub.Verify(data, &Signature{Format: pub.Type(), Blob: der})
+	if err == nil || !strings.Contains(err.Error(), "DER") {
+		t.Errorf("Verify of DER signature: got %v, want DER error", err)
+	}
+
+	// Pad r with a redundant zero byte.
+	var sig ecdsaSignature
+	if err := Unmarshal(blob, &sig); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	rBytes := sig.R.Bytes()
+	if rBytes[0]&0x80 != 0 {
+		rBytes = append([]byte{0}, rBytes...)
+	}
+	padded := appendString(nil, string(append([]byte{0}, rBytes...)))
+	padded = append(padded, Marshal(struct{ S *big.Int }{sig.S})...)
+	if err := pub.Verify(data, &Signature{Format: pub.Type(), Blob: padded}); err == nil {
+		t.Error("Verify accepted a non-canonical signature")
+	}
+
+	if _, err := ECDSASignatureFromDER(append(der, 0)); err == nil {
+		t.Error("ECDSASignatureFromDER accepted trailing data")
+	}
+}
+
+func TestGenerateHostKey(t *testing.T) {
+	for keyType, want := range map[string]string{
+		"":           KeyAlgoED25519,
+		"ed25519":    KeyAlgoED25519,
+		"ecdsa-p256": KeyAlgoECDSA256,
+		"ecdsa-p521": KeyAlgoECDSA521,
+		"rsa-2048":   KeyAlgoRSA,
+	} {
+		signer, err := GenerateHostKey(keyType)
+		if err != nil {
+			t.Errorf("GenerateHostKey(%q): %v", keyType, err)
+			continue
+		}
+		if got := signer.PublicKey().Type(); got != want {
+			t.Errorf("GenerateHostKey(%q): got key type %s, want %s", keyType, got, want)
+		}
+
+		data := []byte("sign me")
+		sig, err := signer.Sign(rand.Reader, data)
+		if err != nil {
+			t.Errorf("Sign(%q): %v", keyType, err)
+			continue
+		}
+		if err := signer.PublicKey().Verify(data, sig); err != nil {
+			t.Errorf("Verify(%q): %v", keyType, err)
+		}
+	}
+
+	if _, err := GenerateHostKey("rsa-1024"); err == nil {
+		t.Error("GenerateHostKey accepted a weak key type")
+	}
+}
+
 func TestParseRSAPrivateKey(t *testing.T) {
 	key := testPrivateKeys["rsa"]
 
@@ -174,6 +256,32 @@ func TestParseEncryptedPrivateKeysWithPassphrase(t *testing.T) {
 	}
 }
 
+func TestParseEncryptedPrivateKeysErrors(t *testing.T) {
+	for _, tt := range testdata.PEMEncryptedKeys {
+		if _, err := ParsePrivateKeyWithPassphrase(tt.PEMBytes, []byte("incorrect")); err != x509.IncorrectPasswordError {
+			t.Errorf("%s: got %v for a wrong passphrase, want IncorrectPasswordError", tt.Name, err)
+		}
+	}
+
+	tt := testdata.PEMEncryptedKeys[2]
+	block, _ := pem.Decode(tt.PEMBytes)
+	for _, dek := range []string{
+		"",
+		"AES-256-CBC",
+		"BF-CBC,95C876D315C97711",
+		"AES-256-CBC,DE0C5439805E4D22",
+		"AES-256-CBC,not hex",
+	} {
+		block.Headers["DEK-Info"] = dek
+		if dek == "" {
+			delete(block.Headers, "DEK-Info")
+		}
+		if _, err := ParsePrivateKeyWithPassphrase(pem.EncodeToMemory(block), []byte(tt.EncryptionKey)); err == nil || err == x509.IncorrectPasswordError {
+			t.Errorf("DEK-Info %q: got error %v, want one about the header", dek, err)
+		}
+	}
+}
+
 func TestParseDSA(t *testing.T) {
 	// We actually exercise the ParsePrivateKey codepath here, as opposed to
 	// using the ParseRawPrivateKey+NewSignerFromKey path that testdata_test.go
@@ -433,6 +541,25 @@ var knownHostsParseTests = []struct {
 	},
 }
 
+func TestKnownHostsLine(t *testing.T) {
+	pub, pubSerialized := getTestKey()
+	line := KnownHostsLine("example.com:2222", pub)
+	if want := "[example.com]:2222 " + pub.Type() + " " + pubSerialized; line != want {
+		t.Errorf("got %q, want %q", line, want)
+	}
+
+	_, hosts, parsed, _, _, err := ParseKnownHosts([]byte(line))
+	if err != nil {
+		t.Fatalf("ParseKnownHosts: %v", err)
+	}
+	if !reflect.DeepEqual(hosts, []string{"[example.com]:2222"}) {
+		t.Errorf("got hosts %q", hosts)
+	}
+	if !bytes.Equal(parsed.Marshal(), pub.Marshal()) {
+		t.Errorf("got key %v, want %v", parsed, pub)
+	}
+}
+
 func TestKnownHostsParsing(t *testing.T) {
 	rsaPub, rsaPubSerialized := getTestKey()
 
@@ -498,3 +625,152 @@ func TestFingerprintSHA256(t *testing.T) {
 		t.Errorf("got fingerprint %q want %q", fingerprint, want)
 	}
 }
+
+func TestParsePKCS12(t *testing.T) {
+	data, err := base64.StdEncoding.DecodeString(pkcs12ECDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := ParsePKCS12(data, []byte("wrong")); err == nil {
+		t.Error("ParsePKCS12 succeeded with the wrong password")
+	}
+
+	signer, certs, err := ParsePKCS12(data, []byte("password"))
+	if err != nil {
+		t.Fatalf("ParsePKCS12: %v", err)
+	}
+	if len(certs) != 1 || certs[0].Subject.CommonName != "ssh-pkcs12-test" {
+		t.Fatalf("got %d certificates, want the test certificate", len(certs))
+	}
+	pub, err := NewPublicKey(certs[0].PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pub.Marshal(), signer.PublicKey().Marshal()) {
+		t.Error("signer does not match the certificate")
+	}
+
+	data = []byte("message")
+	sig, err := signer.Sign(rand.Reader, data)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if err := pub.Verify(data, sig); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+// pkcs12ECDSA holds a P-256 key and a self-signed certificate, encrypted
+// with the password "password". It was created with:
+//
+//	openssl pkcs12 -export -inkey key.pem -in cert.pem -keypbe PBE-SHA1-3DES \
+//	  -certpbe PBE-SHA1-3DES -macalg sha1 | base64
+const pkcs12ECDSA = `MIIDigIBAzCCA1AGCSqGSIb3DQEHAaCCA0EEggM9MIIDOTCCAi8GCSqGSIb3DQEHBqCCAiAwggIc
+AgEAMIICFQYJKoZIhvcNAQcBMBwGCiqGSIb3DQEMAQMwDgQI6bAqB67YqcgCAggAgIIB6LR91GV0
+L4u1s4lLa170zuIV2K5SYkFFbw6nWIHASGLHlDruLUj3Zuztt0AIwIU0TYagFE/OW+J8B3ZLiyb/
+OKzptp524yscf10rrp5MT60yuudhHPot0LoV+LSdV2hj3CjA7iBgmuCa2ArZyhRuXxwhT/9Hafg4
+DlBbe2xAnurwdbvBE07zWgKbibL9gLlsgSJqWPg4vF4qb9YVYZzHGhbhF0Vt6zaebFVyTd0mKPhF
+5tNO7TIFkxIZKVDtSrSEchHuSkvMbh5/CnvMO3u9zqXBDH5727AFUY7f3s6UUvoI8XX83m45kEBL
+nrgWBB566IHWdLRGVgTywSduh0yrTIsq2s4BvmwN2sHiY1/HnW6NlZI46PSePMy4O85rvTzPIH/4
+cSdYvZogstpqzbofm1VrA8DZFNA9YcnwohxYderkahUJnrzrsHSB9WKiWOSJBFHV+RDRLux7skRZ
+eZg4sNVrzcnf0HunOGygu4mFPspZKvTsOAN/T1B34Lm9D/wY3UQ86P7um0PeGMMmNNO+T2hmrQZw
+HkbxB87jTjJnk8tEWzoRE1aMWbXXFIpSXPXeVpCflRW3aH9QbkcaqjW1V57igJrH/oyJmjMe1n2s
+obq87TxhJIXFskMlpp+0KAc+F+JG7PL2J3SbMIIBAgYJKoZIhvcNAQcBoIH0BIHxMIHuMIHrBgsq
+hkiG9w0BDAoBAqCBtDCBsTAcBgoqhkiG9w0BDAEDMA4ECMhMdC5BK7BMAgIIAASBkEwSe7mt4qA8
+m1AJMTuQttm69I/KHz/oRdXlllGonYB5PgvoEWq7UYJzR0MllmJQ2wgmUzR9DvRmIdWF8/Ul4aWJ
+UQe3FtAkHAyIiIQLev4O0HzfwfuNhZjYsBaEbWqY683RJMNlnYrHhGX5+FNb4HwajScerYWX/e2v
+Fte3YbpU0CsChk8qrezEka5IVi9mSjElMCMGCSqGSIb3DQEJFTEWBBRpkugw0fsLVvaH4KbhyRiv
+50HB4zAxMCEwCQYFKw4DAhoFAAQUtW7L/1zgymNJYpu3pZzANTBzud0ECILcz8ZzVKv1AgIIAA==
+`
+
+// skTestSigner imitates a security key that reports the given flags.
+type skTestSigner struct {
+	pub   PublicKey
+	flags byte
+	sign  func(signed []byte) []byte
+}
+
+func newSKTestSigner(t *testing.T, algo string, flags byte) *skTestSigner {
+	s := &skTestSigner{flags: flags}
+	switch algo {
+	case KeyAlgoSKECDSA256:
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.pub = &skECDSAPublicKey{"ssh:", priv.PublicKey}
+		s.sign = func(signed []byte) []byte {
+			digest := sha256.Sum256(signed)
+			r, rs, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return Marshal(&ecdsaSignature{r, rs})
+		}
+	case KeyAlgoSKED25519:
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.pub = &skEd25519PublicKey{"ssh:", pub}
+		s.sign = func(signed []byte) []byte {
+			return ed25519.Sign(priv, signed)
+		}
+	}
+	return s
+}
+
+func (s *skTestSigner) PublicKey() PublicKey {
+	return s.pub
+}
+
+func (s *skTestSigner) Sign(rand io.Reader, data []byte) (*Signature, error) {
+	sig := &Signature{
+		Format: s.pub.Type(),
+		Rest:   Marshal(&skFields{Flags: s.flags, Counter: 42}),
+	}
+	signed, err := skSignedData("ssh:", data, sig)
+	if err != nil {
+		return nil, err
+	}
+	sig.Blob = s.sign(signed)
+	return sig, nil
+}
+
+func TestSecurityKeys(t *testing.T) {
+	data := []byte("sign me")
+	for _, algo := range []string{KeyAlgoSKECDSA256, KeyAlgoSKED25519} {
+		signer := newSKTestSigner(t, algo, SKFlagUserPresent)
+		pub, err := ParsePublicKey(signer.PublicKey().Marshal())
+		if err != nil {
+			t.Fatalf("%s: ParsePublicKey: %v", algo, err)
+		}
+		if !reflect.DeepEqual(pub, signer.PublicKey()) {
+			t.Errorf("%s: got %#v after round trip, want %#v", algo, pub, signer.PublicKey())
+		}
+
+		sig, err := signer.Sign(rand.Reader, data)
+		if err != nil {
+			t.Fatalf("%s: Sign: %v", algo, err)
+		}
+		// Marshal the signature as it is sent on the wire.
+		parsed, rest, ok := parseSignatureBody(Marshal(sig))
+		if !ok || len(rest) > 0 {
+			t.Fatalf("%s: could not parse signature", algo)
+		}
+		if err := pub.Verify(data, parsed); err != nil {
+			t.Errorf("%s: Verify: %v", algo, err)
+		}
+
+		// The flags are covered by the signature.
+		parsed.Rest = Marshal(&skFields{Flags: SKFlagUserPresent | SKFlagUserVerified, Counter: 42})
+		if err := pub.Verify(data, parsed); err == nil {
+			t.Errorf("%s: Verify succeeded with altered flags", algo)
+		}
+		parsed.Rest = nil
+		if err := pub.Verify(data, parsed); err == nil {
+			t.Errorf("%s: Verify succeeded without flags", algo)
+		}
+	}
+}
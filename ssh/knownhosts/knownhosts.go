@@ -21,6 +21,7 @@ import (
 	"strings"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/internal/hostaddr"
 )
 
 // See the sshd manpage
@@ -317,6 +318,12 @@ func (u *KeyError) Error() string {
 	return "knownhosts: key mismatch"
 }
 
+// Is reports whether target is ssh.ErrHostKeyMismatch and the host
+// was known with different keys.
+func (u *KeyError) Is(target error) bool {
+	return target == ssh.ErrHostKeyMismatch && len(u.Want) > 0
+}
+
 // RevokedError is returned if we found a key that was revoked.
 type RevokedError struct {
 	Revoked KnownKey
@@ -438,18 +445,7 @@ func New(files ...string) (ssh.HostKeyCallback, error) {
 
 // Normalize normalizes an address into the form used in known_hosts
 func Normalize(address string) string {
-	host, port, err := net.SplitHostPort(address)
-	if err != nil {
-		host = address
-		port = "22"
-	}
-	entry := host
-	if port != "22" {
-		entry = "[" + entry + "]:" + port
-	} else if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
-		entry = "[" + entry + "]"
-	}
-	return entry
+	return hostaddr.Normalize(address)
 }
 
 // Line returns a line to add append to the known_hosts files.
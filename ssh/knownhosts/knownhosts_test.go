@@ -6,6 +6,7 @@ package knownhosts
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"net"
 	"reflect"
@@ -116,6 +117,8 @@ func TestBracket(t *testing.T) {
 		t.Fatalf("got type %T, want *KeyError", err)
 	} else if len(ke.Want) > 0 {
 		t.Fatalf("got Want %v, want []", ke.Want)
+	} else if errors.Is(err, ssh.ErrHostKeyMismatch) {
+		t.Errorf("unknown host matches ssh.ErrHostKeyMismatch")
 	}
 }
 
@@ -128,6 +131,8 @@ func TestNewKeyType(t *testing.T) {
 		t.Fatalf("got type %T, want *KeyError", err)
 	} else if len(ke.Want) == 0 {
 		t.Fatalf("got empty KeyError.Want")
+	} else if !errors.Is(err, ssh.ErrHostKeyMismatch) {
+		t.Errorf("key mismatch does not match ssh.ErrHostKeyMismatch")
 	}
 }
 
@@ -226,6 +231,9 @@ func TestLine(t *testing.T) {
 		if got := Line([]string{in}, edKey); got != want {
 			t.Errorf("Line(%q) = %q, want %q", in, got, want)
 		}
+		if got := ssh.KnownHostsLine(in, edKey); got != want {
+			t.Errorf("ssh.KnownHostsLine(%q) = %q, want %q", in, got, want)
+		}
 	}
 }
 
@@ -0,0 +1,88 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// A HandshakeLimiter decides whether a server performs the handshake
+// for a new connection. It is consulted before any data is exchanged,
+// so that rejected connections never reach the expensive key exchange.
+type HandshakeLimiter interface {
+	// Acquire is called with the remote address of a new
+	// connection. To reject the connection, it returns an error,
+	// which NewServerConn returns after closing the connection. It
+	// may also block to delay the handshake. Otherwise, release is
+	// called once the handshake, including user authentication, has
+	// finished, successfully or not.
+	Acquire(remote net.Addr) (release func(), err error)
+}
+
+// NewHandshakeLimiter returns a HandshakeLimiter that allows at most
+// perSource concurrent handshakes from a single IP address, and at
+// most total concurrent handshakes overall. A limit of zero or less
+// is not enforced. Handshakes beyond the limits are rejected.
+func NewHandshakeLimiter(perSource, total int) HandshakeLimiter {
+	return &concurrencyLimiter{
+		perSource: perSource,
+		total:     total,
+		sources:   make(map[string]int),
+	}
+}
+
+type concurrencyLimiter struct {
+	perSource int
+	total     int
+
+	mu      sync.Mutex
+	active  int
+	sources map[string]int
+}
+
+// sourceKey returns the IP address of addr as a string, or the whole
+// address if it has no IP.
+func sourceKey(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+func (l *concurrencyLimiter) Acquire(remote net.Addr) (func(), error) {
+	key := sourceKey(remote)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.total > 0 && l.active >= l.total {
+		return nil, errors.New("ssh: too many concurrent handshakes")
+	}
+	if l.perSource > 0 && l.sources[key] >= l.perSource {
+		return nil, fmt.Errorf("ssh: too many concurrent handshakes from %s", key)
+	}
+	l.active++
+	l.sources[key]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.active--
+			if l.sources[key]--; l.sources[key] == 0 {
+				delete(l.sources, key)
+			}
+		})
+	}, nil
+}
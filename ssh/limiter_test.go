@@ -0,0 +1,96 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"net"
+	"testing"
+)
+
+func TestHandshakeLimiter(t *testing.T) {
+	l := NewHandshakeLimiter(2, 3)
+	a := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1000}
+	a2 := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1001}
+	b := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1000}
+
+	r1, err := l.Acquire(a)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if _, err := l.Acquire(a2); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if _, err := l.Acquire(a); err == nil {
+		t.Error("per source limit was not enforced")
+	}
+	if _, err := l.Acquire(b); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if _, err := l.Acquire(&net.TCPAddr{IP: net.ParseIP("192.0.2.3")}); err == nil {
+		t.Error("total limit was not enforced")
+	}
+
+	r1()
+	r1()
+	if _, err := l.Acquire(a); err != nil {
+		t.Errorf("Acquire after release: %v", err)
+	}
+	if _, err := l.Acquire(b); err == nil {
+		t.Error("releasing twice freed two handshakes")
+	}
+}
+
+func TestServerHandshakeLimiter(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	l := NewHandshakeLimiter(1, 0)
+	serverConf := &ServerConfig{
+		NoClientAuth:     true,
+		HandshakeLimiter: l,
+	}
+	serverConf.AddHostKey(testSigners["rsa"])
+
+	// Hold the only slot for the client's address.
+	release, err := l.Acquire(c1.RemoteAddr())
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	if _, _, _, err := NewServerConn(c1, serverConf); err == nil {
+		t.Fatal("handshake was not rejected")
+	}
+	release()
+
+	c3, c4, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c3.Close()
+	defer c4.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, _, err := NewServerConn(c3, serverConf)
+		done <- err
+	}()
+	clientConf := &ClientConfig{
+		User:            "user",
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	}
+	if _, _, _, err := NewClientConn(c4, "", clientConf); err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("NewServerConn: %v", err)
+	}
+	if _, err := l.Acquire(c3.RemoteAddr()); err != nil {
+		t.Errorf("slot was not released after the handshake: %v", err)
+	}
+}
@@ -36,18 +36,25 @@ const (
 // in this file. The only wrinkle is that a final member of type []byte with a
 // ssh tag of "rest" receives the remainder of a packet when unmarshaling.
 
+// See RFC 4253, section 11.4.
+type unimplementedMsg struct {
+	SeqNum uint32 `sshtype:"3"`
+}
+
 // See RFC 4253, section 11.1.
 const msgDisconnect = 1
 
-// disconnectMsg is the message that signals a disconnect. It is also
-// the error type returned from mux.Wait()
-type disconnectMsg struct {
+// DisconnectError is the message that signals a disconnect. It is also
+// the error returned from Conn.Wait and by the handshake when the peer
+// disconnects. Language is the RFC 3066 language tag of Message, which
+// peers often leave empty.
+type DisconnectError struct {
 	Reason   uint32 `sshtype:"1"`
 	Message  string
 	Language string
 }
 
-func (d *disconnectMsg) Error() string {
+func (d *DisconnectError) Error() string {
 	return fmt.Sprintf("ssh: disconnect, reason %d: %s", d.Reason, d.Message)
 }
 
@@ -101,6 +108,37 @@ type kexDHReplyMsg struct {
 	Signature []byte
 }
 
+// Diffie-Hellman group exchange. See RFC 4419, section 5.
+
+const msgKexDHGexRequest = 34
+
+type kexDHGexRequestMsg struct {
+	MinBits      uint32 `sshtype:"34"`
+	PreferedBits uint32
+	MaxBits      uint32
+}
+
+const msgKexDHGexGroup = 31
+
+type kexDHGexGroupMsg struct {
+	P *big.Int `sshtype:"31"`
+	G *big.Int
+}
+
+const msgKexDHGexInit = 32
+
+type kexDHGexInitMsg struct {
+	X *big.Int `sshtype:"32"`
+}
+
+const msgKexDHGexReply = 33
+
+type kexDHGexReplyMsg struct {
+	HostKey   []byte `sshtype:"33"`
+	Y         *big.Int
+	Signature []byte
+}
+
 // See RFC 4253, section 10.
 const msgServiceRequest = 5
 
@@ -125,6 +163,12 @@ type userAuthRequestMsg struct {
 	Payload []byte `ssh:"rest"`
 }
 
+// See RFC 4252, section 5.4
+type userAuthBannerMsg struct {
+	Message  string `sshtype:"53"`
+	Language string
+}
+
 // Used for debug printouts of packets.
 type userAuthSuccessMsg struct {
 }
@@ -137,6 +181,14 @@ type userAuthFailureMsg struct {
 	PartialSuccess bool
 }
 
+// See RFC 4252, section 8
+const msgUserAuthPasswdChangeReq = 60
+
+type userAuthPasswdChangeReqMsg struct {
+	Prompt   string `sshtype:"60"`
+	Language string
+}
+
 // See RFC 4256, section 3.2
 const msgUserAuthInfoRequest = 60
 const msgUserAuthInfoResponse = 61
@@ -703,7 +755,7 @@ func decode(packet []byte) (interface{}, error) {
 	var msg interface{}
 	switch packet[0] {
 	case msgDisconnect:
-		msg = new(disconnectMsg)
+		msg = new(DisconnectError)
 	case msgServiceRequest:
 		msg = new(serviceRequestMsg)
 	case msgServiceAccept:
@@ -718,6 +770,8 @@ func decode(packet []byte) (interface{}, error) {
 		msg = new(userAuthRequestMsg)
 	case msgUserAuthSuccess:
 		return new(userAuthSuccessMsg), nil
+	case msgUserAuthBanner:
+		msg = new(userAuthBannerMsg)
 	case msgUserAuthFailure:
 		msg = new(userAuthFailureMsg)
 	case msgUserAuthPubKeyOk:
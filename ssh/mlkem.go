@@ -0,0 +1,188 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.24
+// +build go1.24
+
+package ssh
+
+import (
+	"crypto"
+	"crypto/mlkem"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+const kexAlgoMLKEM768X25519SHA256 = "mlkem768x25519-sha256"
+
+func init() {
+	// Offer the exchange right after curve25519, so that peers which
+	// understand it can pick it without penalizing those which don't.
+	algos := make([]string, 0, len(supportedKexAlgos)+1)
+	algos = append(algos, supportedKexAlgos[0], kexAlgoMLKEM768X25519SHA256)
+	supportedKexAlgos = append(algos, supportedKexAlgos[1:]...)
+
+	kexAlgoMap[kexAlgoMLKEM768X25519SHA256] = &mlkem768x25519{}
+}
+
+// mlkem768x25519 implements the mlkem768x25519-sha256 hybrid key
+// agreement, which combines ML-KEM-768 (FIPS 203) with X25519 as
+// specified in draft-ietf-sshm-mlkem-hybrid-kex. The messages are laid
+// out like those of sntrup761x25519, with the KEM value first.
+//
+// Package crypto/mlkem always draws from crypto/rand, so the ML-KEM half
+// of the exchange ignores Config.Rand. The fields replace that
+// randomness in tests; nil means crypto/mlkem's own.
+type mlkem768x25519 struct {
+	generateKey func() (*mlkem.DecapsulationKey768, error)
+	encapsulate func(*mlkem.EncapsulationKey768) (sharedKey, ciphertext []byte)
+}
+
+// mlkem768x25519SharedSecret combines the KEM and X25519 secrets and
+// encodes the result as a string.
+func mlkem768x25519SharedSecret(kemKey, ecdhKey []byte) []byte {
+	h := sha256.New()
+	h.Write(kemKey)
+	h.Write(ecdhKey)
+	K := make([]byte, stringLength(sha256.Size))
+	marshalString(K, h.Sum(nil))
+	return K
+}
+
+func (kex *mlkem768x25519) Client(c packetConn, rand io.Reader, magics *handshakeMagics) (*kexResult, error) {
+	generateKey := mlkem.GenerateKey768
+	if kex.generateKey != nil {
+		generateKey = kex.generateKey
+	}
+	dk, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+	var kp curve25519KeyPair
+	if err := kp.generate(rand); err != nil {
+		return nil, err
+	}
+
+	clientPub := append(dk.EncapsulationKey().Bytes(), kp.pub[:]...)
+	if err := c.writePacket(Marshal(&kexECDHInitMsg{clientPub})); err != nil {
+		return nil, err
+	}
+
+	packet, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+
+	var reply kexECDHReplyMsg
+	if err = Unmarshal(packet, &reply); err != nil {
+		return nil, err
+	}
+	if len(reply.EphemeralPubKey) != mlkem.CiphertextSize768+32 {
+		return nil, errors.New("ssh: peer's mlkem768x25519 public value has wrong length")
+	}
+
+	kemKey, err := dk.Decapsulate(reply.EphemeralPubKey[:mlkem.CiphertextSize768])
+	if err != nil {
+		return nil, err
+	}
+
+	var servPub, secret [32]byte
+	copy(servPub[:], reply.EphemeralPubKey[mlkem.CiphertextSize768:])
+	curve25519.ScalarMult(&secret, &kp.priv, &servPub)
+	if subtle.ConstantTimeCompare(secret[:], curve25519Zeros[:]) == 1 {
+		return nil, errors.New("ssh: peer's curve25519 public value has wrong order")
+	}
+
+	K := mlkem768x25519SharedSecret(kemKey, secret[:])
+
+	h := crypto.SHA256.New()
+	magics.write(h)
+	writeString(h, reply.HostKey)
+	writeString(h, clientPub)
+	writeString(h, reply.EphemeralPubKey)
+	h.Write(K)
+
+	return &kexResult{
+		H:         h.Sum(nil),
+		K:         K,
+		HostKey:   reply.HostKey,
+		Signature: reply.Signature,
+		Hash:      crypto.SHA256,
+	}, nil
+}
+
+func (kex *mlkem768x25519) Server(c packetConn, rand io.Reader, magics *handshakeMagics, priv Signer) (*kexResult, error) {
+	packet, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	var kexInit kexECDHInitMsg
+	if err = Unmarshal(packet, &kexInit); err != nil {
+		return nil, err
+	}
+	if len(kexInit.ClientPubKey) != mlkem.EncapsulationKeySize768+32 {
+		return nil, errors.New("ssh: peer's mlkem768x25519 public value has wrong length")
+	}
+
+	ek, err := mlkem.NewEncapsulationKey768(kexInit.ClientPubKey[:mlkem.EncapsulationKeySize768])
+	if err != nil {
+		return nil, err
+	}
+	var kemKey, ciphertext []byte
+	if kex.encapsulate != nil {
+		kemKey, ciphertext = kex.encapsulate(ek)
+	} else {
+		kemKey, ciphertext = ek.Encapsulate()
+	}
+
+	var kp curve25519KeyPair
+	if err := kp.generate(rand); err != nil {
+		return nil, err
+	}
+
+	var clientPub, secret [32]byte
+	copy(clientPub[:], kexInit.ClientPubKey[mlkem.EncapsulationKeySize768:])
+	curve25519.ScalarMult(&secret, &kp.priv, &clientPub)
+	if subtle.ConstantTimeCompare(secret[:], curve25519Zeros[:]) == 1 {
+		return nil, errors.New("ssh: peer's curve25519 public value has wrong order")
+	}
+
+	K := mlkem768x25519SharedSecret(kemKey, secret[:])
+	serverPub := append(ciphertext, kp.pub[:]...)
+	hostKeyBytes := priv.PublicKey().Marshal()
+
+	h := crypto.SHA256.New()
+	magics.write(h)
+	writeString(h, hostKeyBytes)
+	writeString(h, kexInit.ClientPubKey)
+	writeString(h, serverPub)
+	h.Write(K)
+
+	H := h.Sum(nil)
+
+	sig, err := signAndMarshal(priv, rand, H)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := kexECDHReplyMsg{
+		EphemeralPubKey: serverPub,
+		HostKey:         hostKeyBytes,
+		Signature:       sig,
+	}
+	if err := c.writePacket(Marshal(&reply)); err != nil {
+		return nil, err
+	}
+	return &kexResult{
+		H:         H,
+		K:         K,
+		HostKey:   hostKeyBytes,
+		Signature: sig,
+		Hash:      crypto.SHA256,
+	}, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bufio"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Values of the type and tests fields in the OpenSSH moduli file. See
+// moduli(5).
+const (
+	moduliTypeSafe       = 2
+	moduliTestsComposite = 0x01
+)
+
+// GexModulus is a Diffie-Hellman group that a server can offer during
+// diffie-hellman-group-exchange key exchange.
+type GexModulus struct {
+	// Size is the length of Prime in bits.
+	Size int
+
+	// Generator is the group generator.
+	Generator *big.Int
+
+	// Prime is the safe prime modulus of the group.
+	Prime *big.Int
+}
+
+// ParseModuli reads Diffie-Hellman groups in the format of the
+// OpenSSH moduli file, usually found at /etc/ssh/moduli. Comment and
+// blank lines are skipped. Like OpenSSH, entries that are not safe
+// primes, or that were not tested for primality, are ignored. A
+// malformed line results in an error.
+func ParseModuli(r io.Reader) ([]GexModulus, error) {
+	var moduli []GexModulus
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		m, ok, err := parseModulusLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("ssh: moduli line %d: %v", lineNum, err)
+		}
+		if ok {
+			moduli = append(moduli, m)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return moduli, nil
+}
+
+// parseModulusLine parses a single moduli entry of the form
+//
+//	time type tests tries size generator modulus
+//
+// It returns ok == false for well-formed entries that must not be
+// used.
+func parseModulusLine(line string) (m GexModulus, ok bool, err error) {
+	fields := strings.Fields(line)
+	if len(fields) != 7 {
+		return m, false, fmt.Errorf("got %d fields, want 7", len(fields))
+	}
+
+	var nums [4]int
+	for i, f := range fields[1:5] {
+		if nums[i], err = strconv.Atoi(f); err != nil || nums[i] < 0 {
+			return m, false, fmt.Errorf("invalid number %q", f)
+		}
+	}
+	typ, tests, tries, size := nums[0], nums[1], nums[2], nums[3]
+
+	g, gOK := new(big.Int).SetString(fields[5], 16)
+	p, pOK := new(big.Int).SetString(fields[6], 16)
+	if !gOK || !pOK {
+		return m, false, errors.New("invalid generator or modulus")
+	}
+
+	// The size field holds the number of bits minus one.
+	if p.BitLen() != size+1 {
+		return m, false, fmt.Errorf("modulus has %d bits, entry claims %d", p.BitLen(), size+1)
+	}
+	if g.Cmp(bigOne) <= 0 || g.Cmp(p) >= 0 {
+		return m, false, errors.New("generator out of range")
+	}
+
+	if typ != moduliTypeSafe || tests&moduliTestsComposite != 0 || tests&^moduliTestsComposite == 0 || tries == 0 {
+		return m, false, nil
+	}
+
+	return GexModulus{Size: p.BitLen(), Generator: g, Prime: p}, true, nil
+}
+
+// chooseGexModulus picks a group between min and max bits, as close
+// as possible to preferred, in the way OpenSSH does. If several groups
+// have the best size, one of them is chosen at random.
+func chooseGexModulus(moduli []GexModulus, min, preferred, max uint32, randSource io.Reader) (*GexModulus, error) {
+	best := -1
+	for _, m := range moduli {
+		size := m.Size
+		if size < int(min) || size > int(max) {
+			continue
+		}
+		if best < 0 || (size > int(preferred) && size < best) || (size > best && best < int(preferred)) {
+			best = size
+		}
+	}
+	if best < 0 {
+		return nil, fmt.Errorf("ssh: no moduli available between %d and %d bits", min, max)
+	}
+
+	var candidates []*GexModulus
+	for i := range moduli {
+		if moduli[i].Size == best {
+			candidates = append(candidates, &moduli[i])
+		}
+	}
+
+	n, err := rand.Int(randSource, big.NewInt(int64(len(candidates))))
+	if err != nil {
+		return nil, err
+	}
+	return candidates[n.Int64()], nil
+}
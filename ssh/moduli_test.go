@@ -0,0 +1,219 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+// oakleyGroup14 is the 2048-bit safe prime of RFC 3526, which also
+// backs diffie-hellman-group14-sha1.
+const oakleyGroup14 = "FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7EDEE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3BE39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF6955817183995497CEA956AE515D2261898FA051015728E5A8AACAA68FFFFFFFFFFFFFFFF"
+
+var testModuliFile = `# Time Type Tests Tries Size Generator Modulus
+
+20170101000000 2 6 100 2047 2 ` + oakleyGroup14 + `
+20170101000000 0 6 100 2047 2 ` + oakleyGroup14 + `
+20170101000000 2 1 100 2047 2 ` + oakleyGroup14 + `
+20170101000000 2 0 100 2047 2 ` + oakleyGroup14 + `
+`
+
+func testModuli(t *testing.T) []GexModulus {
+	moduli, err := ParseModuli(strings.NewReader(testModuliFile))
+	if err != nil {
+		t.Fatalf("ParseModuli: %v", err)
+	}
+	return moduli
+}
+
+func TestParseModuli(t *testing.T) {
+	moduli := testModuli(t)
+	if len(moduli) != 1 {
+		t.Fatalf("got %d moduli, want 1", len(moduli))
+	}
+	m := moduli[0]
+	if m.Size != 2048 || m.Generator.Int64() != 2 || m.Prime.Text(16) != strings.ToLower(oakleyGroup14) {
+		t.Errorf("got modulus %d bits, generator %v", m.Size, m.Generator)
+	}
+}
+
+func TestParseModuliErrors(t *testing.T) {
+	for _, line := range []string{
+		"20170101000000 2 6 100 2047 2",
+		"20170101000000 2 six 100 2047 2 " + oakleyGroup14,
+		"20170101000000 2 6 100 2047 2 nothex",
+		"20170101000000 2 6 100 4095 2 " + oakleyGroup14,
+		"20170101000000 2 6 100 2047 1 " + oakleyGroup14,
+	} {
+		if _, err := ParseModuli(strings.NewReader(line)); err == nil {
+			t.Errorf("ParseModuli(%.40q...) succeeded", line)
+		}
+	}
+}
+
+func TestChooseGexModulus(t *testing.T) {
+	moduli := []GexModulus{{Size: 2048}, {Size: 3072}, {Size: 4096}}
+	for _, tt := range []struct {
+		min, preferred, max uint32
+		want                int
+	}{
+		{2048, 2048, 8192, 2048},
+		{2048, 3072, 8192, 3072},
+		{2048, 3500, 8192, 4096},
+		{2048, 7000, 8192, 4096},
+		{3000, 3000, 3500, 3072},
+		{5000, 6000, 8192, 0},
+	} {
+		m, err := chooseGexModulus(moduli, tt.min, tt.preferred, tt.max, rand.Reader)
+		if tt.want == 0 {
+			if err == nil {
+				t.Errorf("%d/%d/%d: got %d bits, want error", tt.min, tt.preferred, tt.max, m.Size)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d/%d/%d: %v", tt.min, tt.preferred, tt.max, err)
+		} else if m.Size != tt.want {
+			t.Errorf("%d/%d/%d: got %d bits, want %d", tt.min, tt.preferred, tt.max, m.Size, tt.want)
+		}
+	}
+}
+
+func TestGexHandshake(t *testing.T) {
+	for _, moduli := range [][]GexModulus{testModuli(t), nil} {
+		c1, c2, err := netPipe()
+		if err != nil {
+			t.Fatalf("netPipe: %v", err)
+		}
+		defer c1.Close()
+		defer c2.Close()
+
+		serverConf := &ServerConfig{
+			NoClientAuth: true,
+			GexModuli:    moduli,
+		}
+		serverConf.KeyExchanges = []string{kexAlgoDHGEXSHA256, kexAlgoCurve25519SHA256}
+		serverConf.AddHostKey(testSigners["rsa"])
+		go NewServerConn(c1, serverConf)
+
+		clientConf := &ClientConfig{
+			User:            "user",
+			HostKeyCallback: InsecureIgnoreHostKey(),
+		}
+		clientConf.KeyExchanges = []string{kexAlgoDHGEXSHA256}
+		conn, _, _, err := NewClientConn(c2, "", clientConf)
+		if moduli == nil {
+			if err == nil {
+				conn.Close()
+				t.Error("group exchange negotiated without server moduli")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("NewClientConn: %v", err)
+		}
+		conn.Close()
+	}
+}
+
+func TestGexMaxBits(t *testing.T) {
+	for _, tt := range []struct {
+		maxBits int
+		ok      bool
+	}{
+		{0, true},
+		{2048, true},
+		{1024, false},
+	} {
+		c1, c2, err := netPipe()
+		if err != nil {
+			t.Fatalf("netPipe: %v", err)
+		}
+		defer c1.Close()
+		defer c2.Close()
+
+		serverConf := &ServerConfig{
+			NoClientAuth: true,
+			GexModuli:    testModuli(t),
+			MaxGexBits:   tt.maxBits,
+		}
+		serverConf.KeyExchanges = []string{kexAlgoDHGEXSHA256}
+		serverConf.AddHostKey(testSigners["rsa"])
+		go NewServerConn(c1, serverConf)
+
+		clientConf := &ClientConfig{
+			User:            "user",
+			HostKeyCallback: InsecureIgnoreHostKey(),
+		}
+		clientConf.KeyExchanges = []string{kexAlgoDHGEXSHA256}
+		conn, _, _, err := NewClientConn(c2, "", clientConf)
+		if tt.ok != (err == nil) {
+			t.Errorf("MaxGexBits %d: got error %v, want success %v", tt.maxBits, err, tt.ok)
+		}
+		if err == nil {
+			conn.Close()
+		}
+	}
+}
+
+func TestGexServerRejectsLargeRequest(t *testing.T) {
+	gex := kexAlgoMap[kexAlgoDHGEXSHA256].(*dhGEXSHA).withModuli(testModuli(t), 2048)
+	a, b := memPipe()
+	defer a.Close()
+	defer b.Close()
+
+	req := &kexDHGexRequestMsg{MinBits: 4096, PreferedBits: 8192, MaxBits: 8192}
+	if err := a.writePacket(Marshal(req)); err != nil {
+		t.Fatalf("writePacket: %v", err)
+	}
+	var magics handshakeMagics
+	if _, err := gex.Server(b, rand.Reader, &magics, testSigners["rsa"]); err == nil {
+		t.Error("server accepted a group exchange request above MaxGexBits")
+	}
+}
+
+func TestGexMaxBitsFixedGroups(t *testing.T) {
+	for _, tt := range []struct {
+		kex     string
+		maxBits int
+		ok      bool
+	}{
+		{kexAlgoDH18SHA512, 0, true},
+		{kexAlgoDH18SHA512, 4096, false},
+		{kexAlgoDH16SHA512, 4096, true},
+		{kexAlgoDH16SHA512, 2048, false},
+		{kexAlgoDH14SHA1, 2048, true},
+	} {
+		c1, c2, err := netPipe()
+		if err != nil {
+			t.Fatalf("netPipe: %v", err)
+		}
+		defer c1.Close()
+		defer c2.Close()
+
+		serverConf := &ServerConfig{
+			NoClientAuth: true,
+			MaxGexBits:   tt.maxBits,
+		}
+		serverConf.KeyExchanges = []string{kexAlgoDH14SHA1, kexAlgoDH16SHA512, kexAlgoDH18SHA512}
+		serverConf.AddHostKey(testSigners["rsa"])
+		go NewServerConn(c1, serverConf)
+
+		clientConf := &ClientConfig{
+			User:            "user",
+			HostKeyCallback: InsecureIgnoreHostKey(),
+		}
+		clientConf.KeyExchanges = []string{tt.kex}
+		conn, _, _, err := NewClientConn(c2, "", clientConf)
+		if tt.ok != (err == nil) {
+			t.Errorf("%s with MaxGexBits %d: got error %v, want success %v", tt.kex, tt.maxBits, err, tt.ok)
+		}
+		if err == nil {
+			conn.Close()
+		}
+	}
+}
@@ -5,7 +5,9 @@
 package ssh
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -30,6 +32,9 @@ type chanList struct {
 	// amount. This helps distinguish otherwise identical
 	// server/client muxes
 	offset uint32
+
+	// emptyWaiters are closed once chans holds no channels.
+	emptyWaiters []chan struct{}
 }
 
 // Assigns a channel ID to the given channel.
@@ -58,15 +63,64 @@ func (c *chanList) getChan(id uint32) *channel {
 	return nil
 }
 
+// getChanByRemoteID returns the channel that the peer knows by id.
+func (c *chanList) getChanByRemoteID(id uint32) *channel {
+	c.Lock()
+	defer c.Unlock()
+	for _, ch := range c.chans {
+		if ch != nil && ch.remoteId == id {
+			return ch
+		}
+	}
+	return nil
+}
+
 func (c *chanList) remove(id uint32) {
 	id -= c.offset
 	c.Lock()
 	if id < uint32(len(c.chans)) {
 		c.chans[id] = nil
 	}
+	if c.isEmpty() {
+		c.wakeEmptyWaiters()
+	}
 	c.Unlock()
 }
 
+// isEmpty reports whether there are no channels. The caller must
+// hold the lock.
+func (c *chanList) isEmpty() bool {
+	for _, ch := range c.chans {
+		if ch != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// wakeEmptyWaiters notifies everyone waiting in whenEmpty. The caller
+// must hold the lock.
+func (c *chanList) wakeEmptyWaiters() {
+	for _, w := range c.emptyWaiters {
+		close(w)
+	}
+	c.emptyWaiters = nil
+}
+
+// whenEmpty returns a Go channel that is closed once all channels
+// have been removed.
+func (c *chanList) whenEmpty() <-chan struct{} {
+	c.Lock()
+	defer c.Unlock()
+	w := make(chan struct{})
+	if c.isEmpty() {
+		close(w)
+	} else {
+		c.emptyWaiters = append(c.emptyWaiters, w)
+	}
+	return w
+}
+
 // dropAll forgets all channels it knows, returning them in a slice.
 func (c *chanList) dropAll() []*channel {
 	c.Lock()
@@ -80,6 +134,7 @@ func (c *chanList) dropAll() []*channel {
 		r = append(r, ch)
 	}
 	c.chans = nil
+	c.wakeEmptyWaiters()
 	return r
 }
 
@@ -91,12 +146,29 @@ type mux struct {
 
 	incomingChannels chan NewChannel
 
-	globalSentMu     sync.Mutex
-	globalResponses  chan interface{}
+	// globalSentMu orders the sending of global requests that want a
+	// reply with the queueing of their waiters.
+	globalSentMu sync.Mutex
+
+	// globalMu protects globalWaiters, which holds a channel for each
+	// reply that is due, in the order the requests were sent, and
+	// globalClosed, which is set once the connection has shut down.
+	globalMu      sync.Mutex
+	globalWaiters []chan interface{}
+	globalClosed  bool
+
 	incomingRequests chan *Request
 
+	// handleBanner, if non-nil, receives the userauth banners that a
+	// server sends after authentication.
+	handleBanner func(packet []byte) error
+
 	errCond *sync.Cond
 	err     error
+
+	// shutdown is set to 1 once Shutdown has been called; from
+	// then on no new channels are opened or accepted.
+	shutdown int32
 }
 
 // When debugging, each new chanList instantiation has a different
@@ -114,11 +186,17 @@ func (m *mux) Wait() error {
 
 // newMux returns a mux that runs over the given connection.
 func newMux(p packetConn) *mux {
+	return newClientMux(p, nil)
+}
+
+// newClientMux is like newMux, but passes the userauth banners that
+// arrive after authentication to handleBanner.
+func newClientMux(p packetConn, handleBanner func(packet []byte) error) *mux {
 	m := &mux{
 		conn:             p,
 		incomingChannels: make(chan NewChannel, chanSize),
-		globalResponses:  make(chan interface{}, 1),
 		incomingRequests: make(chan *Request, chanSize),
+		handleBanner:     handleBanner,
 		errCond:          newCond(),
 	}
 	if debugMux {
@@ -138,24 +216,45 @@ func (m *mux) sendMessage(msg interface{}) error {
 }
 
 func (m *mux) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
-	if wantReply {
-		m.globalSentMu.Lock()
-		defer m.globalSentMu.Unlock()
-	}
+	return m.SendRequestContext(context.Background(), name, wantReply, payload)
+}
 
-	if err := m.sendMessage(globalRequestMsg{
+func (m *mux) SendRequestContext(ctx context.Context, name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	req := globalRequestMsg{
 		Type:      name,
 		WantReply: wantReply,
 		Data:      payload,
-	}); err != nil {
-		return false, nil, err
 	}
-
 	if !wantReply {
-		return false, nil, nil
+		return false, nil, m.sendMessage(req)
+	}
+
+	// Replies arrive in the order of the requests, so each one goes to
+	// the oldest waiter. An abandoned waiter still receives its reply,
+	// which keeps the later ones matched up.
+	reply := make(chan interface{}, 1)
+	m.globalSentMu.Lock()
+	m.globalMu.Lock()
+	if m.globalClosed {
+		m.globalMu.Unlock()
+		m.globalSentMu.Unlock()
+		return false, nil, io.EOF
+	}
+	m.globalWaiters = append(m.globalWaiters, reply)
+	m.globalMu.Unlock()
+	err := m.sendMessage(req)
+	m.globalSentMu.Unlock()
+	if err != nil {
+		return false, nil, err
 	}
 
-	msg, ok := <-m.globalResponses
+	var msg interface{}
+	var ok bool
+	select {
+	case msg, ok = <-reply:
+	case <-ctx.Done():
+		return false, nil, ctx.Err()
+	}
 	if !ok {
 		return false, nil, io.EOF
 	}
@@ -164,6 +263,8 @@ func (m *mux) SendRequest(name string, wantReply bool, payload []byte) (bool, []
 		return false, msg.Data, nil
 	case *globalRequestSuccessMsg:
 		return true, msg.Data, nil
+	case *unimplementedMsg:
+		return false, nil, ErrUnimplemented
 	default:
 		return false, nil, fmt.Errorf("ssh: unexpected response to request: %#v", msg)
 	}
@@ -182,6 +283,38 @@ func (m *mux) Close() error {
 	return m.conn.Close()
 }
 
+// noMoreSessionsRequest is the OpenSSH global request that asks the
+// peer not to open any further session channels.
+const noMoreSessionsRequest = "no-more-sessions@openssh.com"
+
+// errShuttingDown is returned when opening a channel on a connection
+// that is being shut down.
+var errShuttingDown = errors.New("ssh: connection is shutting down")
+
+func (m *mux) isShuttingDown() bool {
+	return atomic.LoadInt32(&m.shutdown) != 0
+}
+
+// Shutdown refuses new channels, tells the peer not to open further
+// sessions, and waits for the open channels to be closed before
+// closing the connection. If ctx expires first, the connection is
+// closed anyway and the context's error is returned.
+func (m *mux) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&m.shutdown, 1)
+
+	// The peer may have gone away already, in which case there is
+	// nothing left to drain and the error resurfaces from Close.
+	m.sendMessage(globalRequestMsg{Type: noMoreSessionsRequest})
+
+	select {
+	case <-m.chanList.whenEmpty():
+		return m.Close()
+	case <-ctx.Done():
+		m.Close()
+		return ctx.Err()
+	}
+}
+
 // loop runs the connection machine. It will process packets until an
 // error is encountered. To synchronize on loop exit, use mux.Wait.
 func (m *mux) loop() {
@@ -196,7 +329,14 @@ func (m *mux) loop() {
 
 	close(m.incomingChannels)
 	close(m.incomingRequests)
-	close(m.globalResponses)
+
+	m.globalMu.Lock()
+	m.globalClosed = true
+	for _, reply := range m.globalWaiters {
+		close(reply)
+	}
+	m.globalWaiters = nil
+	m.globalMu.Unlock()
 
 	m.conn.Close()
 
@@ -231,6 +371,16 @@ func (m *mux) onePacket() error {
 		return m.handleChannelOpen(packet)
 	case msgGlobalRequest, msgRequestSuccess, msgRequestFailure:
 		return m.handleGlobalPacket(packet)
+	case msgUnimplemented:
+		return m.handleUnimplemented(packet)
+	case msgUserAuthBanner:
+		// RFC 4252 allows banners only until authentication
+		// succeeds, but some servers send them late. Authentication
+		// is over, so an error from the callback cannot abort it.
+		if m.handleBanner != nil {
+			m.handleBanner(packet)
+		}
+		return nil
 	}
 
 	// assume a channel packet.
@@ -261,7 +411,14 @@ func (m *mux) handleGlobalPacket(packet []byte) error {
 			mux:       m,
 		}
 	case *globalRequestSuccessMsg, *globalRequestFailureMsg:
-		m.globalResponses <- msg
+		m.globalMu.Lock()
+		defer m.globalMu.Unlock()
+		if len(m.globalWaiters) == 0 {
+			// Nobody asked; ignore it as OpenSSH does.
+			return nil
+		}
+		m.globalWaiters[0] <- msg
+		m.globalWaiters = m.globalWaiters[1:]
 	default:
 		panic(fmt.Sprintf("not a global message %#v", msg))
 	}
@@ -269,6 +426,66 @@ func (m *mux) handleGlobalPacket(packet []byte) error {
 	return nil
 }
 
+// requestRecorder is implemented by transports that remember the
+// requests they sent.
+type requestRecorder interface {
+	sentRequest(seqNum uint32) []byte
+}
+
+// handleUnimplemented fails the request that the peer answered with
+// SSH_MSG_UNIMPLEMENTED with ErrUnimplemented, if it can be found by
+// its sequence number and is waiting for a reply. Other such messages
+// are ignored, rather than ending the connection.
+func (m *mux) handleUnimplemented(packet []byte) error {
+	var msg unimplementedMsg
+	if err := Unmarshal(packet, &msg); err != nil {
+		return err
+	}
+	r, ok := m.conn.(requestRecorder)
+	if !ok {
+		return nil
+	}
+	sent := r.sentRequest(msg.SeqNum)
+	if sent == nil {
+		return nil
+	}
+
+	switch sent[0] {
+	case msgGlobalRequest:
+		var req globalRequestMsg
+		if Unmarshal(sent, &req) != nil || !req.WantReply {
+			return nil
+		}
+		m.globalMu.Lock()
+		defer m.globalMu.Unlock()
+		if len(m.globalWaiters) > 0 {
+			m.globalWaiters[0] <- &msg
+			m.globalWaiters = m.globalWaiters[1:]
+		}
+	case msgChannelOpen:
+		var open channelOpenMsg
+		if Unmarshal(sent, &open) != nil {
+			return nil
+		}
+		ch := m.chanList.getChan(open.PeersId)
+		if ch == nil || ch.responseMessageReceived() != nil {
+			return nil
+		}
+		m.chanList.remove(open.PeersId)
+		ch.msg <- &msg
+	case msgChannelRequest:
+		var req channelRequestMsg
+		if Unmarshal(sent, &req) != nil || !req.WantReply {
+			return nil
+		}
+		ch := m.chanList.getChanByRemoteID(req.PeersId)
+		if ch != nil && atomic.CompareAndSwapInt32(&ch.awaitingReply, 1, 0) {
+			ch.msg <- &msg
+		}
+	}
+	return nil
+}
+
 // handleChannelOpen schedules a channel to be Accept()ed.
 func (m *mux) handleChannelOpen(packet []byte) error {
 	var msg channelOpenMsg
@@ -286,6 +503,16 @@ func (m *mux) handleChannelOpen(packet []byte) error {
 		return m.sendMessage(failMsg)
 	}
 
+	if m.isShuttingDown() {
+		failMsg := channelOpenFailureMsg{
+			PeersId:  msg.PeersId,
+			Reason:   Prohibited,
+			Message:  "connection is shutting down",
+			Language: "en_US.UTF-8",
+		}
+		return m.sendMessage(failMsg)
+	}
+
 	c := m.newChannel(msg.ChanType, channelInbound, msg.TypeSpecificData)
 	c.remoteId = msg.PeersId
 	c.maxRemotePayload = msg.MaxPacketSize
@@ -304,6 +531,9 @@ func (m *mux) OpenChannel(chanType string, extra []byte) (Channel, <-chan *Reque
 }
 
 func (m *mux) openChannel(chanType string, extra []byte) (*channel, error) {
+	if m.isShuttingDown() {
+		return nil, errShuttingDown
+	}
 	ch := m.newChannel(chanType, channelOutbound, extra)
 
 	ch.maxIncomingPayload = channelMaxPacket
@@ -324,6 +554,8 @@ func (m *mux) openChannel(chanType string, extra []byte) (*channel, error) {
 		return ch, nil
 	case *channelOpenFailureMsg:
 		return nil, &OpenChannelError{msg.Reason, msg.Message}
+	case *unimplementedMsg:
+		return nil, ErrUnimplemented
 	default:
 		return nil, fmt.Errorf("ssh: unexpected packet in response to channel open: %T", msg)
 	}
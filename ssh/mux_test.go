@@ -5,10 +5,14 @@
 package ssh
 
 import (
+	"context"
+	"crypto/rand"
 	"io"
 	"io/ioutil"
+	"net"
 	"sync"
 	"testing"
+	"time"
 )
 
 func muxPair() (*mux, *mux) {
@@ -167,6 +171,76 @@ func TestMuxChannelOverflow(t *testing.T) {
 	<-wDone
 }
 
+func TestMuxChannelManualWindow(t *testing.T) {
+	reader, writer, mux := channelPair(t)
+	defer reader.Close()
+	defer writer.Close()
+	defer mux.Close()
+
+	var ch Channel = reader
+	if _, ok := ch.(WindowController); !ok {
+		t.Fatalf("%T does not implement WindowController", ch)
+	}
+	reader.SetAutoWindowAdjust(false)
+
+	wDone := make(chan error, 1)
+	go func() {
+		_, err := writer.Write(make([]byte, channelWindowSize+10))
+		wDone <- err
+	}()
+
+	if _, err := io.ReadFull(reader, make([]byte, channelWindowSize)); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	// Reading did not grant the writer any more window.
+	writer.remoteWin.waitWriterBlocked()
+	select {
+	case <-wDone:
+		t.Fatal("write completed without window extension")
+	default:
+	}
+
+	if err := reader.ExtendWindow(10); err != nil {
+		t.Fatalf("ExtendWindow: %v", err)
+	}
+	if _, err := io.ReadFull(reader, make([]byte, 10)); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if err := <-wDone; err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+}
+
+func TestMuxChannelExtendWindowOverflow(t *testing.T) {
+	reader, writer, mux := channelPair(t)
+	defer reader.Close()
+	defer writer.Close()
+	defer mux.Close()
+
+	reader.SetAutoWindowAdjust(false)
+
+	// Only three extensions of 1 GiB fit on top of the initial window.
+	const n = 8
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			errs <- reader.ExtendWindow(1 << 30)
+		}()
+	}
+	var ok int
+	for i := 0; i < n; i++ {
+		if err := <-errs; err == nil {
+			ok++
+		}
+	}
+	if ok != 3 {
+		t.Errorf("%d window extensions succeeded, want 3", ok)
+	}
+	if want := uint32(channelWindowSize + 3<<30); reader.myWindow != want {
+		t.Errorf("got window %d, want %d", reader.myWindow, want)
+	}
+}
+
 func TestMuxChannelCloseWriteUnblock(t *testing.T) {
 	reader, writer, mux := channelPair(t)
 	defer reader.Close()
@@ -292,6 +366,141 @@ func TestMuxChannelRequest(t *testing.T) {
 	}
 }
 
+func TestMuxSendRequestContext(t *testing.T) {
+	server, client := muxPair()
+	defer server.Close()
+	defer client.Close()
+
+	// The peer holds on to the first request until the second one has
+	// arrived, and then answers both.
+	held := make(chan *Request, 1)
+	go func() {
+		for r := range server.incomingRequests {
+			if r.Type == "slow" {
+				held <- r
+				continue
+			}
+			(<-held).Reply(false, []byte("late"))
+			r.Reply(true, []byte("fast"))
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := client.SendRequestContext(ctx, "slow", true, nil); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	ok, payload, err := client.SendRequestContext(context.Background(), "fast", true, nil)
+	if err != nil {
+		t.Fatalf("SendRequestContext: %v", err)
+	}
+	if !ok || string(payload) != "fast" {
+		t.Errorf("got reply %v, %q; want the reply to the second request", ok, payload)
+	}
+}
+
+func TestMuxUnimplemented(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	client := newMux(newTransport(c1, rand.Reader, true))
+	server := newTransport(c2, rand.Reader, false)
+
+	// The peer knows only the "known" global request and "known"
+	// channels, and answers everything else as unimplemented.
+	go func() {
+		for {
+			p, err := server.readPacket()
+			if err != nil {
+				return
+			}
+			seqNum := server.reader.seqNum - 1
+			reply := Marshal(&unimplementedMsg{SeqNum: seqNum})
+			switch msg, _ := decode(p); msg := msg.(type) {
+			case *globalRequestMsg:
+				if msg.Type == "known" {
+					reply = Marshal(&globalRequestSuccessMsg{})
+				}
+			case *channelOpenMsg:
+				if msg.ChanType == "known" {
+					reply = Marshal(&channelOpenConfirmMsg{
+						PeersId:       msg.PeersId,
+						MyId:          7,
+						MyWindow:      1 << 20,
+						MaxPacketSize: 1 << 15,
+					})
+				}
+			}
+			if err := server.writePacket(reply); err != nil {
+				return
+			}
+		}
+	}()
+
+	if _, _, err := client.SendRequest("unknown", true, nil); err != ErrUnimplemented {
+		t.Errorf("SendRequest: got error %v, want %v", err, ErrUnimplemented)
+	}
+	if ok, _, err := client.SendRequest("known", true, nil); err != nil || !ok {
+		t.Errorf("SendRequest after an unimplemented one: got %v, %v", ok, err)
+	}
+	if _, _, err := client.OpenChannel("unknown", nil); err != ErrUnimplemented {
+		t.Errorf("OpenChannel: got error %v, want %v", err, ErrUnimplemented)
+	}
+	ch, reqs, err := client.OpenChannel("known", nil)
+	if err != nil {
+		t.Fatalf("OpenChannel: %v", err)
+	}
+	go DiscardRequests(reqs)
+	if _, err := ch.SendRequest("unknown", true, nil); err != ErrUnimplemented {
+		t.Errorf("channel SendRequest: got error %v, want %v", err, ErrUnimplemented)
+	}
+}
+
+func TestMuxChannelRequestConcurrent(t *testing.T) {
+	client, server, mux := channelPair(t)
+	defer server.Close()
+	defer client.Close()
+	defer mux.Close()
+
+	for _, ch := range []*channel{client, server} {
+		go func(ch *channel) {
+			for r := range ch.incomingRequests {
+				r.Reply(r.Type == "yes", nil)
+			}
+		}(ch)
+	}
+
+	// A reply that does not answer any request must not be taken
+	// for the answer to the next one. The round trip that follows
+	// ensures the reply has been processed before testing.
+	if err := client.sendMessage(channelRequestSuccessMsg{PeersId: client.remoteId}); err != nil {
+		t.Fatalf("sendMessage: %v", err)
+	}
+	if _, err := client.SendRequest("yes", true, nil); err != nil {
+		t.Fatalf("SendRequest: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(want bool) {
+			defer wg.Done()
+			name := "no"
+			if want {
+				name = "yes"
+			}
+			ok, err := server.SendRequest(name, true, nil)
+			if err != nil {
+				t.Errorf("SendRequest(%s): %v", name, err)
+			} else if ok != want {
+				t.Errorf("SendRequest(%s): got %v", name, ok)
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+}
+
 func TestMuxGlobalRequest(t *testing.T) {
 	clientMux, serverMux := muxPair()
 	defer serverMux.Close()
@@ -466,6 +675,23 @@ func TestZeroWindowAdjust(t *testing.T) {
 	}
 }
 
+func TestChannelMaxPacketSize(t *testing.T) {
+	a, b, mux := channelPair(t)
+	defer a.Close()
+	defer b.Close()
+	defer mux.Close()
+
+	for _, ch := range []Channel{a, b} {
+		sizer, ok := ch.(MaxPacketSizer)
+		if !ok {
+			t.Fatalf("%T does not implement MaxPacketSizer", ch)
+		}
+		if got := sizer.MaxPacketSize(); got != channelMaxPacket {
+			t.Errorf("got MaxPacketSize %d, want %d", got, channelMaxPacket)
+		}
+	}
+}
+
 func TestMuxMaxPacketSize(t *testing.T) {
 	a, b, mux := channelPair(t)
 	defer a.Close()
@@ -491,6 +717,68 @@ func TestMuxMaxPacketSize(t *testing.T) {
 	}
 }
 
+func TestMuxShutdown(t *testing.T) {
+	a, b, mux := channelPair(t)
+	defer mux.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- a.mux.Shutdown(context.Background())
+	}()
+
+	req, ok := <-mux.incomingRequests
+	if !ok {
+		t.Fatal("connection closed before no-more-sessions request")
+	}
+	if req.Type != noMoreSessionsRequest {
+		t.Fatalf("got request %q, want %q", req.Type, noMoreSessionsRequest)
+	}
+
+	if _, err := mux.openChannel("chan", nil); err == nil {
+		t.Fatal("openChannel succeeded during shutdown")
+	} else if ocf, ok := err.(*OpenChannelError); !ok || ocf.Reason != Prohibited {
+		t.Fatalf("got %v, want prohibited *OpenChannelError", err)
+	}
+	if _, err := a.mux.openChannel("chan", nil); err != errShuttingDown {
+		t.Fatalf("got %v, want %v", err, errShuttingDown)
+	}
+
+	// In-flight channels keep working until they are closed.
+	go io.WriteString(b, "hello")
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(a, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v with a channel still open", err)
+	default:
+	}
+
+	b.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := mux.Wait(); err == nil {
+		t.Fatal("connection still alive after Shutdown")
+	}
+}
+
+func TestMuxShutdownDeadline(t *testing.T) {
+	a, b, mux := channelPair(t)
+	defer b.Close()
+	defer mux.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := a.mux.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := mux.Wait(); err == nil {
+		t.Fatal("connection still alive after Shutdown")
+	}
+}
+
 // Don't ship code with debug=true.
 func TestDebug(t *testing.T) {
 	if debugMux {
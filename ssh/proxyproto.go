@@ -0,0 +1,170 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// proxyV2Signature starts every version 2 PROXY protocol header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1MaxLength is the longest version 1 header, CRLF included.
+const proxyV1MaxLength = 107
+
+// StripProxyHeader reads the PROXY protocol header, version 1 or 2,
+// that a load balancer sends ahead of the proxied connection, as
+// described in
+// https://www.haproxy.org/download/1.8/doc/proxy-protocol.txt. It
+// returns the address of the original client and a net.Conn whose
+// RemoteAddr reports it, to be passed to NewServerConn instead of conn.
+// For headers that carry no address, such as health checks by the
+// load balancer, conn.RemoteAddr is used.
+//
+// The header is not authenticated, so it must only be accepted from
+// trusted load balancers. It is an error for it to be missing.
+func StripProxyHeader(conn net.Conn) (realRemote net.Addr, wrapped net.Conn, err error) {
+	r := bufio.NewReader(conn)
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var remote net.Addr
+	switch {
+	case bytes.Equal(sig, proxyV2Signature):
+		remote, err = readProxyV2Header(r)
+	case bytes.HasPrefix(sig, []byte("PROXY ")):
+		remote, err = readProxyV1Header(r)
+	default:
+		return nil, nil, errors.New("ssh: missing PROXY protocol header")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if remote == nil {
+		remote = conn.RemoteAddr()
+	}
+	return remote, &proxiedConn{Conn: conn, r: r, remote: remote}, nil
+}
+
+// readProxyV1Header parses the text form of the header, for example
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 22\r\n".
+func readProxyV1Header(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > proxyV1MaxLength {
+		return nil, errors.New("ssh: PROXY header is too long")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("ssh: PROXY header does not end in CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("ssh: invalid PROXY header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("ssh: invalid source address in PROXY header %q", line)
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("ssh: invalid source port in PROXY header %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2Header parses the binary form of the header.
+func readProxyV2Header(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("ssh: unsupported PROXY protocol version %d", hdr[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch cmd := hdr[12] & 0xf; cmd {
+	case 0:
+		// LOCAL: the load balancer's own connection.
+		return nil, nil
+	case 1:
+		// PROXY
+	default:
+		return nil, fmt.Errorf("ssh: unsupported PROXY command %d", cmd)
+	}
+
+	family, proto := hdr[13]>>4, hdr[13]&0xf
+	var ip net.IP
+	var port uint16
+	switch family {
+	case 1, 2:
+		// AF_INET or AF_INET6: source, destination, source port,
+		// destination port.
+		n := net.IPv4len
+		if family == 2 {
+			n = net.IPv6len
+		}
+		if len(payload) < 2*n+4 {
+			return nil, errors.New("ssh: PROXY header is too short for its addresses")
+		}
+		ip = net.IP(payload[:n])
+		port = binary.BigEndian.Uint16(payload[2*n:])
+	case 3:
+		// AF_UNIX: two 108 byte paths.
+		if len(payload) < 216 {
+			return nil, errors.New("ssh: PROXY header is too short for its addresses")
+		}
+		name := payload[:108]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		return &net.UnixAddr{Name: string(name), Net: "unix"}, nil
+	default:
+		// AF_UNSPEC, or unknown: the address is not usable.
+		return nil, nil
+	}
+
+	switch proto {
+	case 1:
+		return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+	case 2:
+		return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+	}
+	return nil, nil
+}
+
+// proxiedConn is a connection whose PROXY header has been read.
+type proxiedConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+func (c *proxiedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// proxyV2Header builds a version 2 header for cmd, family and payload.
+func proxyV2Header(cmd, family byte, payload []byte) string {
+	hdr := append([]byte(nil), proxyV2Signature...)
+	hdr = append(hdr, 0x20|cmd, family, 0, 0)
+	binary.BigEndian.PutUint16(hdr[14:], uint16(len(payload)))
+	return string(append(hdr, payload...))
+}
+
+func TestStripProxyHeader(t *testing.T) {
+	v4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0, 22}
+	v6 := make([]byte, 36)
+	v6[0], v6[15] = 0x20, 1
+	v6[32], v6[33] = 0x12, 0x34
+	// A TLV after the addresses is skipped.
+	v4TLV := append(append([]byte(nil), v4...), 0x01, 0, 2, 'h', '2')
+
+	for _, tt := range []struct {
+		header string
+		want   string // the real remote address, or "" for the conn's own
+	}{
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 56324 22\r\n", "192.0.2.1:56324"},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 4660 22\r\n", "[2001:db8::1]:4660"},
+		{"PROXY UNKNOWN\r\n", ""},
+		{"PROXY UNKNOWN 192.0.2.1 198.51.100.1 56324 22\r\n", ""},
+		{proxyV2Header(1, 0x11, v4), "192.0.2.1:56324"},
+		{proxyV2Header(1, 0x11, v4TLV), "192.0.2.1:56324"},
+		{proxyV2Header(1, 0x21, v6), "[2000::1]:4660"},
+		{proxyV2Header(0, 0x00, nil), ""},
+	} {
+		c1, c2 := net.Pipe()
+		go func() {
+			c1.Write([]byte(tt.header + "SSH-2.0-Go\r\n"))
+			c1.Close()
+		}()
+
+		remote, conn, err := StripProxyHeader(c2)
+		if err != nil {
+			t.Errorf("%q: %v", tt.header, err)
+			c2.Close()
+			continue
+		}
+		want := tt.want
+		if want == "" {
+			want = c2.RemoteAddr().String()
+		}
+		if remote.String() != want || conn.RemoteAddr().String() != want {
+			t.Errorf("%q: got remote %v and %v, want %s", tt.header, remote, conn.RemoteAddr(), want)
+		}
+		rest, err := ioutil.ReadAll(conn)
+		if err != nil || string(rest) != "SSH-2.0-Go\r\n" {
+			t.Errorf("%q: read %q, %v after the header, want the version line", tt.header, rest, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestStripProxyHeaderErrors(t *testing.T) {
+	for _, header := range []string{
+		"SSH-2.0-Go\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n",
+		"PROXY TCP4 2001:db8::1 198.51.100.1 56324 22\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 65536 22\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324 22\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324 22 and a lot of other text that makes the header longer than allowed\r\n",
+		proxyV2Header(1, 0x11, []byte{192, 0, 2, 1}),
+		proxyV2Header(2, 0x11, nil),
+	} {
+		c1, c2 := net.Pipe()
+		go func() {
+			c1.Write([]byte(header))
+			c1.Close()
+		}()
+		if _, _, err := StripProxyHeader(c2); err == nil {
+			t.Errorf("%q: got no error", header)
+		}
+		c2.Close()
+	}
+}
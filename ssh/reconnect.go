@@ -0,0 +1,380 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// A BackoffPolicy decides when a ReconnectingClient dials again after
+// failing to connect.
+type BackoffPolicy interface {
+	// Backoff is called after the attempt'th consecutive failure to
+	// dial, starting at 1, with the error returned by the dial
+	// function. It returns how long to wait before dialing again, or
+	// false if err is permanent and reconnecting should stop.
+	Backoff(attempt int, err error) (delay time.Duration, retry bool)
+}
+
+// ExponentialBackoff is a BackoffPolicy that doubles the delay after each
+// failed attempt. A random part of the delay is left out, so that clients
+// that lost their connections at the same time do not all dial again at
+// the same time.
+type ExponentialBackoff struct {
+	// Initial is the delay after the first failure. If zero, one
+	// second is used.
+	Initial time.Duration
+
+	// Max is the largest delay. If zero, one minute is used.
+	Max time.Duration
+
+	// MaxAttempts is the number of failed attempts after which
+	// reconnecting stops. If zero, there is no limit.
+	MaxAttempts int
+
+	// Permanent reports whether a dial error is permanent, in which
+	// case reconnecting stops. If nil, errors matching ErrAuthFailed
+	// or ErrHostKeyMismatch are permanent, and all others are retried.
+	Permanent func(error) bool
+}
+
+// permanentDialError is the default ExponentialBackoff.Permanent: dialing
+// again does not help if the credentials or the host key are wrong.
+func permanentDialError(err error) bool {
+	return errors.Is(err, ErrAuthFailed) || errors.Is(err, ErrHostKeyMismatch)
+}
+
+func (b *ExponentialBackoff) Backoff(attempt int, err error) (time.Duration, bool) {
+	if b.MaxAttempts > 0 && attempt >= b.MaxAttempts {
+		return 0, false
+	}
+	permanent := b.Permanent
+	if permanent == nil {
+		permanent = permanentDialError
+	}
+	if permanent(err) {
+		return 0, false
+	}
+
+	initial, max := b.Initial, b.Max
+	if initial <= 0 {
+		initial = time.Second
+	}
+	if max <= 0 {
+		max = time.Minute
+	}
+	delay := initial
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)), true
+}
+
+// ReconnectingClient maintains a Client that is dialed again when its
+// connection is lost. The connection is established on demand: the methods
+// that need it wait until a dial succeeds, or their context expires.
+// Concurrent callers share a single dial. Sessions and connections made on
+// a lost Client fail with it; the caller is expected to make new ones.
+// Remote forwards made with Listen are requested again on each new
+// Client; those made directly on a Client returned by the Client method
+// are lost with it.
+type ReconnectingClient struct {
+	dial   func() (*Client, error)
+	policy BackoffPolicy
+
+	mu      sync.Mutex
+	client  *Client       // the connected Client, if any
+	dialing chan struct{} // closed when the running dial loop ends
+	err     error         // permanent dial error
+	closed  chan struct{}
+}
+
+// NewReconnectingClient returns a ReconnectingClient that uses dial to
+// connect, such as a closure around Dial. If policy is nil, an
+// ExponentialBackoff with default settings is used.
+func NewReconnectingClient(dial func() (*Client, error), policy BackoffPolicy) *ReconnectingClient {
+	if policy == nil {
+		policy = &ExponentialBackoff{}
+	}
+	return &ReconnectingClient{
+		dial:   dial,
+		policy: policy,
+		closed: make(chan struct{}),
+	}
+}
+
+var errReconnectingClientClosed = errors.New("ssh: reconnecting client is closed")
+
+// Client returns the connected Client, dialing if necessary. If the
+// BackoffPolicy gave up on a dial error, that error is returned by this
+// and all later calls.
+func (r *ReconnectingClient) Client(ctx context.Context) (*Client, error) {
+	for {
+		r.mu.Lock()
+		select {
+		case <-r.closed:
+			r.mu.Unlock()
+			return nil, errReconnectingClientClosed
+		default:
+		}
+		if r.err != nil {
+			err := r.err
+			r.mu.Unlock()
+			return nil, err
+		}
+		if r.client != nil {
+			c := r.client
+			r.mu.Unlock()
+			return c, nil
+		}
+		if r.dialing == nil {
+			r.dialing = make(chan struct{})
+			go r.connect(r.dialing)
+		}
+		dialing := r.dialing
+		r.mu.Unlock()
+
+		select {
+		case <-dialing:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// connect dials until it succeeds, the policy gives up or r is closed, and
+// then closes done.
+func (r *ReconnectingClient) connect(done chan struct{}) {
+	defer close(done)
+	for attempt := 1; ; attempt++ {
+		c, err := r.dial()
+		if err == nil {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.dialing = nil
+			select {
+			case <-r.closed:
+				c.Close()
+			default:
+				r.client = c
+				go r.watch(c)
+			}
+			return
+		}
+
+		delay, retry := r.policy.Backoff(attempt, err)
+		if !retry {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.dialing = nil
+			r.err = err
+			return
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-r.closed:
+			t.Stop()
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.dialing = nil
+			return
+		}
+	}
+}
+
+// watch forgets c once its connection is lost.
+func (r *ReconnectingClient) watch(c *Client) {
+	c.Wait()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.client == c {
+		r.client = nil
+	}
+}
+
+// NewSession opens a new Session on the connected Client, dialing if
+// necessary.
+func (r *ReconnectingClient) NewSession(ctx context.Context) (*Session, error) {
+	c, err := r.Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.NewSession()
+}
+
+// Dial initiates a connection to addr from the remote host through the
+// connected Client, dialing it if necessary. See Client.Dial.
+func (r *ReconnectingClient) Dial(ctx context.Context, n, addr string) (net.Conn, error) {
+	c, err := r.Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.Dial(n, addr)
+}
+
+// Listen requests the remote host to listen on addr through the connected
+// Client, dialing it if necessary, like Client.Listen. Whenever the
+// connection is lost, the returned net.Listener asks the next Client to
+// listen on addr again; connections that arrive in between are refused
+// by the remote host. If addr has port 0, the remote host may pick a
+// different port each time, and Addr reports the current one. Accept
+// fails once the listener is closed, the ReconnectingClient gives up
+// dialing or the remote host refuses to listen again after a reconnect.
+// The listener must be serviced, or the SSH connection may hang.
+func (r *ReconnectingClient) Listen(ctx context.Context, n, addr string) (net.Listener, error) {
+	c, err := r.Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := c.Listen(n, addr)
+	if err != nil {
+		return nil, err
+	}
+	l := &reconnectingListener{
+		r:       r,
+		n:       n,
+		addr:    addr,
+		ln:      ln,
+		conns:   make(chan net.Conn),
+		stopped: make(chan struct{}),
+	}
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	go l.serve(ln)
+	return l, nil
+}
+
+// reconnectingListener is the net.Listener returned by
+// ReconnectingClient.Listen.
+type reconnectingListener struct {
+	r       *ReconnectingClient
+	n, addr string
+
+	ctx    context.Context // done when the listener is closed
+	cancel context.CancelFunc
+
+	conns   chan net.Conn
+	stopped chan struct{} // closed when serve has returned
+	err     error         // the reason serve returned; set before stopped is closed
+
+	mu sync.Mutex
+	ln net.Listener // the listener on the current Client
+}
+
+// serve passes the connections accepted on ln to Accept, and listens again
+// on a new Client when ln fails.
+func (l *reconnectingListener) serve(ln net.Listener) {
+	defer close(l.stopped)
+	for {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				break
+			}
+			select {
+			case l.conns <- c:
+			case <-l.ctx.Done():
+				c.Close()
+			}
+		}
+
+		var err error
+		ln, err = l.relisten()
+		if err != nil {
+			l.err = err
+			return
+		}
+	}
+}
+
+// relisten listens on addr through the next connected Client, and
+// returns the new listener.
+func (l *reconnectingListener) relisten() (net.Listener, error) {
+	for {
+		if l.ctx.Err() != nil {
+			return nil, io.EOF
+		}
+		c, err := l.r.Client(l.ctx)
+		if err != nil {
+			if l.ctx.Err() != nil {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		ln, err := c.Listen(l.n, l.addr)
+		if err == io.EOF {
+			// The connection was lost again before the request
+			// was answered.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.ctx.Err() != nil {
+			ln.Close()
+			return nil, io.EOF
+		}
+		l.ln = ln
+		return ln, nil
+	}
+}
+
+func (l *reconnectingListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.stopped:
+		return nil, l.err
+	}
+}
+
+func (l *reconnectingListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ctx.Err() != nil {
+		return io.EOF
+	}
+	l.cancel()
+	return l.ln.Close()
+}
+
+func (l *reconnectingListener) Addr() net.Addr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ln.Addr()
+}
+
+// Close closes the connected Client, if any, and stops reconnecting.
+func (r *ReconnectingClient) Close() error {
+	r.mu.Lock()
+	select {
+	case <-r.closed:
+		r.mu.Unlock()
+		return errReconnectingClientClosed
+	default:
+	}
+	close(r.closed)
+	c := r.client
+	r.client = nil
+	r.mu.Unlock()
+
+	if c != nil {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// reconnectDialer dials test servers over pipes, failing while fail is
+// set.
+type reconnectDialer struct {
+	t *testing.T
+
+	// forward makes the servers accept tcpip-forward requests, and
+	// then open a forwarded connection.
+	forward bool
+
+	mu    sync.Mutex
+	dials int
+	fail  error
+	conns []net.Conn
+}
+
+func (d *reconnectDialer) dial() (*Client, error) {
+	d.mu.Lock()
+	d.dials++
+	err := d.fail
+	d.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	c1, c2, err := netPipe()
+	if err != nil {
+		d.t.Fatalf("netPipe: %v", err)
+	}
+	d.mu.Lock()
+	d.conns = append(d.conns, c1, c2)
+	d.mu.Unlock()
+
+	serverConf := &ServerConfig{
+		NoClientAuth: true,
+	}
+	serverConf.AddHostKey(testSigners["rsa"])
+	go func() {
+		conn, chans, reqs, err := NewServerConn(c1, serverConf)
+		if err != nil {
+			return
+		}
+		go d.handleRequests(conn, reqs)
+		for newCh := range chans {
+			newCh.Reject(Prohibited, "")
+		}
+	}()
+
+	conn, chans, reqs, err := NewClientConn(c2, "", &ClientConfig{
+		User:            "user",
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, chans, reqs), nil
+}
+
+func (d *reconnectDialer) handleRequests(conn Conn, reqs <-chan *Request) {
+	for req := range reqs {
+		if d.forward && req.Type == "tcpip-forward" {
+			req.Reply(true, nil)
+			go openForwarded(conn)
+			continue
+		}
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// openForwarded opens a forwarded-tcpip channel on conn, retrying until
+// the client has registered its forward.
+func openForwarded(conn Conn) {
+	payload := forwardedTCPPayload{"127.0.0.1", 8022, "192.0.2.7", 4242}
+	for {
+		ch, in, err := conn.OpenChannel("forwarded-tcpip", Marshal(&payload))
+		if _, ok := err.(*OpenChannelError); ok {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		if err != nil {
+			return
+		}
+		go DiscardRequests(in)
+		ch.Close()
+		return
+	}
+}
+
+func (d *reconnectDialer) setFail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.fail = err
+}
+
+func (d *reconnectDialer) closeAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, c := range d.conns {
+		c.Close()
+	}
+}
+
+func TestReconnectingClient(t *testing.T) {
+	d := &reconnectDialer{t: t}
+	defer d.closeAll()
+	r := NewReconnectingClient(d.dial, &ExponentialBackoff{Initial: time.Millisecond, Max: 10 * time.Millisecond})
+	defer r.Close()
+
+	ctx := context.Background()
+	first, err := r.Client(ctx)
+	if err != nil {
+		t.Fatalf("Client: %v", err)
+	}
+	if c, err := r.Client(ctx); err != nil || c != first {
+		t.Fatalf("got client %p, %v, want the connected one", c, err)
+	}
+
+	// Lose the connection while the server is unreachable.
+	d.setFail(errors.New("unreachable"))
+	d.closeAll()
+	first.Wait()
+	for lost := false; !lost; {
+		r.mu.Lock()
+		lost = r.client == nil
+		r.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	shortCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	if _, err := r.Client(shortCtx); err != context.DeadlineExceeded {
+		t.Errorf("got error %v while unreachable, want %v", err, context.DeadlineExceeded)
+	}
+	cancel()
+
+	d.setFail(nil)
+	second, err := r.Client(ctx)
+	if err != nil {
+		t.Fatalf("Client after reconnect: %v", err)
+	}
+	if second == first {
+		t.Error("got the lost client after reconnecting")
+	}
+	if d.dials < 3 {
+		t.Errorf("got %d dials, want several", d.dials)
+	}
+
+	r.Close()
+	if _, err := r.Client(ctx); err == nil {
+		t.Error("Client succeeded after Close")
+	}
+	if err := second.Wait(); err == nil {
+		t.Error("Close did not close the connected client")
+	}
+}
+
+func TestReconnectingClientPermanentError(t *testing.T) {
+	authErr := errors.New("authentication failed")
+	d := &reconnectDialer{t: t, fail: authErr}
+	r := NewReconnectingClient(d.dial, &ExponentialBackoff{
+		Initial:   time.Millisecond,
+		Permanent: func(err error) bool { return err == authErr },
+	})
+	defer r.Close()
+
+	// Concurrent callers share the dial and get its error.
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.NewSession(context.Background()); err != authErr {
+				t.Errorf("got error %v, want %v", err, authErr)
+			}
+		}()
+	}
+	wg.Wait()
+	if d.dials != 1 {
+		t.Errorf("got %d dials, want 1", d.dials)
+	}
+}
+
+func TestReconnectingClientListen(t *testing.T) {
+	d := &reconnectDialer{t: t, forward: true}
+	defer d.closeAll()
+	r := NewReconnectingClient(d.dial, &ExponentialBackoff{Initial: time.Millisecond, Max: 10 * time.Millisecond})
+	defer r.Close()
+
+	l, err := r.Listen(context.Background(), "tcp", "127.0.0.1:8022")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	c.Close()
+
+	// The forward is requested again on the next connection.
+	d.closeAll()
+	c, err = l.Accept()
+	if err != nil {
+		t.Fatalf("Accept after reconnect: %v", err)
+	}
+	c.Close()
+	if d.dials != 2 {
+		t.Errorf("got %d dials, want 2", d.dials)
+	}
+	if got := l.Addr().String(); got != "127.0.0.1:8022" {
+		t.Errorf("got Addr %s, want 127.0.0.1:8022", got)
+	}
+
+	l.Close()
+	if _, err := l.Accept(); err == nil {
+		t.Error("Accept succeeded after Close")
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := &ExponentialBackoff{Initial: time.Second, Max: 5 * time.Second, MaxAttempts: 5}
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		delay, retry := b.Backoff(attempt+1, nil)
+		if !retry || delay < want/2 || delay > want {
+			t.Errorf("attempt %d: got %v, %v, want up to %v", attempt+1, delay, retry, want)
+		}
+	}
+	if _, retry := b.Backoff(5, nil); retry {
+		t.Error("retrying after MaxAttempts")
+	}
+
+	for _, err := range []error{
+		fmt.Errorf("%w, attempted methods [none]", ErrAuthFailed),
+		fmt.Errorf("ssh: handshake failed: %w", ErrHostKeyMismatch),
+	} {
+		if _, retry := b.Backoff(1, err); retry {
+			t.Errorf("retrying after %v", err)
+		}
+	}
+	if _, retry := b.Backoff(1, errors.New("unreachable")); !retry {
+		t.Error("not retrying after a network error")
+	}
+}
@@ -6,6 +6,7 @@ package ssh
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -25,7 +26,9 @@ type Permissions struct {
 	// defines "force-command" (only allow the given command to
 	// execute) and "source-address" (only allow connections from
 	// the given address). The SSH package currently only enforces
-	// the "source-address" critical option. It is up to server
+	// the "source-address" critical option, and the
+	// "verify-required" option, which requires security key
+	// signatures to report user verification. It is up to server
 	// implementations to enforce other critical options, such as
 	// "force-command", by checking them after the SSH handshake
 	// is successful. In general, SSH servers should reject
@@ -37,9 +40,10 @@ type Permissions struct {
 	// offer on authenticated connections. Lack of support for an
 	// extension does not preclude authenticating a user. Common
 	// extensions are "permit-agent-forwarding",
-	// "permit-X11-forwarding". The Go SSH library currently does
-	// not act on any extension, and it is up to server
-	// implementations to honor them. Extensions can be used to
+	// "permit-X11-forwarding". The Go SSH library currently only
+	// acts on "no-touch-required", which lets security key
+	// signatures omit user presence, and it is up to server
+	// implementations to honor the others. Extensions can be used to
 	// pass data from the authentication callbacks to the server
 	// application layer.
 	Extensions map[string]string
@@ -56,6 +60,21 @@ type ServerConfig struct {
 	// authenticating.
 	NoClientAuth bool
 
+	// GexModuli holds the Diffie-Hellman groups offered to clients
+	// using diffie-hellman-group-exchange key exchange; see
+	// ParseModuli for reading them from an OpenSSH moduli file. If
+	// empty, group exchange is not offered.
+	GexModuli []GexModulus
+
+	// MaxGexBits, if non-zero, is the size in bits of the largest
+	// group used for diffie-hellman-group-exchange, whatever the
+	// client asks for. Fixed groups that are larger, such as
+	// diffie-hellman-group18-sha512, are not offered either, so it
+	// bounds the CPU time a client can make the server spend on a
+	// Diffie-Hellman key exchange. Clients that require larger groups
+	// fail to connect.
+	MaxGexBits int
+
 	// MaxAuthTries specifies the maximum number of authentication attempts
 	// permitted per connection. If set to a negative number, the number of
 	// attempts are unlimited. If set to zero, the number of attempts are limited
@@ -89,12 +108,25 @@ type ServerConfig struct {
 	// attempts.
 	AuthLogCallback func(conn ConnMetadata, method string, err error)
 
+	// BannerCallback, if non-nil, is called once per connection, when
+	// the first authentication request arrives, and the string it
+	// returns is sent to the client as a banner (RFC 4252, section
+	// 5.4) before the request is answered. conn.User() is populated
+	// by then, so the banner may depend on the user. An empty string
+	// sends no banner.
+	BannerCallback func(conn ConnMetadata) string
+
 	// ServerVersion is the version identification string to announce in
 	// the public handshake.
 	// If empty, a reasonable default is used.
 	// Note that RFC 4253 section 4.2 requires that this string start with
 	// "SSH-2.0-".
 	ServerVersion string
+
+	// HandshakeLimiter, if non-nil, is consulted by NewServerConn
+	// before starting the handshake of a new connection, and may
+	// reject or delay it. See NewHandshakeLimiter.
+	HandshakeLimiter HandshakeLimiter
 }
 
 // AddHostKey adds a private key as a host key. If an existing host
@@ -157,6 +189,27 @@ type ServerConn struct {
 	Permissions *Permissions
 }
 
+// Shutdown gracefully shuts down the connection. It stops accepting and
+// opening new channels, sends a no-more-sessions@openssh.com request to
+// the client, and waits for all open channels to be closed before
+// closing the underlying network connection. If ctx expires before the
+// channels have drained, the connection is closed anyway and the
+// context's error is returned. If the ServerConn wraps a Conn from
+// outside this package, Shutdown just closes it.
+func (s *ServerConn) Shutdown(ctx context.Context) error {
+	return shutdownConn(ctx, s.Conn)
+}
+
+// SendRequestContext is like SendRequest, but stops waiting for the
+// reply and returns the context's error once ctx is done. The reply of
+// an abandoned request is discarded when it arrives. If the ServerConn
+// wraps a Conn from outside this package, the request is sent from a
+// separate goroutine, which keeps waiting for the reply after
+// SendRequestContext has returned.
+func (s *ServerConn) SendRequestContext(ctx context.Context, name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	return sendRequestContext(ctx, s.Conn, name, wantReply, payload)
+}
+
 // NewServerConn starts a new SSH server with c as the underlying
 // transport.  It starts with a handshake and, if the handshake is
 // unsuccessful, it closes the connection and returns an error.  The
@@ -169,13 +222,22 @@ func NewServerConn(c net.Conn, config *ServerConfig) (*ServerConn, <-chan NewCha
 		fullConf.MaxAuthTries = 6
 	}
 
+	if fullConf.HandshakeLimiter != nil {
+		release, err := fullConf.HandshakeLimiter.Acquire(c.RemoteAddr())
+		if err != nil {
+			c.Close()
+			return nil, nil, nil, err
+		}
+		defer release()
+	}
+
 	s := &connection{
 		sshConn: sshConn{conn: c},
 	}
 	perms, err := s.serverHandshake(&fullConf)
 	if err != nil {
 		c.Close()
-		return nil, nil, nil, err
+		return nil, nil, nil, classifyHandshakeError(err)
 	}
 	return &ServerConn{s, perms}, s.mux.incomingChannels, s.mux.incomingRequests, nil
 }
@@ -207,12 +269,14 @@ func (s *connection) serverHandshake(config *ServerConfig) (*Permissions, error)
 		s.serverVersion = []byte(packageVersion)
 	}
 	var err error
-	s.clientVersion, err = exchangeVersions(s.sshConn.conn, s.serverVersion)
+	s.clientVersion, err = exchangeVersions(s.sshConn.conn, s.serverVersion, config.VersionExchangeWriter, false)
 	if err != nil {
 		return nil, err
 	}
 
 	tr := newTransport(s.sshConn.conn, config.Rand, false /* not client */)
+	tr.setPacketTrace(config.PacketTraceCallback)
+	tr.setMaxPacket(config.MaxPacketLength)
 	s.transport = newServerTransport(tr, s.clientVersion, s.serverVersion, config)
 
 	if err := s.transport.waitSession(); err != nil {
@@ -252,12 +316,42 @@ func (s *connection) serverHandshake(config *ServerConfig) (*Permissions, error)
 func isAcceptableAlgo(algo string) bool {
 	switch algo {
 	case KeyAlgoRSA, KeyAlgoDSA, KeyAlgoECDSA256, KeyAlgoECDSA384, KeyAlgoECDSA521, KeyAlgoED25519,
+		KeyAlgoSKECDSA256, KeyAlgoSKED25519,
 		CertAlgoRSAv01, CertAlgoDSAv01, CertAlgoECDSA256v01, CertAlgoECDSA384v01, CertAlgoECDSA521v01:
 		return true
 	}
 	return false
 }
 
+// checkSKFlags checks that a security key signature reports the user
+// presence and verification required by perms. User presence is required
+// unless the "no-touch-required" extension is set, and user verification
+// is required if the "verify-required" critical option is set.
+func checkSKFlags(sig *Signature, perms *Permissions) error {
+	switch sig.Format {
+	case KeyAlgoSKECDSA256, KeyAlgoSKED25519:
+	default:
+		return nil
+	}
+	f, err := parseSKFields(sig)
+	if err != nil {
+		return err
+	}
+
+	var noTouch, verify bool
+	if perms != nil {
+		_, noTouch = perms.Extensions[noTouchRequiredExtension]
+		_, verify = perms.CriticalOptions[verifyRequiredCriticalOption]
+	}
+	if !noTouch && f.Flags&SKFlagUserPresent == 0 {
+		return errors.New("ssh: security key signature lacks user presence")
+	}
+	if verify && f.Flags&SKFlagUserVerified == 0 {
+		return errors.New("ssh: security key signature lacks user verification")
+	}
+	return nil
+}
+
 func checkSourceAddress(addr net.Addr, sourceAddrs string) error {
 	if addr == nil {
 		return errors.New("ssh: no address known for client, but source-address match required")
@@ -288,6 +382,86 @@ func checkSourceAddress(addr net.Addr, sourceAddrs string) error {
 	return fmt.Errorf("ssh: remote address %v is not allowed because of source-address restriction", addr)
 }
 
+// MatchSourceRestriction reports whether remote is allowed by pattern,
+// a comma-separated list in the format of the from="pattern-list"
+// option of OpenSSH's authorized_keys file. Each entry is an IP
+// address, a CIDR network or a wildcard pattern using '*' and '?', and
+// may be negated with a leading '!'. A matching negated entry denies
+// access even if other entries match. Like OpenSSH with its default
+// UseDNS setting, wildcard patterns are matched against the textual
+// IP address; the client's reverse DNS is not consulted, since it is
+// under the client's control. An error is returned for entries that
+// OpenSSH rejects, such as networks with host bits set.
+func MatchSourceRestriction(pattern string, remote net.Addr) (bool, error) {
+	if remote == nil {
+		return false, errors.New("ssh: no address known for client, but source restriction match required")
+	}
+
+	tcpAddr, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return false, fmt.Errorf("ssh: remote address %v is not an TCP address when checking source restriction", remote)
+	}
+	host := tcpAddr.IP.String()
+
+	matched := false
+	for _, entry := range strings.Split(pattern, ",") {
+		negated := strings.HasPrefix(entry, "!")
+		if negated {
+			entry = entry[1:]
+		}
+		if entry == "" {
+			return false, fmt.Errorf("ssh: empty entry in source restriction %q", pattern)
+		}
+
+		var found bool
+		if ip := net.ParseIP(entry); ip != nil {
+			found = ip.Equal(tcpAddr.IP)
+		} else if ip, ipNet, err := net.ParseCIDR(entry); err == nil {
+			if !ip.Equal(ipNet.IP) {
+				return false, fmt.Errorf("ssh: inconsistent mask length for network %q in source restriction", entry)
+			}
+			found = ipNet.Contains(tcpAddr.IP)
+		} else {
+			found = matchPattern(strings.ToLower(entry), host)
+		}
+
+		if found {
+			if negated {
+				return false, nil
+			}
+			matched = true
+		}
+	}
+	return matched, nil
+}
+
+// matchPattern reports whether s matches pat, in which '*' matches any
+// sequence of characters and '?' matches a single character.
+func matchPattern(pat, s string) bool {
+	for len(pat) > 0 {
+		switch pat[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchPattern(pat[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || pat[0] != s[0] {
+				return false
+			}
+		}
+		pat = pat[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
+
 // ServerAuthError implements the error interface. It appends any authentication
 // errors that may occur, and is returned if all of the authentication methods
 // provided by the user failed to authenticate.
@@ -305,6 +479,11 @@ func (l ServerAuthError) Error() string {
 	return "[" + strings.Join(errs, ", ") + "]"
 }
 
+// Is reports whether target is ErrAuthFailed.
+func (l ServerAuthError) Is(target error) bool {
+	return target == ErrAuthFailed
+}
+
 func (s *connection) serverAuthenticate(config *ServerConfig) (*Permissions, error) {
 	sessionID := s.transport.getSessionID()
 	var cache pubKeyCache
@@ -312,11 +491,12 @@ func (s *connection) serverAuthenticate(config *ServerConfig) (*Permissions, err
 
 	authFailures := 0
 	var authErrs []error
+	var displayedBanner bool
 
 userAuthLoop:
 	for {
 		if authFailures >= config.MaxAuthTries && config.MaxAuthTries > 0 {
-			discMsg := &disconnectMsg{
+			discMsg := &DisconnectError{
 				Reason:  2,
 				Message: "too many authentication failures",
 			}
@@ -343,6 +523,19 @@ userAuthLoop:
 		}
 
 		s.user = userAuthReq.User
+
+		if !displayedBanner && config.BannerCallback != nil {
+			displayedBanner = true
+			if msg := config.BannerCallback(s); msg != "" {
+				bannerMsg := &userAuthBannerMsg{
+					Message: msg,
+				}
+				if err := s.transport.writePacket(Marshal(bannerMsg)); err != nil {
+					return nil, err
+				}
+			}
+		}
+
 		perms = nil
 		authErr := errors.New("no auth passed yet")
 
@@ -464,6 +657,9 @@ userAuthLoop:
 
 				authErr = candidate.result
 				perms = candidate.perms
+				if authErr == nil {
+					authErr = checkSKFlags(sig, perms)
+				}
 			}
 		default:
 			authErr = fmt.Errorf("ssh: unknown method %q", userAuthReq.Method)
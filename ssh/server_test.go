@@ -0,0 +1,52 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMatchSourceRestriction(t *testing.T) {
+	for _, tt := range []struct {
+		pattern string
+		addr    string
+		want    bool
+		wantErr bool
+	}{
+		{"192.168.0.1", "192.168.0.1", true, false},
+		{"192.168.0.1", "192.168.0.2", false, false},
+		{"10.0.0.0/8,192.168.0.1", "10.1.2.3", true, false},
+		{"10.0.0.0/8", "11.1.2.3", false, false},
+		{"192.168.0.*", "192.168.0.42", true, false},
+		{"192.168.?.1", "192.168.7.1", true, false},
+		{"192.168.?.1", "192.168.17.1", false, false},
+		{"*,!10.0.0.1", "10.0.0.1", false, false},
+		{"*,!10.0.0.1", "10.0.0.2", true, false},
+		{"10.0.0.0/8,!10.0.0.0/24", "10.0.0.5", false, false},
+		{"!10.0.0.0/24,10.0.0.0/8", "10.1.0.5", true, false},
+		{"!192.168.*", "192.168.0.1", false, false},
+		{"!192.168.*", "10.0.0.1", false, false},
+		{"2001:db8::/32", "2001:db8::1", true, false},
+		{"2001:DB8::*", "2001:db8::1", true, false},
+		{"10.0.0.1/8", "10.0.0.1", false, true},
+		{"10.0.0.1,,10.0.0.2", "10.0.0.1", false, true},
+		{"!", "10.0.0.1", false, true},
+	} {
+		addr := &net.TCPAddr{IP: net.ParseIP(tt.addr), Port: 22}
+		got, err := MatchSourceRestriction(tt.pattern, addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("MatchSourceRestriction(%q, %s): got error %v, want error: %v", tt.pattern, tt.addr, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("MatchSourceRestriction(%q, %s) = %v, want %v", tt.pattern, tt.addr, got, tt.want)
+		}
+	}
+
+	if _, err := MatchSourceRestriction("*", &net.UnixAddr{Name: "/tmp/sock", Net: "unix"}); err == nil {
+		t.Error("MatchSourceRestriction succeeded for a unix address")
+	}
+}
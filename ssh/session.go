@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"strings"
 	"sync"
 )
 
@@ -144,6 +145,9 @@ type Session struct {
 	stdinPipeWriter io.WriteCloser
 
 	exitStatus chan error
+
+	mu                sync.Mutex
+	extensionHandlers map[string]ExtensionHandler
 }
 
 // SendRequest sends an out-of-band channel request on the SSH channel
@@ -152,6 +156,60 @@ func (s *Session) SendRequest(name string, wantReply bool, payload []byte) (bool
 	return s.ch.SendRequest(name, wantReply, payload)
 }
 
+// Extension sends the channel request name, which must be a vendor
+// extension of the form "name@domain" (RFC 4251, section 4.2), such as
+// "elevation@openssh.com", with the given payload on the session. If
+// wantReply is true, it waits for the reply and reports whether the
+// server accepted the request. Servers that do not know the extension
+// reject it, or for some minimal servers answer with ErrUnimplemented.
+func (s *Session) Extension(name string, wantReply bool, payload []byte) (bool, error) {
+	if !strings.Contains(name, "@") {
+		return false, fmt.Errorf("ssh: %q is not an extension request name", name)
+	}
+	return s.ch.SendRequest(name, wantReply, payload)
+}
+
+// An ExtensionHandler handles a channel request of a vendor extension
+// that the server sends on a session. It returns whether the request
+// is accepted, which is the reply if the server wants one.
+type ExtensionHandler func(payload []byte) bool
+
+// HandleExtension registers handler for the extension requests called
+// name that the server sends on the session, replacing any previous
+// handler; a nil handler removes it. As for Extension, name must be of
+// the form "name@domain"; standard requests such as "exit-status" are
+// handled by the session itself. Requests without a handler are
+// rejected, like OpenSSH does. Handlers are called one at a time from
+// the goroutine that reads the session's requests, and must not block.
+//
+// The session reads requests from the moment the channel is open, so a
+// request the server sends before the handler is registered is
+// rejected. Servers usually send extension requests only once a
+// command runs; register handlers before calling Shell, Start or Run.
+func (s *Session) HandleExtension(name string, handler ExtensionHandler) error {
+	if !strings.Contains(name, "@") {
+		return fmt.Errorf("ssh: %q is not an extension request name", name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if handler == nil {
+		delete(s.extensionHandlers, name)
+		return nil
+	}
+	if s.extensionHandlers == nil {
+		s.extensionHandlers = make(map[string]ExtensionHandler)
+	}
+	s.extensionHandlers[name] = handler
+	return nil
+}
+
+// extensionHandler returns the handler for the extension name, or nil.
+func (s *Session) extensionHandler(name string) ExtensionHandler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.extensionHandlers[name]
+}
+
 func (s *Session) Close() error {
 	return s.ch.Close()
 }
@@ -417,12 +475,32 @@ func (s *Session) Wait() error {
 	return copyError
 }
 
+// RFC 4254 Section 6.10.
+type exitStatusMsg struct {
+	Status uint32
+}
+
+// RFC 4254 Section 6.10.
+type exitSignalMsg struct {
+	Signal     string
+	CoreDumped bool
+	Errmsg     string
+	Lang       string
+}
+
 func (s *Session) wait(reqs <-chan *Request) error {
 	wm := Waitmsg{status: -1}
+	var malformed error
 	// Wait for msg channel to be closed before returning.
 	for msg := range reqs {
 		switch msg.Type {
 		case "exit-status":
+			if len(msg.Payload) < 4 {
+				// Keep serving reqs, so that later requests
+				// are still answered.
+				malformed = errors.New("ssh: malformed exit-status request")
+				continue
+			}
 			wm.status = int(binary.BigEndian.Uint32(msg.Payload))
 		case "exit-signal":
 			var sigval struct {
@@ -440,13 +518,20 @@ func (s *Session) wait(reqs <-chan *Request) error {
 			wm.msg = sigval.Error
 			wm.lang = sigval.Lang
 		default:
-			// This handles keepalives and matches
-			// OpenSSH's behaviour.
+			ok := false
+			if h := s.extensionHandler(msg.Type); h != nil {
+				ok = h(msg.Payload)
+			}
+			// Rejecting the others handles keepalives and
+			// matches OpenSSH's behaviour.
 			if msg.WantReply {
-				msg.Reply(false, nil)
+				msg.Reply(ok, nil)
 			}
 		}
 	}
+	if malformed != nil {
+		return malformed
+	}
 	if wm.status == 0 {
 		return nil
 	}
@@ -14,6 +14,9 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"net"
+	"os"
+	"os/exec"
+	"strings"
 	"testing"
 
 	"golang.org/x/crypto/ssh/terminal"
@@ -223,6 +226,63 @@ func TestExitStatusZero(t *testing.T) {
 	}
 }
 
+func TestExitStatusMalformed(t *testing.T) {
+	// The session keeps answering requests after the malformed one.
+	replied := make(chan error, 1)
+	conn := dial(func(ch Channel, in <-chan *Request, t *testing.T) {
+		defer ch.Close()
+		shell := newServerShell(ch, in, "> ")
+		readLine(shell, t)
+		if _, err := ch.SendRequest("exit-status", false, []byte{0, 1}); err != nil {
+			t.Errorf("unable to send status: %v", err)
+		}
+		_, err := ch.SendRequest("keepalive@openssh.com", true, nil)
+		replied <- err
+	}, t)
+	defer conn.Close()
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("Unable to request new session: %v", err)
+	}
+	defer session.Close()
+
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Unable to execute command: %v", err)
+	}
+	err = session.Wait()
+	if err == nil || !strings.Contains(err.Error(), "malformed exit-status") {
+		t.Fatalf("got %v, want an error for the short exit-status payload", err)
+	}
+	select {
+	case err := <-replied:
+		if err != nil {
+			t.Errorf("request after the malformed exit-status: %v", err)
+		}
+	default:
+		t.Error("Wait returned before the request after the malformed exit-status was answered")
+	}
+}
+
+func TestExitSignalMessage(t *testing.T) {
+	conn := dial(exitSignalHandler, t)
+	defer conn.Close()
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("Unable to request new session: %v", err)
+	}
+	defer session.Close()
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Unable to execute command: %v", err)
+	}
+	e, ok := session.Wait().(*ExitError)
+	if !ok {
+		t.Fatalf("expected *ExitError")
+	}
+	if e.Msg() != "Process terminated" || e.Lang() != "en-GB-oed" {
+		t.Errorf("got message %q in language %q", e.Msg(), e.Lang())
+	}
+}
+
 // Test exit signal and status are both returned correctly.
 func TestExitSignalAndStatus(t *testing.T) {
 	conn := dial(exitSignalAndStatusHandler, t)
@@ -389,17 +449,6 @@ func TestClientHandlesKeepalives(t *testing.T) {
 	}
 }
 
-type exitStatusMsg struct {
-	Status uint32
-}
-
-type exitSignalMsg struct {
-	Signal     string
-	CoreDumped bool
-	Errmsg     string
-	Lang       string
-}
-
 func handleTerminalRequests(in <-chan *Request) {
 	for req := range in {
 		ok := false
@@ -507,22 +556,13 @@ func readLine(shell *terminal.Terminal, t *testing.T) {
 }
 
 func sendStatus(status uint32, ch Channel, t *testing.T) {
-	msg := exitStatusMsg{
-		Status: status,
-	}
-	if _, err := ch.SendRequest("exit-status", false, Marshal(&msg)); err != nil {
+	if err := SendExitStatus(ch, status); err != nil {
 		t.Errorf("unable to send status: %v", err)
 	}
 }
 
 func sendSignal(signal string, ch Channel, t *testing.T) {
-	sig := exitSignalMsg{
-		Signal:     signal,
-		CoreDumped: false,
-		Errmsg:     "Process terminated",
-		Lang:       "en-GB-oed",
-	}
-	if _, err := ch.SendRequest("exit-signal", false, Marshal(&sig)); err != nil {
+	if err := SendExitSignal(ch, signal, false, "Process terminated", "en-GB-oed"); err != nil {
 		t.Errorf("unable to send signal: %v", err)
 	}
 }
@@ -772,3 +812,162 @@ func TestHostKeyAlgorithms(t *testing.T) {
 		t.Fatal("succeeded connecting with unknown hostkey algorithm")
 	}
 }
+
+// posixShellHandler runs the session's shell with /bin/sh.
+func posixShellHandler(ch Channel, in <-chan *Request, t *testing.T) {
+	defer ch.Close()
+	req, ok := <-in
+	if !ok || req.Type != "shell" {
+		t.Errorf("got request %v, want a shell request", req)
+		return
+	}
+	req.Reply(true, nil)
+	go DiscardRequests(in)
+
+	cmd := exec.Command("/bin/sh")
+	cmd.Stdin = ch
+	cmd.Stdout = ch
+	cmd.Stderr = ch.Stderr()
+	var status uint32
+	if err := cmd.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Errorf("running /bin/sh: %v", err)
+			return
+		}
+		status = uint32(exitErr.ExitCode())
+	}
+	sendStatus(status, ch, t)
+}
+
+func TestSessionRunBatch(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+	conn := dial(posixShellHandler, t)
+	defer conn.Close()
+
+	for _, tt := range []struct {
+		commands []string
+		want     []CommandResult
+		status   int // the ExitError status, or 0 for no error
+	}{
+		{
+			commands: []string{"echo hello", "printf partial", "echo oops >&2; false", "cat", "exit 3", "echo never"},
+			want: []CommandResult{
+				{Command: "echo hello", Stdout: []byte("hello\n")},
+				{Command: "printf partial", Stdout: []byte("partial")},
+				{Command: "echo oops >&2; false", Stderr: []byte("oops\n"), ExitStatus: 1},
+				{Command: "cat"},
+			},
+			status: 3,
+		},
+		{
+			commands: []string{"x=1", "echo $x", "(exit 7)"},
+			want: []CommandResult{
+				{Command: "x=1"},
+				{Command: "echo $x", Stdout: []byte("1\n")},
+				{Command: "(exit 7)", ExitStatus: 7},
+			},
+		},
+	} {
+		session, err := conn.NewSession()
+		if err != nil {
+			t.Fatalf("NewSession: %v", err)
+		}
+		results, err := session.RunBatch(tt.commands)
+		if tt.status == 0 && err != nil {
+			t.Errorf("RunBatch(%q): %v", tt.commands, err)
+		}
+		if exitErr, ok := err.(*ExitError); tt.status != 0 && (!ok || exitErr.ExitStatus() != tt.status) {
+			t.Errorf("RunBatch(%q): got error %v, want exit status %d", tt.commands, err, tt.status)
+		}
+		if len(results) != len(tt.want) {
+			t.Errorf("RunBatch(%q): got %d results, want %d", tt.commands, len(results), len(tt.want))
+			session.Close()
+			continue
+		}
+		for i, got := range results {
+			want := tt.want[i]
+			if got.Command != want.Command || !bytes.Equal(got.Stdout, want.Stdout) || !bytes.Equal(got.Stderr, want.Stderr) || got.ExitStatus != want.ExitStatus {
+				t.Errorf("result %d: got %q, %q, %q, %d, want %q, %q, %q, %d", i,
+					got.Command, got.Stdout, got.Stderr, got.ExitStatus,
+					want.Command, want.Stdout, want.Stderr, want.ExitStatus)
+			}
+		}
+		session.Close()
+	}
+}
+
+// extensionHandler accepts the elevation@openssh.com request and a
+// shell, then sends two extension requests of its own and exits with
+// the number of them that the client accepted.
+func extensionHandler(ch Channel, in <-chan *Request, t *testing.T) {
+	defer ch.Close()
+	req, ok := <-in
+	if !ok {
+		t.Error("got no request")
+		return
+	}
+	req.Reply(req.Type == "elevation@openssh.com" && string(req.Payload) == "\x01", nil)
+	if req, ok = <-in; !ok || req.Type != "shell" {
+		t.Errorf("got request %v, want a shell request", req)
+		return
+	}
+	req.Reply(true, nil)
+	go DiscardRequests(in)
+
+	var accepted uint32
+	for _, name := range []string{"vendor@example.com", "other@example.com"} {
+		ok, err := ch.SendRequest(name, true, []byte(name))
+		if err != nil {
+			t.Errorf("SendRequest(%q): %v", name, err)
+		}
+		if ok {
+			accepted++
+		}
+	}
+	sendStatus(accepted, ch, t)
+}
+
+func TestSessionExtension(t *testing.T) {
+	conn := dial(extensionHandler, t)
+	defer conn.Close()
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+
+	if _, err := session.Extension("exec", true, nil); err == nil {
+		t.Error("Extension accepted a standard request name")
+	}
+
+	if err := session.HandleExtension("exit-status", func([]byte) bool { return true }); err == nil {
+		t.Error("HandleExtension accepted a standard request name")
+	}
+
+	var got []byte
+	if err := session.HandleExtension("vendor@example.com", func(payload []byte) bool {
+		got = payload
+		return true
+	}); err != nil {
+		t.Fatalf("HandleExtension: %v", err)
+	}
+	session.HandleExtension("other@example.com", func(payload []byte) bool { return true })
+	session.HandleExtension("other@example.com", nil)
+
+	if ok, err := session.Extension("elevation@openssh.com", true, []byte{1}); !ok || err != nil {
+		t.Fatalf("Extension: got %v, %v, want acceptance", ok, err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell: %v", err)
+	}
+	err = session.Wait()
+	if exitErr, ok := err.(*ExitError); !ok || exitErr.ExitStatus() != 1 {
+		t.Errorf("got %v, want one accepted extension request", err)
+	}
+	if string(got) != "vendor@example.com" {
+		t.Errorf("handler got payload %q", got)
+	}
+}
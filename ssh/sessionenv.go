@@ -0,0 +1,82 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+// SessionEnv collects the environment variables that a client sets
+// with "env" requests (RFC 4254, section 6.4) on a server-side
+// session channel. Pass each request received on the channel to
+// HandleRequest; once the command is started, Environ returns the
+// variables to run it with.
+type SessionEnv struct {
+	// AcceptEnv lists the names of the variables that are
+	// accepted, like the AcceptEnv option of sshd_config. A name
+	// may contain the wildcards '*' and '?'. Variables that do not
+	// match are refused. If empty, all variables are refused.
+	AcceptEnv []string
+
+	vars    map[string]int
+	environ []string
+	started bool
+}
+
+// HandleRequest processes req if it is an "env" request, replying to
+// it as needed, and reports whether it did so. Other requests are left
+// for the caller to handle and reply to. Since variables must be set
+// before the command runs, "env" requests that arrive after an
+// "exec", "shell" or "subsystem" request are refused.
+func (e *SessionEnv) HandleRequest(req *Request) bool {
+	switch req.Type {
+	case "exec", "shell", "subsystem":
+		e.started = true
+		return false
+	case "env":
+	default:
+		return false
+	}
+
+	var msg setenvRequest
+	if err := Unmarshal(req.Payload, &msg); err != nil || e.started || !e.accept(msg.Name) {
+		req.Reply(false, nil)
+		return true
+	}
+
+	if e.vars == nil {
+		e.vars = make(map[string]int)
+	}
+	entry := msg.Name + "=" + msg.Value
+	if i, ok := e.vars[msg.Name]; ok {
+		e.environ[i] = entry
+	} else {
+		e.vars[msg.Name] = len(e.environ)
+		e.environ = append(e.environ, entry)
+	}
+	req.Reply(true, nil)
+	return true
+}
+
+func (e *SessionEnv) accept(name string) bool {
+	for _, pat := range e.AcceptEnv {
+		if matchPattern(pat, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns the value the client set for the variable name.
+func (e *SessionEnv) Lookup(name string) (value string, ok bool) {
+	i, ok := e.vars[name]
+	if !ok {
+		return "", false
+	}
+	return e.environ[i][len(name)+1:], true
+}
+
+// Environ returns the accepted variables as "key=value" strings in the
+// order the client first set them, as used by os/exec.Cmd.Env. A
+// variable that was set several times has its last value.
+func (e *SessionEnv) Environ() []string {
+	return append([]string(nil), e.environ...)
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"strings"
+	"testing"
+)
+
+func envHandler(ch Channel, in <-chan *Request, t *testing.T) {
+	defer ch.Close()
+	env := SessionEnv{AcceptEnv: []string{"LANG", "LC_*"}}
+	for req := range in {
+		if env.HandleRequest(req) {
+			continue
+		}
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+
+		// Variables can no longer be set once the command runs.
+		late := &Request{Type: "env", Payload: Marshal(&setenvRequest{"LANG", "late"})}
+		if !env.HandleRequest(late) {
+			t.Error("env request after exec was not handled")
+		}
+		if v, _ := env.Lookup("LANG"); v != "en_US.UTF-8" {
+			t.Errorf("got LANG=%q after late env request", v)
+		}
+
+		ch.Write([]byte(strings.Join(env.Environ(), " ")))
+		sendStatus(0, ch, t)
+		return
+	}
+}
+
+func TestSessionEnv(t *testing.T) {
+	conn := dial(envHandler, t)
+	defer conn.Close()
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("Unable to request new session: %v", err)
+	}
+	defer session.Close()
+
+	for _, v := range [][2]string{{"LANG", "C"}, {"LC_ALL", "C"}, {"LANG", "en_US.UTF-8"}} {
+		if err := session.Setenv(v[0], v[1]); err != nil {
+			t.Errorf("Setenv(%s): %v", v[0], err)
+		}
+	}
+	if err := session.Setenv("LD_PRELOAD", "evil.so"); err == nil {
+		t.Error("Setenv(LD_PRELOAD) was accepted")
+	}
+
+	out, err := session.Output("env")
+	if err != nil {
+		t.Fatalf("Output: %v", err)
+	}
+	if want := "LANG=en_US.UTF-8 LC_ALL=C"; string(out) != want {
+		t.Errorf("got environment %q, want %q", out, want)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// SOCKS5 protocol constants, see RFC 1928.
+const (
+	socks5Version = 5
+
+	socksAuthNone         = 0x00
+	socksAuthNoAcceptable = 0xff
+
+	socksCmdConnect = 1
+
+	socksAddrIPv4   = 1
+	socksAddrDomain = 3
+	socksAddrIPv6   = 4
+
+	socksReplySucceeded           = 0x00
+	socksReplyGeneralFailure      = 0x01
+	socksReplyNotAllowed          = 0x02
+	socksReplyHostUnreachable     = 0x04
+	socksReplyCommandNotSupported = 0x07
+	socksReplyAddrNotSupported    = 0x08
+)
+
+// SOCKSProxy is a local SOCKS5 proxy that tunnels the connections it
+// accepts through an SSH client, like the -D option of OpenSSH. Each
+// CONNECT request opens a direct-tcpip channel to the requested address,
+// which the server connects to. Only unauthenticated CONNECT requests
+// are supported.
+type SOCKSProxy struct {
+	client *Client
+	ln     net.Listener
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// NewSOCKSProxy listens on the TCP address listenAddr, and serves SOCKS5
+// requests there through client until Close is called. Anyone who can
+// connect to listenAddr can use the tunnel, so it should normally be a
+// loopback address.
+func NewSOCKSProxy(client *Client, listenAddr string) (*SOCKSProxy, error) {
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	p := &SOCKSProxy{
+		client: client,
+		ln:     ln,
+		conns:  make(map[net.Conn]struct{}),
+	}
+	p.wg.Add(1)
+	go p.serve()
+	return p, nil
+}
+
+// Addr returns the address the proxy listens on.
+func (p *SOCKSProxy) Addr() net.Addr {
+	return p.ln.Addr()
+}
+
+// Close stops accepting connections, closes the connections that are
+// being proxied and waits for their handlers to finish. The SSH client
+// is not closed.
+func (p *SOCKSProxy) Close() error {
+	err := p.ln.Close()
+	p.mu.Lock()
+	for c := range p.conns {
+		c.Close()
+	}
+	p.conns = nil
+	p.mu.Unlock()
+	p.wg.Wait()
+	return err
+}
+
+func (p *SOCKSProxy) serve() {
+	defer p.wg.Done()
+	for {
+		c, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		if !p.track(c) {
+			c.Close()
+			return
+		}
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			defer p.untrack(c)
+			p.handle(c)
+		}()
+	}
+}
+
+// track registers c to be closed by Close. It reports false if the
+// proxy is already closed.
+func (p *SOCKSProxy) track(c net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns == nil {
+		return false
+	}
+	p.conns[c] = struct{}{}
+	return true
+}
+
+// untrack closes c and forgets it.
+func (p *SOCKSProxy) untrack(c net.Conn) {
+	c.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns, c)
+}
+
+// handle negotiates a SOCKS5 CONNECT on c and then forwards it.
+func (p *SOCKSProxy) handle(c net.Conn) {
+	r := bufio.NewReader(c)
+	addr, err := readSOCKSRequest(r, c)
+	if err != nil {
+		return
+	}
+
+	target, err := p.client.Dial("tcp", addr)
+	if err != nil {
+		reply := byte(socksReplyGeneralFailure)
+		if e, ok := err.(*OpenChannelError); ok {
+			switch e.Reason {
+			case Prohibited:
+				reply = socksReplyNotAllowed
+			case ConnectionFailed:
+				reply = socksReplyHostUnreachable
+			}
+		}
+		writeSOCKSReply(c, reply)
+		return
+	}
+	if !p.track(target) {
+		target.Close()
+		return
+	}
+	defer p.untrack(target)
+	if err := writeSOCKSReply(c, socksReplySucceeded); err != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		// The client may have sent data right after its request.
+		io.Copy(target, r)
+		target.(*chanConn).CloseWrite()
+		close(done)
+	}()
+	io.Copy(c, target)
+	if tc, ok := c.(*net.TCPConn); ok {
+		tc.CloseWrite()
+	} else {
+		c.Close()
+	}
+	<-done
+}
+
+// readSOCKSRequest reads the method negotiation and the request of a
+// SOCKS5 client, and returns the address to connect to as host:port. It
+// answers requests it cannot serve on w.
+func readSOCKSRequest(r *bufio.Reader, w io.Writer) (string, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return "", err
+	}
+	if hdr[0] != socks5Version {
+		return "", fmt.Errorf("ssh: unsupported SOCKS version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return "", err
+	}
+	method := byte(socksAuthNoAcceptable)
+	for _, m := range methods {
+		if m == socksAuthNone {
+			method = socksAuthNone
+		}
+	}
+	if _, err := w.Write([]byte{socks5Version, method}); err != nil {
+		return "", err
+	}
+	if method == socksAuthNoAcceptable {
+		return "", errors.New("ssh: SOCKS client requires authentication")
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(r, req[:]); err != nil {
+		return "", err
+	}
+	if req[0] != socks5Version {
+		return "", fmt.Errorf("ssh: unsupported SOCKS version %d", req[0])
+	}
+	if req[1] != socksCmdConnect {
+		writeSOCKSReply(w, socksReplyCommandNotSupported)
+		return "", fmt.Errorf("ssh: unsupported SOCKS command %d", req[1])
+	}
+
+	var host string
+	switch req[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if req[3] == socksAddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		n, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		name := make([]byte, n)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		writeSOCKSReply(w, socksReplyAddrNotSupported)
+		return "", fmt.Errorf("ssh: unsupported SOCKS address type %d", req[3])
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// writeSOCKSReply sends a reply with the given code. As the connection
+// is tunneled, there is no meaningful bound address to report.
+func writeSOCKSReply(w io.Writer, reply byte) error {
+	_, err := w.Write([]byte{socks5Version, reply, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,158 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// socksTestClient returns a Client whose server answers direct-tcpip
+// channels with a greeting naming the target, and then echoes. Targets
+// on the host "forbidden.example" are refused.
+func socksTestClient(t *testing.T) *Client {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+
+	serverConf := &ServerConfig{
+		NoClientAuth: true,
+	}
+	serverConf.AddHostKey(testSigners["rsa"])
+	go func() {
+		_, chans, reqs, err := NewServerConn(c1, serverConf)
+		if err != nil {
+			return
+		}
+		go DiscardRequests(reqs)
+		for newCh := range chans {
+			var target struct {
+				Raddr string
+				Rport uint32
+				Laddr string
+				Lport uint32
+			}
+			if newCh.ChannelType() != "direct-tcpip" || Unmarshal(newCh.ExtraData(), &target) != nil {
+				newCh.Reject(UnknownChannelType, "")
+				continue
+			}
+			if target.Raddr == "forbidden.example" {
+				newCh.Reject(Prohibited, "")
+				continue
+			}
+			ch, in, err := newCh.Accept()
+			if err != nil {
+				continue
+			}
+			go DiscardRequests(in)
+			go func() {
+				defer ch.Close()
+				fmt.Fprintf(ch, "hello %s\n", net.JoinHostPort(target.Raddr, fmt.Sprint(target.Rport)))
+				io.Copy(ch, ch)
+			}()
+		}
+	}()
+
+	conn, chans, reqs, err := NewClientConn(c2, "", &ClientConfig{
+		User:            "user",
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+	return NewClient(conn, chans, reqs)
+}
+
+// socksConnect sends a CONNECT request for the address of type addrType,
+// and returns the reply code.
+func socksConnect(t *testing.T, c net.Conn, r *bufio.Reader, addrType byte, addr []byte, port uint16) byte {
+	req := []byte{socks5Version, 1, socksAuthNone}
+	req = append(req, socks5Version, socksCmdConnect, 0, addrType)
+	req = append(req, addr...)
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := c.Write(req); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// The method selection, and the reply with its bound address.
+	var reply [12]byte
+	if _, err := io.ReadFull(r, reply[:]); err != nil {
+		t.Fatalf("reading SOCKS replies: %v", err)
+	}
+	if reply[0] != socks5Version || reply[1] != socksAuthNone {
+		t.Fatalf("got method selection %x, want no authentication", reply[:2])
+	}
+	return reply[3]
+}
+
+func TestSOCKSProxy(t *testing.T) {
+	client := socksTestClient(t)
+	defer client.Close()
+
+	p, err := NewSOCKSProxy(client, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewSOCKSProxy: %v", err)
+	}
+	defer p.Close()
+
+	for _, tt := range []struct {
+		addrType byte
+		addr     []byte
+		want     string
+	}{
+		{socksAddrDomain, append([]byte{11}, "example.com"...), "example.com:80"},
+		{socksAddrIPv4, []byte{192, 0, 2, 1}, "192.0.2.1:80"},
+		{socksAddrIPv6, net.ParseIP("2001:db8::1"), "[2001:db8::1]:80"},
+	} {
+		c, err := net.Dial("tcp", p.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		r := bufio.NewReader(c)
+		if reply := socksConnect(t, c, r, tt.addrType, tt.addr, 80); reply != socksReplySucceeded {
+			t.Errorf("%s: got reply %d, want success", tt.want, reply)
+			c.Close()
+			continue
+		}
+		greeting, err := r.ReadString('\n')
+		if err != nil || greeting != "hello "+tt.want+"\n" {
+			t.Errorf("got greeting %q, %v, want one for %s", greeting, err, tt.want)
+		}
+		c.Write([]byte("ping"))
+		c.(*net.TCPConn).CloseWrite()
+		if echo, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(echo, []byte("ping")) {
+			t.Errorf("got echo %q, %v, want \"ping\"", echo, err)
+		}
+		c.Close()
+	}
+}
+
+func TestSOCKSProxyRefused(t *testing.T) {
+	client := socksTestClient(t)
+	defer client.Close()
+
+	p, err := NewSOCKSProxy(client, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewSOCKSProxy: %v", err)
+	}
+	defer p.Close()
+
+	c, err := net.Dial("tcp", p.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	r := bufio.NewReader(c)
+	host := append([]byte{17}, "forbidden.example"...)
+	if reply := socksConnect(t, c, r, socksAddrDomain, host, 22); reply != socksReplyNotAllowed {
+		t.Errorf("got reply %d, want %d", reply, socksReplyNotAllowed)
+	}
+}
@@ -19,7 +19,10 @@ import (
 // Listen requests the remote peer open a listening socket on
 // addr. Incoming connections will be available by calling Accept on
 // the returned net.Listener. The listener must be serviced, or the
-// SSH connection may hang.
+// SSH connection may hang. For TCP, the LocalAddr of an accepted
+// connection is the forwarded address on the remote side and its
+// RemoteAddr is the address the connection originates from, as
+// reported by the peer.
 // N must be "tcp", "tcp4", "tcp6", or "unix".
 func (c *Client) Listen(n, addr string) (net.Listener, error) {
 	switch n {
@@ -182,6 +185,19 @@ func parseTCPAddr(addr string, port uint32) (*net.TCPAddr, error) {
 	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
 }
 
+// parseOriginAddr is like parseTCPAddr, but accepts port 0, which
+// servers send when the originating port is not known.
+func parseOriginAddr(addr string, port uint32) (*net.TCPAddr, error) {
+	if port == 0 {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("ssh: cannot parse IP address %q", addr)
+		}
+		return &net.TCPAddr{IP: ip}, nil
+	}
+	return parseTCPAddr(addr, port)
+}
+
 func (l *forwardList) handleChannels(in <-chan NewChannel) {
 	for ch := range in {
 		var (
@@ -207,7 +223,7 @@ func (l *forwardList) handleChannels(in <-chan NewChannel) {
 				ch.Reject(ConnectionFailed, err.Error())
 				continue
 			}
-			raddr, err = parseTCPAddr(payload.OriginAddr, payload.OriginPort)
+			raddr, err = parseOriginAddr(payload.OriginAddr, payload.OriginPort)
 			if err != nil {
 				ch.Reject(ConnectionFailed, err.Error())
 				continue
@@ -241,17 +257,29 @@ func (l *forwardList) handleChannels(in <-chan NewChannel) {
 }
 
 // remove removes the forward entry, and the channel feeding its
-// listener.
-func (l *forwardList) remove(addr net.Addr) {
+// listener. It reports whether there was such an entry.
+func (l *forwardList) remove(addr net.Addr) bool {
 	l.Lock()
 	defer l.Unlock()
 	for i, f := range l.entries {
 		if addr.Network() == f.laddr.Network() && addr.String() == f.laddr.String() {
 			l.entries = append(l.entries[:i], l.entries[i+1:]...)
 			close(f.c)
-			return
+			return true
 		}
 	}
+	return false
+}
+
+// addrs returns the addresses of the current entries.
+func (l *forwardList) addrs() []net.Addr {
+	l.Lock()
+	defer l.Unlock()
+	addrs := make([]net.Addr, 0, len(l.entries))
+	for _, f := range l.entries {
+		addrs = append(addrs, f.laddr)
+	}
+	return addrs
 }
 
 // closeAll closes and clears all forwards.
@@ -276,6 +304,80 @@ func (l *forwardList) forward(laddr, raddr net.Addr, ch NewChannel) bool {
 	return false
 }
 
+// ForwardInfo describes a remote forward that is active on a Client.
+type ForwardInfo struct {
+	// Type is the global request that set up the forward,
+	// "tcpip-forward" or "streamlocal-forward@openssh.com".
+	Type string
+
+	// Addr is the address the server listens on, a *net.TCPAddr
+	// or a *net.UnixAddr.
+	Addr net.Addr
+}
+
+// Forwards returns the remote forwards that were set up with Listen,
+// ListenTCP or ListenUnix and whose listeners are still open, in the
+// order they were set up.
+func (c *Client) Forwards() []ForwardInfo {
+	var infos []ForwardInfo
+	for _, addr := range c.forwards.addrs() {
+		info := ForwardInfo{Type: "tcpip-forward", Addr: addr}
+		if _, ok := addr.(*net.UnixAddr); ok {
+			info.Type = "streamlocal-forward@openssh.com"
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// CancelAllForwards asks the server to stop every forward returned by
+// Forwards, and closes their listeners, as if Close had been called on
+// each; a later Close on one of them returns an error. All the forwards
+// are cancelled even if some requests fail; the first error is
+// returned.
+func (c *Client) CancelAllForwards() error {
+	var firstErr error
+	for _, addr := range c.forwards.addrs() {
+		// A listener closed meanwhile is not a failure.
+		if err := c.cancelForward(addr); err != nil && err != errForwardCancelled && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// errForwardCancelled is returned when closing the listener of a
+// forward that was cancelled already.
+var errForwardCancelled = errors.New("ssh: forward already cancelled")
+
+// cancelForward closes the listener for the forward on addr and sends
+// the matching cancel request. For forwards that are already
+// cancelled, nothing is sent and errForwardCancelled is returned.
+func (c *Client) cancelForward(addr net.Addr) error {
+	// this also closes the listener.
+	if !c.forwards.remove(addr) {
+		return errForwardCancelled
+	}
+
+	var reqType string
+	var payload []byte
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		reqType = "cancel-tcpip-forward"
+		payload = Marshal(&channelForwardMsg{addr.IP.String(), uint32(addr.Port)})
+	case *net.UnixAddr:
+		reqType = "cancel-streamlocal-forward@openssh.com"
+		payload = Marshal(&streamLocalChannelForwardMsg{addr.Name})
+	default:
+		return fmt.Errorf("ssh: unsupported forward address %v", addr)
+	}
+	ok, _, err := c.SendRequest(reqType, true, payload)
+	if err == nil && !ok {
+		err = fmt.Errorf("ssh: %s failed", reqType)
+	}
+	return err
+}
+
 type tcpListener struct {
 	laddr *net.TCPAddr
 
@@ -302,20 +404,10 @@ func (l *tcpListener) Accept() (net.Conn, error) {
 	}, nil
 }
 
-// Close closes the listener.
+// Close closes the listener. Closing it again, or after
+// CancelAllForwards, returns an error.
 func (l *tcpListener) Close() error {
-	m := channelForwardMsg{
-		l.laddr.IP.String(),
-		uint32(l.laddr.Port),
-	}
-
-	// this also closes the listener.
-	l.conn.forwards.remove(l.laddr)
-	ok, _, err := l.conn.SendRequest("cancel-tcpip-forward", true, Marshal(&m))
-	if err == nil && !ok {
-		err = errors.New("ssh: cancel-tcpip-forward failed")
-	}
-	return err
+	return l.conn.cancelForward(l.laddr)
 }
 
 // Addr returns the listener's network address.
@@ -5,6 +5,9 @@
 package ssh
 
 import (
+	"io"
+	"net"
+	"strings"
 	"testing"
 )
 
@@ -18,3 +21,182 @@ func TestAutoPortListenBroken(t *testing.T) {
 		t.Errorf("version %q marked as broken", works)
 	}
 }
+
+func TestForwardedTCPIPAddrs(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	serverConf := &ServerConfig{
+		NoClientAuth: true,
+	}
+	serverConf.AddHostKey(testSigners["rsa"])
+
+	origins := []forwardedTCPPayload{
+		{"127.0.0.1", 8022, "192.0.2.7", 4242},
+		// The origin port may be unknown.
+		{"127.0.0.1", 8022, "2001:db8::1", 0},
+	}
+	listening := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, chans, reqs, err := NewServerConn(c1, serverConf)
+		if err != nil {
+			t.Errorf("NewServerConn: %v", err)
+			return
+		}
+		go func() {
+			for newCh := range chans {
+				newCh.Reject(Prohibited, "")
+			}
+		}()
+		req := <-reqs
+		req.Reply(req.Type == "tcpip-forward", nil)
+		go DiscardRequests(reqs)
+
+		// The client registers the forward only after it has seen
+		// the reply.
+		<-listening
+		for _, payload := range origins {
+			ch, in, err := conn.OpenChannel("forwarded-tcpip", Marshal(&payload))
+			if err != nil {
+				t.Errorf("OpenChannel: %v", err)
+				return
+			}
+			go DiscardRequests(in)
+			ch.Close()
+		}
+	}()
+
+	clientConf := &ClientConfig{
+		User:            "user",
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	}
+	conn, chans, reqs, err := NewClientConn(c2, "", clientConf)
+	if err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+	client := NewClient(conn, chans, reqs)
+	defer client.Close()
+
+	l, err := client.Listen("tcp", "127.0.0.1:8022")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	close(listening)
+	for _, want := range origins {
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatalf("Accept: %v", err)
+		}
+		if got := c.LocalAddr().String(); got != "127.0.0.1:8022" {
+			t.Errorf("got LocalAddr %s, want 127.0.0.1:8022", got)
+		}
+		raddr, ok := c.RemoteAddr().(*net.TCPAddr)
+		if !ok || !raddr.IP.Equal(net.ParseIP(want.OriginAddr)) || raddr.Port != int(want.OriginPort) {
+			t.Errorf("got RemoteAddr %v, want %s port %d", c.RemoteAddr(), want.OriginAddr, want.OriginPort)
+		}
+		c.Close()
+	}
+	<-done
+}
+
+func TestCancelAllForwards(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	serverConf := &ServerConfig{
+		NoClientAuth: true,
+	}
+	serverConf.AddHostKey(testSigners["rsa"])
+
+	// The server grants every request and records the cancellations.
+	cancelled := make(chan string, 10)
+	go func() {
+		_, chans, reqs, err := NewServerConn(c1, serverConf)
+		if err != nil {
+			t.Errorf("NewServerConn: %v", err)
+			return
+		}
+		go func() {
+			for newCh := range chans {
+				newCh.Reject(Prohibited, "")
+			}
+		}()
+		for req := range reqs {
+			if strings.HasPrefix(req.Type, "cancel-") {
+				cancelled <- req.Type
+			}
+			req.Reply(true, nil)
+		}
+		close(cancelled)
+	}()
+
+	conn, chans, reqs, err := NewClientConn(c2, "", &ClientConfig{
+		User:            "user",
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+	client := NewClient(conn, chans, reqs)
+	defer client.Close()
+
+	tl, err := client.Listen("tcp", "127.0.0.1:8022")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	ul, err := client.Listen("unix", "/tmp/forward.sock")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	closed, err := client.Listen("tcp", "127.0.0.1:8023")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	closed.Close()
+	if got := <-cancelled; got != "cancel-tcpip-forward" {
+		t.Fatalf("got request %q, want cancel-tcpip-forward", got)
+	}
+
+	fwds := client.Forwards()
+	if len(fwds) != 2 ||
+		fwds[0].Type != "tcpip-forward" || fwds[0].Addr.String() != "127.0.0.1:8022" ||
+		fwds[1].Type != "streamlocal-forward@openssh.com" || fwds[1].Addr.String() != "/tmp/forward.sock" {
+		t.Fatalf("got forwards %v, want the open tcp and unix ones", fwds)
+	}
+
+	if err := client.CancelAllForwards(); err != nil {
+		t.Fatalf("CancelAllForwards: %v", err)
+	}
+	for _, want := range []string{"cancel-tcpip-forward", "cancel-streamlocal-forward@openssh.com"} {
+		if got := <-cancelled; got != want {
+			t.Errorf("got request %q, want %q", got, want)
+		}
+	}
+	if fwds := client.Forwards(); len(fwds) != 0 {
+		t.Errorf("got forwards %v after CancelAllForwards", fwds)
+	}
+	for _, l := range []net.Listener{tl, ul} {
+		if _, err := l.Accept(); err != io.EOF {
+			t.Errorf("Accept on %v: got %v, want io.EOF", l.Addr(), err)
+		}
+		// The forward is gone already, so nothing is sent.
+		if err := l.Close(); err == nil {
+			t.Errorf("Close on %v after CancelAllForwards succeeded", l.Addr())
+		}
+	}
+
+	client.Close()
+	if got, ok := <-cancelled; ok {
+		t.Errorf("got request %q after closing the listeners", got)
+	}
+}
@@ -44,6 +44,22 @@ type Terminal struct {
 	// and the new cursor position.
 	AutoCompleteCallback func(line string, pos int, key rune) (newLine string, newPos int, ok bool)
 
+	// ControlKeyCallback, if non-null, is called for each control
+	// character that is typed, other than Enter, such as 1 for Ctrl-A
+	// or 9 for Tab. It is called before the key bindings are applied,
+	// so it can override them. Its arguments and results are those of
+	// AutoCompleteCallback; if it returns ok=false, the key press is
+	// processed normally.
+	//
+	// The default bindings follow the emacs mode of readline: Ctrl-A
+	// and Ctrl-E move to the start and end of the line, Ctrl-B and
+	// Ctrl-F move by a character, Alt-B and Alt-F by a word, and Ctrl-P
+	// and Ctrl-N through the history. Ctrl-K kills the rest of the line,
+	// Ctrl-U its start, Ctrl-W and Alt-Backspace the previous word and
+	// Alt-D the next one, and Ctrl-Y yanks the text killed last.
+	// Consecutive kills are yanked together.
+	ControlKeyCallback func(line string, pos int, key rune) (newLine string, newPos int, ok bool)
+
 	// Escape contains a pointer to the escape codes for this terminal.
 	// It's always a valid pointer, although the escape codes themselves
 	// may be empty if the terminal doesn't support them.
@@ -92,6 +108,12 @@ type Terminal struct {
 	// the incomplete, initial line. That value is stored in
 	// historyPending.
 	historyPending string
+
+	// killBuffer holds the text killed last, which is yanked by
+	// Ctrl-Y. killing is true if the last key press killed text, in
+	// which case the next kill adds to killBuffer.
+	killBuffer []rune
+	killing    bool
 }
 
 // NewTerminal runs a VT100 terminal on the given ReadWriter. If the ReadWriter is
@@ -126,12 +148,30 @@ const (
 	keyHome
 	keyEnd
 	keyDeleteWord
+	keyDeleteWordForward
 	keyDeleteLine
+	keyYank
 	keyClearScreen
 	keyPasteStart
 	keyPasteEnd
 )
 
+// keyBindings maps the control characters that edit the line to the
+// keys they stand for.
+var keyBindings = map[rune]rune{
+	1:  keyHome,        // ^A
+	2:  keyLeft,        // ^B
+	5:  keyEnd,         // ^E
+	6:  keyRight,       // ^F
+	8:  keyBackspace,   // ^H
+	11: keyDeleteLine,  // ^K
+	12: keyClearScreen, // ^L
+	14: keyDown,        // ^N
+	16: keyUp,          // ^P
+	23: keyDeleteWord,  // ^W
+	25: keyYank,        // ^Y
+}
+
 var (
 	crlf       = []byte{'\r', '\n'}
 	pasteStart = []byte{keyEscape, '[', '2', '0', '0', '~'}
@@ -145,23 +185,6 @@ func bytesToKey(b []byte, pasteActive bool) (rune, []byte) {
 		return utf8.RuneError, nil
 	}
 
-	if !pasteActive {
-		switch b[0] {
-		case 1: // ^A
-			return keyHome, b[1:]
-		case 5: // ^E
-			return keyEnd, b[1:]
-		case 8: // ^H
-			return keyBackspace, b[1:]
-		case 11: // ^K
-			return keyDeleteLine, b[1:]
-		case 12: // ^L
-			return keyClearScreen, b[1:]
-		case 23: // ^W
-			return keyDeleteWord, b[1:]
-		}
-	}
-
 	if b[0] != keyEscape {
 		if !utf8.FullRune(b) {
 			return utf8.RuneError, b
@@ -170,6 +193,20 @@ func bytesToKey(b []byte, pasteActive bool) (rune, []byte) {
 		return r, b[l:]
 	}
 
+	// Alt-key combinations are sent as an escape followed by the key.
+	if !pasteActive && len(b) >= 2 {
+		switch b[1] {
+		case 'b':
+			return keyAltLeft, b[2:]
+		case 'f':
+			return keyAltRight, b[2:]
+		case 'd':
+			return keyDeleteWordForward, b[2:]
+		case keyBackspace:
+			return keyDeleteWord, b[2:]
+		}
+	}
+
 	if !pasteActive && len(b) >= 3 && b[0] == keyEscape && b[1] == '[' {
 		switch b[2] {
 		case 'A':
@@ -237,7 +274,7 @@ func (t *Terminal) moveCursorToPos(pos int) {
 		return
 	}
 
-	x := visualLength(t.prompt) + pos
+	x := visualLength(t.prompt) + runesWidth(t.line[:pos])
 	y := x / t.termWidth
 	x = x % t.termWidth
 
@@ -308,13 +345,13 @@ func (t *Terminal) setLine(newLine []rune, newPos int) {
 	if t.echo {
 		t.moveCursorToPos(0)
 		t.writeLine(newLine)
-		for i := len(newLine); i < len(t.line); i++ {
+		for i := runesWidth(newLine); i < runesWidth(t.line); i++ {
 			t.writeLine(space)
 		}
-		t.moveCursorToPos(newPos)
 	}
 	t.line = newLine
 	t.pos = newPos
+	t.moveCursorToPos(newPos)
 }
 
 func (t *Terminal) advanceCursor(places int) {
@@ -351,14 +388,15 @@ func (t *Terminal) eraseNPreviousChars(n int) {
 	t.pos -= n
 	t.moveCursorToPos(t.pos)
 
+	width := runesWidth(t.line[t.pos : t.pos+n])
 	copy(t.line[t.pos:], t.line[n+t.pos:])
 	t.line = t.line[:len(t.line)-n]
 	if t.echo {
 		t.writeLine(t.line[t.pos:])
-		for i := 0; i < n; i++ {
+		for i := 0; i < width; i++ {
 			t.queue(space)
 		}
-		t.advanceCursor(n)
+		t.advanceCursor(width)
 		t.moveCursorToPos(t.pos)
 	}
 }
@@ -407,7 +445,74 @@ func (t *Terminal) countToRightWord() int {
 	return pos - t.pos
 }
 
-// visualLength returns the number of visible glyphs in s.
+// countToLeftChar returns the number of runes from the cursor to the start
+// of the previous character, which includes its combining marks.
+func (t *Terminal) countToLeftChar() int {
+	pos := t.pos
+	for pos > 0 {
+		pos--
+		if runeWidth(t.line[pos]) != 0 {
+			break
+		}
+	}
+	return t.pos - pos
+}
+
+// countToRightChar returns the number of runes from the cursor to the start
+// of the next character, skipping the combining marks of the current one.
+func (t *Terminal) countToRightChar() int {
+	pos := t.pos
+	if pos < len(t.line) {
+		pos++
+	}
+	for pos < len(t.line) && runeWidth(t.line[pos]) == 0 {
+		pos++
+	}
+	return pos - t.pos
+}
+
+// countToEndOfWord returns the number of characters from the cursor to the
+// end of the current or next word.
+func (t *Terminal) countToEndOfWord() int {
+	pos := t.pos
+	for pos < len(t.line) {
+		if t.line[pos] != ' ' {
+			break
+		}
+		pos++
+	}
+	for pos < len(t.line) {
+		if t.line[pos] == ' ' {
+			break
+		}
+		pos++
+	}
+	return pos - t.pos
+}
+
+// kill records the n characters before the cursor, or after it if n is
+// negative, in the kill buffer. They are added to the text killed by the
+// previous key press, if any.
+func (t *Terminal) kill(wasKilling bool, n int) {
+	var killed []rune
+	if n >= 0 {
+		killed = t.line[t.pos-n : t.pos]
+	} else {
+		killed = t.line[t.pos : t.pos-n]
+	}
+	switch {
+	case !wasKilling:
+		t.killBuffer = append([]rune(nil), killed...)
+	case n >= 0:
+		t.killBuffer = append(append([]rune(nil), killed...), t.killBuffer...)
+	default:
+		t.killBuffer = append(t.killBuffer, killed...)
+	}
+	t.killing = true
+}
+
+// visualLength returns the number of columns taken up by the visible glyphs
+// in s.
 func visualLength(runes []rune) int {
 	inEscapeSeq := false
 	length := 0
@@ -421,7 +526,7 @@ func visualLength(runes []rune) int {
 		case r == '\x1b':
 			inEscapeSeq = true
 		default:
-			length++
+			length += runeWidth(r)
 		}
 	}
 
@@ -436,12 +541,30 @@ func (t *Terminal) handleKey(key rune) (line string, ok bool) {
 		return
 	}
 
+	if key < ' ' && key != keyEnter && t.ControlKeyCallback != nil {
+		t.lock.Unlock()
+		newLine, newPos, handled := t.ControlKeyCallback(string(t.line), len(string(t.line[:t.pos])), key)
+		t.lock.Lock()
+
+		if handled {
+			t.killing = false
+			t.setLine([]rune(newLine), utf8.RuneCount([]byte(newLine)[:newPos]))
+			return
+		}
+	}
+	if bound, ok := keyBindings[key]; ok {
+		key = bound
+	}
+
+	wasKilling := t.killing
+	t.killing = false
+
 	switch key {
 	case keyBackspace:
 		if t.pos == 0 {
 			return
 		}
-		t.eraseNPreviousChars(1)
+		t.eraseNPreviousChars(t.countToLeftChar())
 	case keyAltLeft:
 		// move left by a word.
 		t.pos -= t.countToLeftWord()
@@ -454,13 +577,13 @@ func (t *Terminal) handleKey(key rune) (line string, ok bool) {
 		if t.pos == 0 {
 			return
 		}
-		t.pos--
+		t.pos -= t.countToLeftChar()
 		t.moveCursorToPos(t.pos)
 	case keyRight:
 		if t.pos == len(t.line) {
 			return
 		}
-		t.pos++
+		t.pos += t.countToRightChar()
 		t.moveCursorToPos(t.pos)
 	case keyHome:
 		if t.pos == 0 {
@@ -513,11 +636,21 @@ func (t *Terminal) handleKey(key rune) (line string, ok bool) {
 		t.maxLine = 0
 	case keyDeleteWord:
 		// Delete zero or more spaces and then one or more characters.
-		t.eraseNPreviousChars(t.countToLeftWord())
+		n := t.countToLeftWord()
+		t.kill(wasKilling, n)
+		t.eraseNPreviousChars(n)
+	case keyDeleteWordForward:
+		// Delete zero or more spaces and then one or more characters
+		// after the cursor.
+		n := t.countToEndOfWord()
+		t.kill(wasKilling, -n)
+		t.pos += n
+		t.eraseNPreviousChars(n)
 	case keyDeleteLine:
 		// Delete everything from the current cursor position to the
 		// end of line.
-		for i := t.pos; i < len(t.line); i++ {
+		t.kill(wasKilling, t.pos-len(t.line))
+		for i := runesWidth(t.line[t.pos:]); i > 0; i-- {
 			t.queue(space)
 			t.advanceCursor(1)
 		}
@@ -528,11 +661,22 @@ func (t *Terminal) handleKey(key rune) (line string, ok bool) {
 		// The EOF case when the line is empty is handled in
 		// readLine().
 		if t.pos < len(t.line) {
-			t.pos++
-			t.eraseNPreviousChars(1)
+			n := t.countToRightChar()
+			t.pos += n
+			t.eraseNPreviousChars(n)
 		}
 	case keyCtrlU:
+		t.kill(wasKilling, t.pos)
 		t.eraseNPreviousChars(t.pos)
+	case keyYank:
+		if len(t.line)+len(t.killBuffer) > maxLineLength {
+			return
+		}
+		newLine := make([]rune, 0, len(t.line)+len(t.killBuffer))
+		newLine = append(newLine, t.line[:t.pos]...)
+		newLine = append(newLine, t.killBuffer...)
+		newLine = append(newLine, t.line[t.pos:]...)
+		t.setLine(newLine, t.pos+len(t.killBuffer))
 	case keyClearScreen:
 		// Erases the screen and moves the cursor to the home position.
 		t.queue([]rune("\x1b[2J\x1b[H"))
@@ -586,12 +730,18 @@ func (t *Terminal) addKeyToLine(key rune) {
 func (t *Terminal) writeLine(line []rune) {
 	for len(line) != 0 {
 		remainingOnLine := t.termWidth - t.cursorX
-		todo := len(line)
-		if todo > remainingOnLine {
-			todo = remainingOnLine
+		todo, width := 0, 0
+		for todo < len(line) && width+runeWidth(line[todo]) <= remainingOnLine {
+			width += runeWidth(line[todo])
+			todo++
+		}
+		if todo == 0 {
+			// A wide character does not fit in the last column, and
+			// the terminal wraps it to the next line.
+			todo, width = 1, runeWidth(line[0])
 		}
 		t.queue(line[:todo])
-		t.advanceCursor(visualLength(line[:todo]))
+		t.advanceCursor(width)
 		line = line[todo:]
 	}
 }
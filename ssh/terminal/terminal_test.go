@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"io"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -165,6 +166,21 @@ var keyPressTests = []struct {
 		line:           "£",
 		throwAwayLines: 1,
 	},
+	{
+		// Backspace erases a character along with its combining marks.
+		in:   "ae\u0301\177\r",
+		line: "a",
+	},
+	{
+		// Left moves over a character and its combining marks.
+		in:   "ae\u0301\x1b[D\x1b[DX\r",
+		line: "Xae\u0301",
+	},
+	{
+		// Ctrl-D erases a character along with its combining marks.
+		in:   "e\u0301a\001\004\r",
+		line: "a",
+	},
 	{
 		// Ctrl-D at the end of the line should be ignored.
 		in:   "a\004\r",
@@ -181,6 +197,63 @@ var keyPressTests = []struct {
 		in:   "abcd\x1b[D\x1b[D\025\r",
 		line: "cd",
 	},
+	{
+		// Ctrl-B twice moves back over two characters.
+		in:   "abc\002\002X\r",
+		line: "aXbc",
+	},
+	{
+		// Ctrl-A, then Ctrl-F moves forward a character.
+		in:   "abc\001\006X\r",
+		line: "aXbc",
+	},
+	{
+		// Alt-B moves back a word.
+		in:   "one two\x1bbX\r",
+		line: "one Xtwo",
+	},
+	{
+		// Alt-F moves forward a word, like Alt-Right.
+		in:   "one two\001\x1bfX\r",
+		line: "one Xtwo",
+	},
+	{
+		// Alt-D kills the next word.
+		in:   "one two\001\x1bd\r",
+		line: " two",
+	},
+	{
+		// Alt-Backspace kills the previous word.
+		in:   "one two\x1b\177\r",
+		line: "one ",
+	},
+	{
+		// Ctrl-W, Ctrl-A, then Ctrl-Y yanks the killed word.
+		in:   "one two\027\001\031\r",
+		line: "twoone ",
+	},
+	{
+		// Consecutive kills are yanked together.
+		in:   "one two three\027\027\031\r",
+		line: "one two three",
+	},
+	{
+		// Ctrl-K kills the line, which can be yanked repeatedly.
+		in:   "a b\001\013\031\031\r",
+		line: "a ba b",
+	},
+	{
+		// Ctrl-P recalls the previous line.
+		in:             "line1\rline2\020\r",
+		line:           "line1",
+		throwAwayLines: 1,
+	},
+	{
+		// Ctrl-P then Ctrl-N returns to the pending line.
+		in:             "line1\rline2\020\016\r",
+		line:           "line2",
+		throwAwayLines: 1,
+	},
 	{
 		// Bracketed paste mode: control sequences should be returned
 		// verbatim in paste mode.
@@ -228,6 +301,83 @@ func TestKeyPresses(t *testing.T) {
 	}
 }
 
+func TestControlKeyCallback(t *testing.T) {
+	c := &MockTerminal{
+		toSend: []byte("abc\001\003d\t\r"),
+	}
+	ss := NewTerminal(c, "> ")
+	var keys []rune
+	ss.ControlKeyCallback = func(line string, pos int, key rune) (string, int, bool) {
+		keys = append(keys, key)
+		switch key {
+		case 1:
+			// Override Ctrl-A to move to the end of the line instead.
+			return line, len(line), true
+		case 3:
+			return "[" + line + "]", pos + 1, true
+		}
+		return "", 0, false
+	}
+	ss.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key == '\t' {
+			return line + "ef", pos + 2, true
+		}
+		return "", 0, false
+	}
+
+	line, err := ss.ReadLine()
+	if err != nil {
+		t.Fatalf("ReadLine: %v", err)
+	}
+	if want := "[abcd]ef"; line != want {
+		t.Errorf("got line %q, want %q", line, want)
+	}
+	if want := []rune{1, 3, '\t'}; string(keys) != string(want) {
+		t.Errorf("got control keys %q, want %q", keys, want)
+	}
+}
+
+func TestWideCharacters(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string // the end of the output
+	}{
+		// Moving left over a wide character moves two columns.
+		{"日本\x1b[D", "\x1b[D\x1b[D"},
+		// Moving left over a combining mark and its base moves one.
+		{"e\u0301\x1b[D", "\x1b[D"},
+		// Backspace over a wide character erases two columns.
+		{"a日\177", "\x1b[D\x1b[D  \x1b[D\x1b[D"},
+	} {
+		c := &MockTerminal{toSend: []byte(test.in)}
+		ss := NewTerminal(c, "> ")
+		ss.ReadLine()
+
+		if got := string(c.received); !strings.HasSuffix(got, test.want) {
+			t.Errorf("%q: got output %q, want it to end with %q", test.in, got, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		r     rune
+		width int
+	}{
+		{'a', 1},
+		{'é', 1},
+		{'\u0301', 0},
+		{'\u200d', 0},
+		{'日', 2},
+		{'한', 2},
+		{'Ａ', 2},
+		{'😀', 2},
+		{'\U00020000', 2},
+	} {
+		if got := runeWidth(test.r); got != test.width {
+			t.Errorf("runeWidth(%q) = %d, want %d", test.r, got, test.width)
+		}
+	}
+}
+
 func TestPasswordNotSaved(t *testing.T) {
 	c := &MockTerminal{
 		toSend:       []byte("password\r\x1b[A\r"),
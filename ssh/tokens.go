@@ -0,0 +1,95 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TokenVars holds the values of the tokens that ExpandTokens replaces. See
+// the TOKENS section of ssh_config(5).
+type TokenVars struct {
+	RemoteHost   string // %h, the host name to connect to
+	OriginalHost string // %n, the host name as given by the user
+	Port         int    // %p, the port to connect to
+	RemoteUser   string // %r, the user to log in as
+	LocalUser    string // %u, the local user name
+	LocalHost    string // %l, the local host name; %L is its first component
+	HomeDir      string // %d, the home directory of the local user
+	UID          string // %i, the local user ID
+	HostKeyAlias string // %k, the host key alias, or the original host name
+}
+
+// ExpandTokens replaces the tokens in template, such as "%h" or "%r", with
+// their values in vars, as OpenSSH does in ProxyCommand, IdentityFile,
+// UserKnownHostsFile and similar options. "%%" stands for a literal '%',
+// and "%C" for the hex-encoded SHA-1 hash of "%l%h%p%r". It is an error
+// for template to contain an unknown token, or a token that has no value in
+// vars, so that a missing value cannot silently point a path elsewhere.
+func ExpandTokens(template string, vars TokenVars) (string, error) {
+	var out bytes.Buffer
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c != '%' {
+			out.WriteByte(c)
+			continue
+		}
+		i++
+		if i == len(template) {
+			return "", fmt.Errorf("ssh: invalid trailing %% in %q", template)
+		}
+
+		var value string
+		switch token := template[i]; token {
+		case '%':
+			value = "%"
+		case 'h':
+			value = vars.RemoteHost
+		case 'n':
+			value = vars.OriginalHost
+		case 'p':
+			if vars.Port > 0 {
+				value = strconv.Itoa(vars.Port)
+			}
+		case 'r':
+			value = vars.RemoteUser
+		case 'u':
+			value = vars.LocalUser
+		case 'l':
+			value = vars.LocalHost
+		case 'L':
+			value = vars.LocalHost
+			if i := strings.IndexByte(value, '.'); i >= 0 {
+				value = value[:i]
+			}
+		case 'd':
+			value = vars.HomeDir
+		case 'i':
+			value = vars.UID
+		case 'k':
+			value = vars.HostKeyAlias
+			if value == "" {
+				value = vars.OriginalHost
+			}
+		case 'C':
+			if vars.LocalHost != "" && vars.RemoteHost != "" && vars.Port > 0 && vars.RemoteUser != "" {
+				h := sha1.Sum([]byte(vars.LocalHost + vars.RemoteHost + strconv.Itoa(vars.Port) + vars.RemoteUser))
+				value = hex.EncodeToString(h[:])
+			}
+		default:
+			return "", fmt.Errorf("ssh: unknown token %%%c in %q", token, template)
+		}
+		if value == "" {
+			return "", fmt.Errorf("ssh: no value for token %%%c in %q", template[i], template)
+		}
+		out.WriteString(value)
+	}
+	return out.String(), nil
+}
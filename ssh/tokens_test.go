@@ -0,0 +1,56 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"testing"
+)
+
+func TestExpandTokens(t *testing.T) {
+	vars := TokenVars{
+		RemoteHost:   "server.example.com",
+		OriginalHost: "server",
+		Port:         2222,
+		RemoteUser:   "root",
+		LocalUser:    "alice",
+		LocalHost:    "laptop.example.org",
+		HomeDir:      "/home/alice",
+		UID:          "1000",
+	}
+	for _, tt := range []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{"plain", "plain", false},
+		{"", "", false},
+		{"ssh -W %h:%p bastion", "ssh -W server.example.com:2222 bastion", false},
+		{"%d/.ssh/known_hosts_%n", "/home/alice/.ssh/known_hosts_server", false},
+		{"%r@%h as %u (%i) on %L", "root@server.example.com as alice (1000) on laptop", false},
+		{"%k", "server", false},
+		{"100%%", "100%", false},
+		{"%%h", "%h", false},
+		// sha1("laptop.example.orgserver.example.com2222root")
+		{"%C", "c0dbea37d7a206022400dc6332090d7595eb42f4", false},
+		{"%x", "", true},
+		{"trailing %", "", true},
+	} {
+		got, err := ExpandTokens(tt.template, vars)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ExpandTokens(%q): got error %v, want error: %v", tt.template, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ExpandTokens(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+
+	// Tokens without a value are errors.
+	for _, template := range []string{"%h", "%p", "%C"} {
+		if _, err := ExpandTokens(template, TokenVars{}); err == nil {
+			t.Errorf("ExpandTokens(%q) succeeded without values", template)
+		}
+	}
+}
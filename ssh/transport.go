@@ -6,9 +6,12 @@ package ssh
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"sync"
 )
 
 // debugTransport if set, will print packet types as they go over the
@@ -16,9 +19,11 @@ import (
 const debugTransport = false
 
 const (
-	gcmCipherID    = "aes128-gcm@openssh.com"
-	aes128cbcID    = "aes128-cbc"
-	tripledescbcID = "3des-cbc"
+	gcmCipherID        = "aes128-gcm@openssh.com"
+	gcm256CipherID     = "aes256-gcm@openssh.com"
+	chacha20Poly1305ID = "chacha20-poly1305@openssh.com"
+	aes128cbcID        = "aes128-cbc"
+	tripledescbcID     = "3des-cbc"
 )
 
 // packetConn represents a transport that implements packet based
@@ -47,6 +52,49 @@ type transport struct {
 	rand      io.Reader
 	isClient  bool
 	io.Closer
+
+	// sent remembers recent requests, so that SSH_MSG_UNIMPLEMENTED
+	// replies can be traced back to them.
+	sent sentRequests
+}
+
+// sentRequestsSize is the number of recent requests that are
+// remembered.
+const sentRequestsSize = 32
+
+// sentRequests remembers the last few global requests, channel opens
+// and channel requests that were sent, by sequence number.
+type sentRequests struct {
+	mu      sync.Mutex
+	seqNums [sentRequestsSize]uint32
+	packets [sentRequestsSize][]byte
+	next    int
+}
+
+func (s *sentRequests) record(seqNum uint32, packet []byte) {
+	switch packet[0] {
+	case msgGlobalRequest, msgChannelOpen, msgChannelRequest:
+	default:
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seqNums[s.next] = seqNum
+	s.packets[s.next] = append(s.packets[s.next][:0], packet...)
+	s.next = (s.next + 1) % sentRequestsSize
+}
+
+// lookup returns a copy of the request sent with seqNum, or nil if it
+// is not remembered.
+func (s *sentRequests) lookup(seqNum uint32) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, n := range s.seqNums {
+		if n == seqNum && s.packets[i] != nil {
+			return append([]byte(nil), s.packets[i]...)
+		}
+	}
+	return nil
 }
 
 // packetCipher represents a combination of SSH encryption/MAC
@@ -60,6 +108,10 @@ type packetCipher interface {
 	// returned packet may be overwritten by future calls of
 	// readPacket.
 	readPacket(seqnum uint32, r io.Reader) ([]byte, error)
+
+	// setMaxPacket sets the largest packet length that readPacket
+	// accepts.
+	setMaxPacket(n uint32)
 }
 
 // connectionState represents one side (read or write) of the
@@ -70,6 +122,56 @@ type connectionState struct {
 	seqNum           uint32
 	dir              direction
 	pendingKeyChange chan packetCipher
+
+	// maxPacket, if non-zero, is the largest packet length accepted
+	// in this direction. It is passed on to each new packetCipher.
+	maxPacket uint32
+
+	// trace, if non-nil, is called with the message type and
+	// payload length of every packet in this direction.
+	trace func(msgType byte, length int)
+}
+
+// Direction indicates whether a packet was sent or received. It is
+// passed to Config.PacketTraceCallback.
+type Direction int
+
+const (
+	DirectionSent Direction = iota
+	DirectionReceived
+)
+
+// String converts the direction to human readable form.
+func (d Direction) String() string {
+	switch d {
+	case DirectionSent:
+		return "sent"
+	case DirectionReceived:
+		return "received"
+	}
+	return fmt.Sprintf("unknown direction %d", int(d))
+}
+
+// setPacketTrace arranges for cb to be called for every packet that
+// goes over the transport. Packet contents are never passed to cb.
+func (t *transport) setPacketTrace(cb func(dir Direction, msgType byte, length int)) {
+	if cb == nil {
+		return
+	}
+	t.reader.trace = func(msgType byte, length int) {
+		cb(DirectionReceived, msgType, length)
+	}
+	t.writer.trace = func(msgType byte, length int) {
+		cb(DirectionSent, msgType, length)
+	}
+}
+
+// setMaxPacket limits the length of the packets that the transport
+// reads to n bytes. Longer packets are rejected before anything is
+// allocated for them.
+func (t *transport) setMaxPacket(n uint32) {
+	t.reader.maxPacket = n
+	t.reader.packetCipher.setMaxPacket(n)
 }
 
 // prepareKeyChange sets up key material for a keychange. The key changes in
@@ -132,11 +234,18 @@ func (s *connectionState) readPacket(r *bufio.Reader) ([]byte, error) {
 		err = errors.New("ssh: zero length packet")
 	}
 
+	if s.trace != nil && len(packet) > 0 {
+		s.trace(packet[0], len(packet))
+	}
+
 	if len(packet) > 0 {
 		switch packet[0] {
 		case msgNewKeys:
 			select {
 			case cipher := <-s.pendingKeyChange:
+				if s.maxPacket != 0 {
+					cipher.setMaxPacket(s.maxPacket)
+				}
 				s.packetCipher = cipher
 			default:
 				return nil, errors.New("ssh: got bogus newkeys message.")
@@ -148,7 +257,7 @@ func (s *connectionState) readPacket(r *bufio.Reader) ([]byte, error) {
 			// we interpret message types, doing it here
 			// ensures that we don't have to handle it
 			// elsewhere.
-			var msg disconnectMsg
+			var msg DisconnectError
 			if err := Unmarshal(packet, &msg); err != nil {
 				return nil, err
 			}
@@ -168,12 +277,27 @@ func (t *transport) writePacket(packet []byte) error {
 	if debugTransport {
 		t.printPacket(packet, true)
 	}
+	// The packet is scrambled by the cipher, so record it first.
+	if len(packet) > 0 {
+		t.sent.record(t.writer.seqNum, packet)
+	}
 	return t.writer.writePacket(t.bufWriter, t.rand, packet)
 }
 
+// sentRequest returns the request that was sent with seqNum, if it is
+// among the recent ones.
+func (t *transport) sentRequest(seqNum uint32) []byte {
+	return t.sent.lookup(seqNum)
+}
+
 func (s *connectionState) writePacket(w *bufio.Writer, rand io.Reader, packet []byte) error {
 	changeKeys := len(packet) > 0 && packet[0] == msgNewKeys
 
+	// The cipher scrambles the packet, so trace it beforehand.
+	if s.trace != nil && len(packet) > 0 {
+		s.trace(packet[0], len(packet))
+	}
+
 	err := s.packetCipher.writePacket(s.seqNum, w, rand, packet)
 	if err != nil {
 		return err
@@ -253,10 +377,14 @@ func generateKeys(d direction, algs directionAlgorithms, kex *kexResult) (iv, ke
 func newPacketCipher(d direction, algs directionAlgorithms, kex *kexResult) (packetCipher, error) {
 	iv, key, macKey := generateKeys(d, algs, kex)
 
-	if algs.Cipher == gcmCipherID {
+	if algs.Cipher == gcmCipherID || algs.Cipher == gcm256CipherID {
 		return newGCMCipher(iv, key, macKey)
 	}
 
+	if algs.Cipher == chacha20Poly1305ID {
+		return newChaCha20Cipher(key)
+	}
+
 	if algs.Cipher == aes128cbcID {
 		return newAESCBCCipher(iv, key, macKey, algs)
 	}
@@ -311,11 +439,10 @@ const packageVersion = "SSH-2.0-Go"
 
 // Sends and receives a version line.  The versionLine string should
 // be US ASCII, start with "SSH-2.0-", and should not include a
-// newline. exchangeVersions returns the other side's version line.
-func exchangeVersions(rw io.ReadWriter, versionLine []byte) (them []byte, err error) {
-	// Contrary to the RFC, we do not ignore lines that don't
-	// start with "SSH-2.0-" to make the library usable with
-	// nonconforming servers.
+// newline. If writeVersion is non-nil, it writes the line instead of
+// the default. A client skips the lines the server may send before its
+// version line. exchangeVersions returns the other side's version line.
+func exchangeVersions(rw io.ReadWriter, versionLine []byte, writeVersion func(w io.Writer, version string) error, isClient bool) (them []byte, err error) {
 	for _, c := range versionLine {
 		// The spec disallows non US-ASCII chars, and
 		// specifically forbids null chars.
@@ -323,14 +450,34 @@ func exchangeVersions(rw io.ReadWriter, versionLine []byte) (them []byte, err er
 			return nil, errors.New("ssh: junk character in version line")
 		}
 	}
-	if _, err = rw.Write(append(versionLine, '\r', '\n')); err != nil {
+	if writeVersion != nil {
+		err = writeVersion(rw, string(versionLine))
+	} else {
+		_, err = rw.Write(append(versionLine, '\r', '\n'))
+	}
+	if err != nil {
 		return
 	}
 
-	them, err = readVersion(rw)
-	return them, err
+	// RFC 4253, section 4.2 lets servers send other lines before
+	// the version line, which is the first line starting with
+	// "SSH-". Contrary to the RFC, we do not insist on "SSH-2.0-",
+	// to make the library usable with nonconforming servers.
+	for lines := 0; ; lines++ {
+		them, err = readVersion(rw)
+		if err != nil || !isClient || bytes.HasPrefix(them, []byte("SSH-")) {
+			return them, err
+		}
+		if lines >= maxPreVersionLines {
+			return nil, errors.New("ssh: too many lines before the version string")
+		}
+	}
 }
 
+// maxPreVersionLines is the maximum number of lines a client accepts
+// before the server's version line, like OpenSSH.
+const maxPreVersionLines = 1024
+
 // maxVersionStringBytes is the maximum number of bytes that we'll
 // accept as a version string. RFC 4253 section 4.2 limits this at 255
 // chars
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/binary"
+	"io"
 	"strings"
 	"testing"
 )
@@ -47,7 +48,7 @@ func TestReadVersionError(t *testing.T) {
 func TestExchangeVersionsBasic(t *testing.T) {
 	v := "SSH-2.0-bla"
 	buf := bytes.NewBufferString(v + "\r\n")
-	them, err := exchangeVersions(buf, []byte("xyz"))
+	them, err := exchangeVersions(buf, []byte("xyz"), nil, true)
 	if err != nil {
 		t.Errorf("exchangeVersions: %v", err)
 	}
@@ -64,12 +65,74 @@ func TestExchangeVersions(t *testing.T) {
 	}
 	for _, c := range cases {
 		buf := bytes.NewBufferString("SSH-2.0-bla\r\n")
-		if _, err := exchangeVersions(buf, []byte(c)); err == nil {
+		if _, err := exchangeVersions(buf, []byte(c), nil, true); err == nil {
 			t.Errorf("exchangeVersions(%q): should have failed", c)
 		}
 	}
 }
 
+func TestExchangeVersionsPreVersionLines(t *testing.T) {
+	in := "Welcome\r\nremote: SSH-like\n\r\nSSH-2.0-bla\r\n"
+	them, err := exchangeVersions(bytes.NewBufferString(in), []byte("SSH-2.0-xyz"), nil, true)
+	if err != nil {
+		t.Fatalf("exchangeVersions: %v", err)
+	}
+	if want := "SSH-2.0-bla"; string(them) != want {
+		t.Errorf("got %q, want %q", them, want)
+	}
+
+	// Servers do not skip lines.
+	buf := bytes.NewBufferString("Hello\r\nSSH-2.0-bla\r\n")
+	if them, err := exchangeVersions(buf, []byte("SSH-2.0-xyz"), nil, false); err != nil || string(them) != "Hello" {
+		t.Errorf("got %q, %v, want the first line", them, err)
+	}
+
+	buf = bytes.NewBufferString(strings.Repeat("comment\r\n", maxPreVersionLines+1) + "SSH-2.0-bla\r\n")
+	if _, err := exchangeVersions(buf, []byte("SSH-2.0-xyz"), nil, true); err == nil {
+		t.Error("exchangeVersions accepted too many lines before the version")
+	}
+}
+
+func TestVersionExchangeWriter(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+
+	serverConf := &ServerConfig{
+		NoClientAuth: true,
+	}
+	serverConf.VersionExchangeWriter = func(w io.Writer, version string) error {
+		_, err := io.WriteString(w, "This system is for authorized use only.\r\n"+version+"\r\n")
+		return err
+	}
+	serverConf.AddHostKey(testSigners["ecdsa"])
+	go NewServerConn(c1, serverConf)
+
+	var written string
+	clientConf := &ClientConfig{
+		User:            "user",
+		HostKeyCallback: InsecureIgnoreHostKey(),
+	}
+	clientConf.VersionExchangeWriter = func(w io.Writer, version string) error {
+		written = version
+		_, err := io.WriteString(w, version+"\r\n")
+		return err
+	}
+	conn, _, _, err := NewClientConn(c2, "", clientConf)
+	if err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+	if written != packageVersion {
+		t.Errorf("VersionExchangeWriter got version %q, want %q", written, packageVersion)
+	}
+	if got := string(conn.ServerVersion()); got != packageVersion {
+		t.Errorf("got server version %q, want %q", got, packageVersion)
+	}
+}
+
 type closerBuffer struct {
 	bytes.Buffer
 }
@@ -107,3 +170,40 @@ func TestTransportMaxPacketReader(t *testing.T) {
 		t.Errorf("got %q, should mention %q", err.Error(), "large")
 	}
 }
+
+func TestTransportMaxPacketLength(t *testing.T) {
+	var header [5]byte
+	binary.BigEndian.PutUint32(header[0:], 40000)
+
+	buf := &closerBuffer{}
+	buf.Write(header[:])
+	buf.Write(make([]byte, 40000))
+
+	tr := newTransport(buf, rand.Reader, true)
+	tr.setMaxPacket(minPacketLimit)
+	_, err := tr.readPacket()
+	if err == nil || !strings.Contains(err.Error(), "large") {
+		t.Errorf("got %v, want an error about a large packet", err)
+	}
+	// The buffer for the packet is allocated only after the check.
+	if c := tr.reader.packetCipher.(*streamPacketCipher); cap(c.packetData) >= 40000 {
+		t.Errorf("allocated %d bytes for a rejected packet", cap(c.packetData))
+	}
+}
+
+func TestConfigMaxPacketLength(t *testing.T) {
+	for _, tt := range []struct {
+		in, want uint32
+	}{
+		{0, maxPacket},
+		{100, minPacketLimit},
+		{1 << 20, 1 << 20},
+		{1 << 30, maxPacketLimit},
+	} {
+		c := &Config{MaxPacketLength: tt.in}
+		c.SetDefaults()
+		if c.MaxPacketLength != tt.want {
+			t.Errorf("MaxPacketLength %d: got %d after SetDefaults, want %d", tt.in, c.MaxPacketLength, tt.want)
+		}
+	}
+}